@@ -0,0 +1,123 @@
+package authentication
+
+import (
+	"time"
+)
+
+type AccountStatus string
+
+const (
+	StatusActive AccountStatus = "active"
+	//StatusDisabled отключен по просьбе владельца или администратором
+	StatusDisabled AccountStatus = "disabled"
+	//StatusSuspended временная блокировка, обычно с Until
+	StatusSuspended AccountStatus = "suspended"
+	StatusBanned    AccountStatus = "banned"
+	//StatusPendingDeletion профиль удален и ждет окончательного удаления в Until
+	StatusPendingDeletion AccountStatus = "pending_deletion"
+	//StatusAnonymized персональные данные профиля стерты, строка сохранена ради ссылок на ProfileID
+	StatusAnonymized AccountStatus = "anonymized"
+)
+
+// ProfileStatus нулевой Until - статус действует бессрочно. По истечении Until профиль снова активен,
+// кроме StatusPendingDeletion и StatusAnonymized
+type ProfileStatus struct {
+	Status    AccountStatus
+	Reason    string
+	Until     time.Time
+	ChangedAt time.Time
+}
+
+// active удаленный профиль не становится активным по истечении Until
+func (s *ProfileStatus) active(now time.Time) bool {
+	if s.Status == StatusPendingDeletion || s.Status == StatusAnonymized {
+		return false
+	}
+	return s.Status == "" || s.Status == StatusActive || (!s.Until.IsZero() && !now.Before(s.Until))
+}
+
+// AccountStatusError возвращают Authentication, ReadToken и ProfileByID для неактивного профиля.
+// errors.Is различает ErrAccountDisabled, ErrAccountSuspended, ErrAccountBanned, ErrAccountPendingDeletion
+// и ErrAccountAnonymized
+type AccountStatusError struct {
+	Status AccountStatus
+	Reason string
+	Until  time.Time
+}
+
+func (e *AccountStatusError) Error() string {
+	return e.Unwrap().Error()
+}
+
+func (e *AccountStatusError) Unwrap() error {
+	switch e.Status {
+	case StatusSuspended:
+		return ErrAccountSuspended
+	case StatusBanned:
+		return ErrAccountBanned
+	case StatusPendingDeletion:
+		return ErrAccountPendingDeletion
+	case StatusAnonymized:
+		return ErrAccountAnonymized
+	default:
+		return ErrAccountDisabled
+	}
+}
+
+func (a *Auth) checkStatus(profileID ProfileID) error {
+	status, err := a.st.GetProfileStatus(profileID)
+	if err != nil {
+		return err
+	}
+	if status.active(time.Now()) {
+		return nil
+	}
+	return &AccountStatusError{Status: status.Status, Reason: status.Reason, Until: status.Until}
+}
+
+// ProfileStatus возвращает статус профиля, в том числе истекший
+func (a *Auth) ProfileStatus(profileID ProfileID) (*ProfileStatus, error) {
+	return a.st.GetProfileStatus(profileID)
+}
+
+// SetProfileStatus меняет статус профиля. Любой статус кроме StatusActive отзывает все токены профиля.
+// Удаленный профиль сначала восстанавливается через RestoreProfile, анонимизированный не меняется:
+// для них возвращается AccountStatusError текущего статуса
+func (a *Auth) SetProfileStatus(profileID ProfileID, status AccountStatus, reason string, until time.Time) error {
+	switch status {
+	case StatusActive, StatusDisabled, StatusSuspended, StatusBanned:
+	default:
+		return ErrAccountStatusInvalid
+	}
+
+	current, err := a.st.GetProfileStatus(profileID)
+	if err != nil {
+		return err
+	}
+	if current.Status == StatusPendingDeletion || current.Status == StatusAnonymized {
+		return &AccountStatusError{Status: current.Status, Reason: current.Reason, Until: current.Until}
+	}
+
+	err = a.st.SetProfileStatus(profileID, &ProfileStatus{
+		Status:    status,
+		Reason:    reason,
+		Until:     until,
+		ChangedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if status != StatusActive {
+		if err := a.st.DelTokensByProfileID(profileID); err != nil {
+			return err
+		}
+	}
+
+	data := map[string]string{"status": string(status), "reason": reason}
+	if !until.IsZero() {
+		data["until"] = until.UTC().Format(time.RFC3339)
+	}
+	a.emit(EventStatusChanged, profileID, data)
+	return nil
+}
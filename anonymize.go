@@ -0,0 +1,84 @@
+package authentication
+
+import (
+	"github.com/google/uuid"
+)
+
+// anonymizedEmailDomain зарезервированный домен (RFC 2606), письма на него не доставляются
+const anonymizedEmailDomain = "anonymized.invalid"
+
+// AuditScrubber стирает персональные данные из записей аудита профиля, сохраняя
+// сами записи и их ProfileID, например drivers.GormAuditSink. Записи с ProfileID 0,
+// в Data которых login или email профиля (неверный логин, блокировка), тоже стираются
+type AuditScrubber interface {
+	ScrubProfile(profileID ProfileID, login, email string) error
+}
+
+type AuditScrubberFunc func(profileID ProfileID, login, email string) error
+
+func (f AuditScrubberFunc) ScrubProfile(profileID ProfileID, login, email string) error {
+	return f(profileID, login, email)
+}
+
+// MultiAuditScrubber стирает данные во всех хранилищах аудита и возвращает первую ошибку
+func MultiAuditScrubber(scrubbers ...AuditScrubber) AuditScrubber {
+	return AuditScrubberFunc(func(profileID ProfileID, login, email string) error {
+		var first error
+		for _, s := range scrubbers {
+			if err := s.ScrubProfile(profileID, login, email); err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	})
+}
+
+// AuditEventOf относится ли запись аудита к профилю с логином login и E-MAIL email.
+// Для реализаций AuditScrubber
+func AuditEventOf(event *Event, profileID ProfileID, login, email string) bool {
+	if event.ProfileID != 0 {
+		return event.ProfileID == profileID
+	}
+	for _, key := range []string{"login", "email"} {
+		value := event.Data[key]
+		if value == "" {
+			continue
+		}
+		//входить можно и по E-MAIL, поэтому значение сравнивается с обоими
+		if CanonicalLogin(value) == CanonicalLogin(login) || CanonicalEmail(value) == CanonicalEmail(email) {
+			return true
+		}
+	}
+	return false
+}
+
+// AnonymizeProfile стирает персональные данные профиля вместо удаления: логин и E-MAIL
+// заменяются случайными заглушками, пароль и секреты удаляются, токены отзываются.
+// ID профиля остается, поэтому ссылки на него из таблиц приложения не нарушаются.
+// Профиль переходит в StatusAnonymized, войти в него больше нельзя
+func (a *Auth) AnonymizeProfile(profileID ProfileID) error {
+	login, err := a.st.GetLogin(profileID)
+	if err != nil {
+		return err
+	}
+	email, err := a.st.GetEmail(profileID)
+	if err != nil {
+		return err
+	}
+
+	placeholder := uuid.New().String()
+	if err := a.st.AnonymizeProfile(profileID, "anonymized-"+placeholder, placeholder+"@"+anonymizedEmailDomain); err != nil {
+		return err
+	}
+
+	if err := a.bruteForce.reset(login); err != nil {
+		return err
+	}
+	if a.auditScrubber != nil {
+		if err := a.auditScrubber.ScrubProfile(profileID, login, email); err != nil {
+			return err
+		}
+	}
+	a.emit(EventProfileAnonymized, profileID, nil)
+	return nil
+}
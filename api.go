@@ -14,11 +14,119 @@ type ProfileID int64
 type TokenID string
 type TokenLifeTime int64
 
+// EmailKeyPurpose назначение ключа из письма. Ключ гасится только вызовом с тем же назначением:
+// ключ восстановления пароля не подтверждает E-MAIL и не входит по ссылке
+type EmailKeyPurpose string
+
+const (
+	EmailKeyRecovery     EmailKeyPurpose = "recovery"
+	EmailKeyVerification EmailKeyPurpose = "verification"
+	EmailKeyChangeEmail  EmailKeyPurpose = "change_email"
+	EmailKeyMagicLink    EmailKeyPurpose = "magic_link"
+)
+
 type AuthConfig struct {
 	DriverStorage       DriverStorage
 	EmailLifeTimeSecond int64
 	ProfilePasswordSalt []byte
 	TokenSecretKey      []byte
+
+	//EmailVerificationRequired запрещает аутентификацию профилей с неподтвержденным E-MAIL
+	EmailVerificationRequired bool
+
+	//EmailCodeDigits включает выдачу цифровых кодов (6-8 цифр) вместо UUID ключей
+	//в ForgotPassword и Profile.ChangeEmail. 0 - коды выключены
+	EmailCodeDigits int
+	//EmailCodeMaxAttempts количество неверных попыток, после которых код сжигается, по умолчанию 5
+	EmailCodeMaxAttempts int
+
+	//EmailChangeUndoLifeTimeSecond время, в течение которого можно отменить смену E-MAIL, по умолчанию 7 дней
+	EmailChangeUndoLifeTimeSecond int64
+
+	//MagicLinkLifeTimeSecond время жизни ключа входа без пароля, по умолчанию 15 минут
+	MagicLinkLifeTimeSecond int64
+	//MagicLinkAutoRegistration создает профиль при первом входе по ссылке
+	MagicLinkAutoRegistration bool
+
+	//TOTPIssuer отображается в приложении-аутентификаторе
+	TOTPIssuer string
+	//TOTPEncryptionKey ключ шифрования TOTP секретов в базе данных, по умолчанию TokenSecretKey
+	TOTPEncryptionKey []byte
+	//MFAChallengeLifeTimeSecond время на ввод второго фактора, по умолчанию 5 минут
+	MFAChallengeLifeTimeSecond int64
+	//MFAMaxAttempts количество неверных кодов второго фактора, по умолчанию 5
+	MFAMaxAttempts int
+	//MFAEmailCodeLifeTimeSecond время жизни кода второго фактора из письма, по умолчанию 10 минут
+	MFAEmailCodeLifeTimeSecond int64
+	//MFAEmailResendIntervalSecond минимальный интервал между письмами с кодом, по умолчанию 60 секунд
+	MFAEmailResendIntervalSecond int64
+	//MFAEmailCodeMaxAttempts количество неверных попыток, после которых код сгорает, по умолчанию 3
+	MFAEmailCodeMaxAttempts int
+
+	//AttemptStore включает защиту Authentication от перебора паролей, nil - выключено
+	AttemptStore AttemptStore
+	//LoginAttemptPolicy пороги неудачных попыток для одного логина
+	LoginAttemptPolicy AttemptPolicy
+	//IPAttemptPolicy пороги неудачных попыток для одного IP из RequestMeta
+	IPAttemptPolicy AttemptPolicy
+
+	//NewDeviceDetection запоминает устройства и сети, с которых входит профиль,
+	//и сообщает письмом о входе с нового устройства. Нужен RequestMeta через WithMeta
+	NewDeviceDetection bool
+	//NewDeviceConfirmation требует подтвердить вход с нового устройства по ссылке из письма
+	NewDeviceConfirmation bool
+
+	//ChallengeVerifier включает CAPTCHA в Registration, Authentication и ForgotPassword
+	//при срабатывании ChallengePolicy, nil - выключено
+	ChallengeVerifier ChallengeVerifier
+	//ChallengePolicy по умолчанию DefaultChallengePolicy
+	ChallengePolicy ChallengePolicy
+
+	//ProofOfWorkDifficulty требует решенную задачу NewProofOfWork перед Registration
+	//и ForgotPassword, число нулевых бит хеша. 0 - выключено
+	ProofOfWorkDifficulty int
+	//ProofOfWorkMaxDifficulty предел роста сложности под нагрузкой, по умолчанию +8 бит
+	ProofOfWorkMaxDifficulty int
+	//ProofOfWorkRatePerMinute частота принятых решений, сверх которой сложность растет, по умолчанию 60
+	ProofOfWorkRatePerMinute int
+	//ProofOfWorkLifeTimeSecond время на решение задачи, по умолчанию 5 минут
+	ProofOfWorkLifeTimeSecond int64
+
+	//EventSubscriber получает события аудита, nil - события не формируются
+	EventSubscriber EventSubscriber
+	//EventErrorHandler получает ошибки подписчика и поиска профиля для события, операции они не прерывают
+	EventErrorHandler func(event *Event, err error)
+
+	//WebAuthn включает вход по ключам доступа (passkeys), nil - выключено
+	WebAuthn *WebAuthnConfig
+
+	//LoginConfusableCheck отклоняет при регистрации логины, похожие на существующие (LoginSkeleton)
+	LoginConfusableCheck bool
+
+	//DeletionGracePeriodSecond время, в течение которого удаленный профиль можно восстановить
+	//через RestoreProfile. Затем его удаляет PurgeDeletedProfiles. 0 - профиль удаляется сразу
+	DeletionGracePeriodSecond int64
+
+	//AuditEventReader добавляет события аудита в Profile.Export, nil - без событий
+	AuditEventReader EventReader
+	//ExportHooks разделы приложения в Profile.Export по именам
+	ExportHooks map[string]ExportHook
+	//AttrSchema реестр атрибутов профиля, nil - атрибуты отклоняются с ErrAttrUnknown
+	AttrSchema *AttrSchema
+
+	//RBACCacheSecond как долго хранится граф ролей, по умолчанию 60 секунд.
+	//Роли, измененные в другом процессе, применяются с этой задержкой
+	RBACCacheSecond int64
+
+	//AuditScrubber стирает персональные данные из аудита в AnonymizeProfile, nil - аудит не меняется
+	AuditScrubber AuditScrubber
+
+	//Mailer необязателен, без него секретные ключи доставляет вызывающая сторона
+	Mailer            Mailer
+	MailLinks         LinkBuilder
+	MailTemplates     MailTemplates
+	MailDefaultLocale string
+	MailLocale        func(email string) string
 }
 
 func NewAuth(cfg AuthConfig) *Auth {
@@ -26,17 +134,80 @@ func NewAuth(cfg AuthConfig) *Auth {
 	passwordHasher.s = sync.RWMutex{}
 	passwordHasher.bs = cfg.ProfilePasswordSalt
 
+	mail := newMailSender(cfg)
+	codes := newEmailCodes(cfg)
+	totp := newTOTP(cfg)
+	recoveryCodes := newRecoveryCodes(cfg)
+	webAuthn := newWebAuthn(cfg)
+	mfaEmail := newMFAEmail(cfg, mail)
+	events := newEvents(cfg)
+
+	st := canonicalDriverStorage(cfg.DriverStorage)
+
 	tokConfig := &profileConfig{
-		st:             singleflightDriverStorage(cfg.DriverStorage),
+		st:             singleflightDriverStorage(st),
 		passwordHasher: passwordHasher,
 		emailLifeTime:  cfg.EmailLifeTimeSecond,
+		mail:           mail,
+		codes:          codes,
+		totp:           totp,
+		recoveryCodes:  recoveryCodes,
+		webAuthn:       webAuthn,
+		mfaEmail:       mfaEmail,
+		events:         events,
+
+		deletionGracePeriod: cfg.DeletionGracePeriodSecond,
+		auditEvents:         cfg.AuditEventReader,
+		exportHooks:         cfg.ExportHooks,
+		attrs:               cfg.AttrSchema,
+	}
+	tokConfig.rbac = newRBAC(cfg, st)
+
+	undoLifeTime := cfg.EmailChangeUndoLifeTimeSecond
+	if undoLifeTime <= 0 {
+		undoLifeTime = defaultEmailChangeUndoLifeTime
+	}
+
+	magicLinkLifeTime := cfg.MagicLinkLifeTimeSecond
+	if magicLinkLifeTime <= 0 {
+		magicLinkLifeTime = defaultMagicLinkLifeTime
+	}
+
+	mfaChallengeLifeTime := cfg.MFAChallengeLifeTimeSecond
+	if mfaChallengeLifeTime <= 0 {
+		mfaChallengeLifeTime = defaultMFAChallengeLifeTime
+	}
+	mfaMaxAttempts := cfg.MFAMaxAttempts
+	if mfaMaxAttempts <= 0 {
+		mfaMaxAttempts = defaultMFAMaxAttempts
 	}
 
 	return &Auth{
-		st:                  cfg.DriverStorage,
+		st:                  st,
 		emailLifeTimeSecond: cfg.EmailLifeTimeSecond,
 		tokenSecretKey:      cfg.TokenSecretKey,
 
+		emailVerificationRequired: cfg.EmailVerificationRequired,
+		mail:                      mail,
+		codes:                     codes,
+		emailChangeUndoLifeTime:   undoLifeTime,
+		magicLinkLifeTime:         magicLinkLifeTime,
+		magicLinkAutoRegistration: cfg.MagicLinkAutoRegistration,
+		totp:                      totp,
+		recoveryCodes:             recoveryCodes,
+		mfaChallengeLifeTime:      mfaChallengeLifeTime,
+		mfaMaxAttempts:            mfaMaxAttempts,
+		webAuthn:                  webAuthn,
+		mfaEmail:                  mfaEmail,
+		bruteForce:                newBruteForce(cfg),
+		events:                    events,
+		newDeviceDetection:        cfg.NewDeviceDetection || cfg.NewDeviceConfirmation,
+		newDeviceConfirmation:     cfg.NewDeviceConfirmation,
+		challenge:                 newChallenge(cfg),
+		proofOfWork:               newProofOfWork(cfg),
+		loginConfusableCheck:      cfg.LoginConfusableCheck,
+		auditScrubber:             cfg.AuditScrubber,
+
 		tokenConfig:         tokConfig,
 		profilePasswordSalt: passwordHasher,
 	}
@@ -47,11 +218,62 @@ type Auth struct {
 	emailLifeTimeSecond int64
 	tokenSecretKey      []byte
 
+	emailVerificationRequired bool
+	mail                      *mailSender
+	codes                     *emailCodes
+	emailChangeUndoLifeTime   int64
+	magicLinkLifeTime         int64
+	magicLinkAutoRegistration bool
+	totp                      *totp
+	recoveryCodes             *recoveryCodes
+	mfaChallengeLifeTime      int64
+	mfaMaxAttempts            int
+	webAuthn                  *webAuthn
+	mfaEmail                  *mfaEmail
+	bruteForce                *bruteForce
+	events                    *events
+	newDeviceDetection        bool
+	newDeviceConfirmation     bool
+	challenge                 *challenge
+	proofOfWork               *proofOfWork
+	loginConfusableCheck      bool
+	auditScrubber             AuditScrubber
+	meta                      RequestMeta
+
 	tokenConfig         *profileConfig
 	profilePasswordSalt *passwordHasher
 }
 
+// Registration при заданном Mailer или EmailVerificationRequired выдает ключ подтверждения E-MAIL.
+// Без Mailer ключ доставляет вызывающая сторона: его возвращают RegistrationWithVerification
+// и ResendVerification
 func (a *Auth) Registration(login, email, password string) (*Profile, error) {
+	if a.mail.enabled() || a.emailVerificationRequired {
+		prof, _, err := a.RegistrationWithVerification(login, email, password)
+		return prof, err
+	}
+	return a.registration(login, email, password)
+}
+
+// RegistrationWithVerification регистрирует профиль и выдает секретный ключ для подтверждения E-MAIL.
+// Если ключ не выдан или письмо не ушло, профиль уже создан и возвращается вместе с ошибкой
+func (a *Auth) RegistrationWithVerification(login, email, password string) (*Profile, EmailSecretKey, error) {
+	prof, err := a.registration(login, email, password)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret, err := a.newVerificationKey(email)
+	return prof, secret, err
+}
+
+func (a *Auth) registration(login, email, password string) (*Profile, error) {
+	if err := a.requireProofOfWork(ChallengeRegistration); err != nil {
+		return nil, err
+	}
+	if err := a.requireChallenge(ChallengeRegistration, login, 0); err != nil {
+		return nil, err
+	}
 
 	uniqueLogin, err := a.st.IsUniqueLogin(login)
 	if err != nil {
@@ -61,6 +283,15 @@ func (a *Auth) Registration(login, email, password string) (*Profile, error) {
 	if !uniqueLogin {
 		return nil, ErrLoginNotUnique
 	}
+	if a.loginConfusableCheck {
+		uniqueLogin, err = a.st.IsUniqueLoginSkeleton(LoginSkeleton(login))
+		if err != nil {
+			return nil, err
+		}
+		if !uniqueLogin {
+			return nil, ErrLoginNotUnique
+		}
+	}
 
 	uniqueEmail, err := a.st.IsUniqueEmail(email)
 	if err != nil {
@@ -74,23 +305,110 @@ func (a *Auth) Registration(login, email, password string) (*Profile, error) {
 	if err != nil {
 		return nil, err
 	}
-	return newProfile(a.tokenConfig, profID), nil
+	a.emit(EventRegistered, profID, map[string]string{"login": login, "email": email})
+	return a.profile(profID), nil
 }
 
+// Authentication при включенном втором факторе возвращает *MFARequiredError
+// с идентификатором вызова для CompleteMFA
 func (a *Auth) Authentication(login, password string) (*Profile, error) {
+	if err := a.bruteForce.check(login, a.meta); err != nil {
+		a.emit(EventLoginBlocked, 0, map[string]string{"login": login})
+		return nil, err
+	}
+
 	res, err := a.st.GetPasswordByLogin(login)
-	if err != nil {
-		if err == ErrLoginNotFound {
-			return nil, ErrWrongLoginOrPassword
+	if err != nil && err != ErrLoginNotFound {
+		return nil, err
+	}
+	var profileID ProfileID
+	if err == nil {
+		profileID = res.ProfileID
+	}
+	if err := a.requireChallenge(ChallengeAuthentication, login, profileID); err != nil {
+		return nil, err
+	}
+	if profileID == 0 {
+		a.emit(EventLoginFailed, 0, map[string]string{"login": login})
+		return nil, a.failedLogin(login)
+	}
+
+	if a.profilePasswordSalt.Hash(res.Login, password) != res.Password {
+		a.emit(EventLoginFailed, res.ProfileID, map[string]string{"login": login})
+		return nil, a.failedLogin(login)
+	}
+	return a.completeLogin(res.ProfileID, "password")
+}
+
+// completeLogin общее продолжение всех способов входа после первого фактора: статус,
+// подтвержденный E-MAIL и второй фактор. Без второго фактора вход сразу завершается
+func (a *Auth) completeLogin(profileID ProfileID, method string) (*Profile, error) {
+	if err := a.checkStatus(profileID); err != nil {
+		return nil, err
+	}
+
+	if a.emailVerificationRequired {
+		verified, err := a.st.IsEmailVerified(profileID)
+		if err != nil {
+			return nil, err
+		}
+		if !verified {
+			return nil, ErrEmailNotVerified
 		}
+	}
+
+	if err := a.mfaChallenge(profileID, method); err != nil {
+		return nil, err
+	}
+	return a.loginSucceeded(profileID, method)
+}
+
+// loginSucceeded завершает вход после проверки всех факторов. Счетчик подбора пароля
+// сбрасывается только здесь, одного пароля для этого недостаточно
+func (a *Auth) loginSucceeded(profileID ProfileID, method string) (*Profile, error) {
+	//статус мог измениться, пока вводился второй фактор
+	if err := a.checkStatus(profileID); err != nil {
+		return nil, err
+	}
+	dev, err := a.checkDevice(profileID)
+	if err != nil {
 		return nil, err
 	}
+	return a.finishLogin(profileID, method, dev)
+}
 
-	if a.profilePasswordSalt.Hash(login, password) != res.Password {
-		return nil, ErrWrongLoginOrPassword
+// finishLogin вход принят: сброс счетчика подбора, событие и профиль, привязанный к устройству
+func (a *Auth) finishLogin(profileID ProfileID, method string, dev *KnownDevice) (*Profile, error) {
+	if a.bruteForce != nil {
+		login, err := a.st.GetLogin(profileID)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.bruteForce.reset(login); err != nil {
+			return nil, err
+		}
 	}
 
-	return newProfile(a.tokenConfig, res.ProfileID), nil
+	a.emit(EventLoginSucceeded, profileID, map[string]string{"method": method})
+	prof := a.profile(profileID)
+	if dev != nil {
+		prof.device = dev.ID
+	}
+	return prof, nil
+}
+
+// profile профиль наследует RequestMeta вызова для событий
+func (a *Auth) profile(profileID ProfileID) *Profile {
+	prof := newProfile(a.tokenConfig, profileID)
+	prof.meta = a.meta
+	return prof
+}
+
+func (a *Auth) failedLogin(login string) error {
+	if err := a.bruteForce.fail(login, a.meta); err != nil {
+		return err
+	}
+	return ErrWrongLoginOrPassword
 }
 
 func (a *Auth) ProfileByID(profileID ProfileID) (*Profile, error) {
@@ -98,38 +416,86 @@ func (a *Auth) ProfileByID(profileID ProfileID) (*Profile, error) {
 	if err != nil {
 		return nil, err
 	}
-	if ok {
-		return newProfile(a.tokenConfig, profileID), nil
-	} else {
+	if !ok {
 		return nil, ErrProfileIdNotFound
 	}
+	if err := a.checkStatus(profileID); err != nil {
+		return nil, err
+	}
+	return a.profile(profileID), nil
 }
 
+// ForgotPassword при включенных EmailCodeDigits возвращает цифровой код,
+// который принимает только RecoveryPasswordByCode. Если письмо не ушло, ключ уже выдан
+// и возвращается вместе с MailDeliveryError
 func (a *Auth) ForgotPassword(email string) (EmailSecretKey, error) {
+	if err := a.requireProofOfWork(ChallengeForgotPassword); err != nil {
+		return "", err
+	}
+	if err := a.requireChallenge(ChallengeForgotPassword, "", 0); err != nil {
+		return "", err
+	}
+
+	if a.codes.enabled() {
+		code, err := a.codes.issue(EmailKeyRecovery, email)
+		if err != nil {
+			return "", err
+		}
+		return code, a.mail.sendCode(MailRecovery, email, code)
+	}
+
 	secret := EmailSecretKey(uuid.New().String())
-	err := a.st.EmailNewSecretKey(secret, email, a.emailLifeTimeSecond)
-	return secret, err
+	err := a.st.EmailNewSecretKey(secret, EmailKeyRecovery, email, a.emailLifeTimeSecond)
+	if err != nil {
+		return "", err
+	}
+	return secret, a.mail.sendKey(MailRecovery, email, secret)
 }
 
+// RecoveryPassword MailDeliveryError означает, что пароль изменен, но уведомление не отправлено
 func (a *Auth) RecoveryPassword(key EmailSecretKey, newPassword string) error {
-	email, err := a.st.EmailReadSecretKey(key)
+	email, err := a.st.EmailConsumeSecretKey(key, EmailKeyRecovery)
+	if err != nil {
+		return err
+	}
+	login, err := a.st.GetLoginByEmail(email)
+	if err != nil {
+		return err
+	}
+	return a.setPasswordByEmail(email, login, newPassword)
+}
+
+// RecoveryPasswordByCode проверяет код раньше поиска профиля, чтобы ошибка не выдавала,
+// зарегистрирован ли E-MAIL
+func (a *Auth) RecoveryPasswordByCode(email, code, newPassword string) error {
+	err := a.codes.check(EmailKeyRecovery, email, code)
 	if err != nil {
 		return err
 	}
+
 	login, err := a.st.GetLoginByEmail(email)
 	if err != nil {
 		return err
 	}
+	return a.setPasswordByEmail(email, login, newPassword)
+}
 
-	err = a.st.EmailDeleteSecretKey(key)
+func (a *Auth) setPasswordByEmail(email, login, newPassword string) error {
+	err := a.st.SetPasswordProfileByEmail(email, a.profilePasswordSalt.Hash(login, newPassword))
 	if err != nil {
 		return err
 	}
-	return a.st.SetPasswordProfileByEmail(email, a.profilePasswordSalt.Hash(login, newPassword))
+	a.emitByEmail(EventPasswordRecovered, email, nil)
+	return a.mail.notice(email, NoticePasswordRecovered, "")
 }
 
+// AllowedChangeEmail не проверяет владение новым адресом. MailDeliveryError означает,
+// что E-MAIL изменен, но уведомление на старый адрес не отправлено.
+//
+// Deprecated: используйте Profile.RequestEmailChange и Auth.ConfirmEmailChange,
+// они подтверждают оба адреса и позволяют отменить смену
 func (a *Auth) AllowedChangeEmail(key EmailSecretKey, newEmail string) error {
-	email, err := a.st.EmailReadSecretKey(key)
+	email, err := a.st.EmailConsumeSecretKey(key, EmailKeyChangeEmail)
 	if err != nil {
 		return err
 	}
@@ -137,11 +503,73 @@ func (a *Auth) AllowedChangeEmail(key EmailSecretKey, newEmail string) error {
 	if err != nil {
 		return err
 	}
-	err = a.st.EmailDeleteSecretKey(key)
+	return a.setEmail(pid, email, newEmail)
+}
+
+// AllowedChangeEmailByCode принимает код, выданный Profile.ChangeEmail на текущий E-MAIL профиля.
+//
+// Deprecated: используйте Profile.RequestEmailChange и Auth.ConfirmEmailChange
+func (a *Auth) AllowedChangeEmailByCode(email, code, newEmail string) error {
+	err := a.codes.check(EmailKeyChangeEmail, email, code)
+	if err != nil {
+		return err
+	}
+
+	pid, err := a.st.GetProfileIDByEmail(email)
+	if err != nil {
+		return err
+	}
+	return a.setEmail(pid, email, newEmail)
+}
+
+func (a *Auth) setEmail(pid ProfileID, email, newEmail string) error {
+	err := a.st.SetEmailByProfileID(pid, newEmail)
+	if err != nil {
+		return err
+	}
+	a.emit(EventEmailChanged, pid, map[string]string{"email": email, "new_email": newEmail})
+	return a.mail.notice(email, NoticeEmailChanged, newEmail)
+}
+
+func (a *Auth) VerifyEmail(key EmailSecretKey) error {
+	email, err := a.st.EmailConsumeSecretKey(key, EmailKeyVerification)
+	if err != nil {
+		return err
+	}
+	pid, err := a.st.GetProfileIDByEmail(email)
 	if err != nil {
 		return err
 	}
-	return a.st.SetEmailByProfileID(pid, newEmail)
+	if err := a.st.SetEmailVerified(pid, true); err != nil {
+		return err
+	}
+	a.emit(EventEmailVerified, pid, map[string]string{"email": email})
+	return nil
+}
+
+func (a *Auth) ResendVerification(email string) (EmailSecretKey, error) {
+	pid, err := a.st.GetProfileIDByEmail(email)
+	if err != nil {
+		return "", err
+	}
+
+	verified, err := a.st.IsEmailVerified(pid)
+	if err != nil {
+		return "", err
+	}
+	if verified {
+		return "", ErrEmailAlreadyVerified
+	}
+	return a.newVerificationKey(email)
+}
+
+func (a *Auth) newVerificationKey(email string) (EmailSecretKey, error) {
+	secret := EmailSecretKey(uuid.New().String())
+	err := a.st.EmailNewSecretKey(secret, EmailKeyVerification, email, a.emailLifeTimeSecond)
+	if err != nil {
+		return "", err
+	}
+	return secret, a.mail.sendKey(MailVerification, email, secret)
 }
 
 type Token struct {
@@ -167,6 +595,13 @@ func (a *Auth) NewToken(prof *Profile, tokenLifeTimeSecond TokenLifeTime) (strin
 	if err := a.st.NewToken(mTok.ID, prof.ProfileID, mTok.LifeTime); err != nil {
 		return "", err
 	}
+	if prof.device != 0 {
+		if err := a.st.SetKnownDeviceToken(prof.device, mTok.ID); err != nil {
+			return "", err
+		}
+		prof.device = 0
+	}
+	a.emit(EventTokenIssued, prof.ProfileID, map[string]string{"token": string(mTok.ID)})
 
 	return base64.URLEncoding.EncodeToString(bs), nil
 }
@@ -181,8 +616,11 @@ func (a *Auth) ReadToken(publickToken string) (*Profile, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := a.checkStatus(profileID); err != nil {
+		return nil, err
+	}
 
-	return newProfile(a.tokenConfig, profileID), nil
+	return a.profile(profileID), nil
 }
 
 func (a *Auth) DelPublicToken(publickToken string, profID ProfileID) error {
@@ -190,7 +628,11 @@ func (a *Auth) DelPublicToken(publickToken string, profID ProfileID) error {
 	if err != nil {
 		return err
 	}
-	return a.st.DelToken(mTok.ID, profID)
+	if err := a.st.DelToken(mTok.ID, profID); err != nil {
+		return err
+	}
+	a.emit(EventTokenRevoked, profID, map[string]string{"token": string(mTok.ID)})
+	return nil
 }
 
 func readToken(tokenSecretKey []byte, publickToken string) (*Token, error) {
@@ -0,0 +1,286 @@
+package authentication
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type AttrType string
+
+const (
+	AttrString AttrType = "string"
+	AttrInt    AttrType = "int"
+	AttrFloat  AttrType = "float"
+	AttrBool   AttrType = "bool"
+	//AttrTime хранится в UTC с точностью до наносекунд
+	AttrTime AttrType = "time"
+)
+
+const (
+	attrMaxNameLength = 64
+	//attrMaxLength предел длины значения по умолчанию
+	attrMaxLength = 1024
+	//attrMaxIndexedLength предел длины значения индексируемого атрибута
+	attrMaxIndexedLength = 255
+)
+
+// AttrDef описание атрибута профиля. Значения приводятся к типам string, int64, float64, bool и time.Time
+type AttrDef struct {
+	Name string
+	Type AttrType
+	//Indexed разрешает поиск профилей по значению через Auth.FindProfilesByAttr
+	Indexed bool
+	//MaxLength предел длины строкового значения в символах, по умолчанию 1024, для Indexed 255
+	MaxLength int
+	//Default возвращает GetAttr, если атрибут не задан
+	Default interface{}
+	//Validate дополнительная проверка уже приведенного значения
+	Validate func(value interface{}) error
+}
+
+// AttrSchema реестр атрибутов. Атрибуты вне реестра отклоняются с ErrAttrUnknown
+type AttrSchema struct {
+	mu   sync.RWMutex
+	defs map[string]AttrDef
+}
+
+func NewAttrSchema(defs ...AttrDef) (*AttrSchema, error) {
+	s := &AttrSchema{defs: make(map[string]AttrDef, len(defs))}
+	for _, def := range defs {
+		if err := s.Register(def); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Register добавляет атрибут в реестр. Повторная регистрация имени дает ErrAttrSchemaInvalid
+func (s *AttrSchema) Register(def AttrDef) error {
+	if def.Name == "" || utf8.RuneCountInString(def.Name) > attrMaxNameLength {
+		return ErrAttrSchemaInvalid
+	}
+	switch def.Type {
+	case AttrString, AttrInt, AttrFloat, AttrBool, AttrTime:
+	default:
+		return ErrAttrSchemaInvalid
+	}
+
+	limit := attrMaxLength
+	if def.Indexed {
+		limit = attrMaxIndexedLength
+	}
+	if def.MaxLength <= 0 || def.MaxLength > limit {
+		def.MaxLength = limit
+	}
+	if def.Default != nil {
+		value, err := def.convert(def.Default)
+		if err != nil {
+			return ErrAttrSchemaInvalid
+		}
+		def.Default = value
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.defs[def.Name]; ok {
+		return ErrAttrSchemaInvalid
+	}
+	s.defs[def.Name] = def
+	return nil
+}
+
+// Lookup возвращает описание атрибута из реестра
+func (s *AttrSchema) Lookup(name string) (AttrDef, bool) {
+	if s == nil {
+		return AttrDef{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	def, ok := s.defs[name]
+	return def, ok
+}
+
+func (s *AttrSchema) lookup(name string) (AttrDef, error) {
+	def, ok := s.Lookup(name)
+	if !ok {
+		return def, ErrAttrUnknown
+	}
+	return def, nil
+}
+
+// convert приводит значение к типу атрибута
+func (def *AttrDef) convert(value interface{}) (interface{}, error) {
+	switch def.Type {
+	case AttrString:
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+	case AttrInt:
+		switch v := value.(type) {
+		case int:
+			return int64(v), nil
+		case int32:
+			return int64(v), nil
+		case int64:
+			return v, nil
+		case uint32:
+			return int64(v), nil
+		}
+	case AttrFloat:
+		switch v := value.(type) {
+		case float32:
+			return float64(v), nil
+		case float64:
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return nil, ErrAttrType
+			}
+			return v, nil
+		case int:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		}
+	case AttrBool:
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+	case AttrTime:
+		if v, ok := value.(time.Time); ok {
+			return v.UTC(), nil
+		}
+	}
+	return nil, ErrAttrType
+}
+
+// encode проверяет значение и переводит его в строку для хранения
+func (def *AttrDef) encode(value interface{}) (string, error) {
+	value, err := def.convert(value)
+	if err != nil {
+		return "", err
+	}
+
+	var raw string
+	switch v := value.(type) {
+	case string:
+		raw = v
+		if !utf8.ValidString(v) || utf8.RuneCountInString(v) > def.MaxLength {
+			return "", ErrAttrTooLong
+		}
+	case int64:
+		raw = strconv.FormatInt(v, 10)
+	case float64:
+		raw = strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		raw = strconv.FormatBool(v)
+	case time.Time:
+		raw = v.Format(time.RFC3339Nano)
+	}
+
+	if def.Validate != nil {
+		if err := def.Validate(value); err != nil {
+			return "", err
+		}
+	}
+	return raw, nil
+}
+
+func (def *AttrDef) decode(raw string) (interface{}, error) {
+	switch def.Type {
+	case AttrInt:
+		return strconv.ParseInt(raw, 10, 64)
+	case AttrFloat:
+		return strconv.ParseFloat(raw, 64)
+	case AttrBool:
+		return strconv.ParseBool(raw)
+	case AttrTime:
+		return time.Parse(time.RFC3339Nano, raw)
+	default:
+		return raw, nil
+	}
+}
+
+// GetAttr возвращает значение атрибута или его Default, если атрибут не задан
+func (t *Profile) GetAttr(name string) (interface{}, error) {
+	def, err := t.cfg.attrs.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := t.cfg.st.GetAttrs(t.ProfileID)
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := attrs[name]
+	if !ok {
+		return def.Default, nil
+	}
+	return def.decode(raw)
+}
+
+// Attrs возвращает все заданные атрибуты профиля. Атрибуты, удаленные из реестра, пропускаются
+func (t *Profile) Attrs() (map[string]interface{}, error) {
+	attrs, err := t.cfg.st.GetAttrs(t.ProfileID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]interface{}, len(attrs))
+	for name, raw := range attrs {
+		def, ok := t.cfg.attrs.Lookup(name)
+		if !ok {
+			continue
+		}
+		if res[name], err = def.decode(raw); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// SetAttr сохраняет атрибут, nil удаляет его
+func (t *Profile) SetAttr(name string, value interface{}) error {
+	return t.SetAttrs(map[string]interface{}{name: value})
+}
+
+// SetAttrs проверяет все значения и сохраняет их вместе: при ошибке ни один атрибут не меняется.
+// nil удаляет атрибут
+func (t *Profile) SetAttrs(values map[string]interface{}) error {
+	attrs := make(map[string]*ResultAttr, len(values))
+	for name, value := range values {
+		def, err := t.cfg.attrs.lookup(name)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			attrs[name] = nil
+			continue
+		}
+		raw, err := def.encode(value)
+		if err != nil {
+			return err
+		}
+		attrs[name] = &ResultAttr{Value: raw, Indexed: def.Indexed}
+	}
+	if len(attrs) == 0 {
+		return nil
+	}
+	return t.cfg.st.SetAttrs(t.ProfileID, attrs)
+}
+
+// FindProfilesByAttr ищет профили по точному значению индексируемого атрибута
+func (a *Auth) FindProfilesByAttr(name string, value interface{}, limit int) ([]ProfileID, error) {
+	def, err := a.tokenConfig.attrs.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if !def.Indexed {
+		return nil, ErrAttrNotIndexed
+	}
+	raw, err := def.encode(value)
+	if err != nil {
+		return nil, err
+	}
+	return a.st.FindProfilesByAttr(name, raw, limit)
+}
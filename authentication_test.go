@@ -1,13 +1,26 @@
 package authentication_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/coocood/freecache"
 	"github.com/v-grabko1999/authentication"
+	"github.com/v-grabko1999/authentication/captcha"
 	"github.com/v-grabko1999/authentication/drivers"
+	"github.com/v-grabko1999/authentication/mailers"
 	"github.com/v-grabko1999/cache"
 	cache_driver "github.com/v-grabko1999/cache/drivers"
 	"gorm.io/driver/sqlite"
@@ -67,8 +80,32 @@ func testLogic(dr authentication.DriverStorage, t *testing.T) {
 	testProfile(profile, auth, t)
 	testToken(profile, auth, t)
 	testForgotPassword(auth, t)
+	testMailDelivery(auth, dr, t)
 	testProfileByID(auth, t, profile.ProfileID)
 	testDeleteProfile(profile, auth, t)
+	testEmailVerification(dr, t)
+	testEmailCodes(dr, t)
+	testConsumeSecretKey(dr, t)
+	testEmailChange(dr, t)
+	testMagicLink(dr, t)
+	testTOTP(dr, t)
+	testRecoveryCodes(dr, t)
+	testPasskey(dr, t)
+	testMFAEmail(dr, t)
+	testReauthenticate(dr, t)
+	testBruteForce(dr, t)
+	testEvents(dr, t)
+	testNewDevice(dr, t)
+	testChallenge(dr, t)
+	testProofOfWork(dr, t)
+	testCanonical(dr, t)
+	testUniqueViolation(dr, t)
+	testAccountStatus(dr, t)
+	testSoftDelete(dr, t)
+	testExport(dr, t)
+	testAnonymize(dr, t)
+	testAttributes(dr, t)
+	testRBAC(dr, t)
 }
 
 const (
@@ -266,6 +303,40 @@ func testForgotPassword(auth *authentication.Auth, t *testing.T) {
 
 }
 
+// testMailDelivery сбой доставки не выдается за сбой операции
+func testMailDelivery(auth *authentication.Auth, dr authentication.DriverStorage, t *testing.T) {
+	mailer := mailers.NewMemory()
+	mailer.Fail(errors.New("smtp is down"))
+	failing := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:       dr,
+		EmailLifeTimeSecond: 60 * 60 * 24,
+		ProfilePasswordSalt: []byte("test password salt"),
+		TokenSecretKey:      []byte("token secret keu"),
+		Mailer:              mailer,
+	})
+
+	key, err := failing.ForgotPassword(changeEmail)
+	var delivery *authentication.MailDeliveryError
+	if !errors.Is(err, authentication.ErrMailNotDelivered) || !errors.As(err, &delivery) || delivery.Kind != authentication.MailRecovery || key == "" {
+		t.Fatal("ForgotPassword mail delivery error: ", err)
+	}
+	if err := failing.RecoveryPassword(key, changePassword); !errors.Is(err, authentication.ErrMailNotDelivered) {
+		t.Fatal("RecoveryPassword mail delivery error: ", err)
+	}
+	if _, err := auth.Authentication(regLogin, changePassword); err != nil {
+		t.Fatal("Authentication after RecoveryPassword without mail error: ", err)
+	}
+
+	mailer.Fail(nil)
+	key, err = failing.ForgotPassword(changeEmail)
+	if err != nil {
+		t.Fatal("ForgotPassword error: ", err)
+	}
+	if err := failing.RecoveryPassword(key, regPass); err != nil {
+		t.Fatal("RecoveryPassword error: ", err)
+	}
+}
+
 func testDeleteProfile(profile *authentication.Profile, auth *authentication.Auth, t *testing.T) {
 	err := profile.DeleteProfile("regPass")
 	if err != nil {
@@ -280,3 +351,1824 @@ func testDeleteProfile(profile *authentication.Profile, auth *authentication.Aut
 		t.Fatal("DeleteProfile error:", err)
 	}
 }
+
+func testEmailVerification(dr authentication.DriverStorage, t *testing.T) {
+	mailer := mailers.NewMemory()
+	auth := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:             dr,
+		EmailLifeTimeSecond:       60 * 60 * 24,
+		ProfilePasswordSalt:       []byte("test password salt"),
+		TokenSecretKey:            []byte("token secret keu"),
+		EmailVerificationRequired: true,
+		Mailer:                    mailer,
+		MailLinks:                 authentication.URLLinkBuilder("https://example.com/auth/"),
+	})
+
+	profile, key, err := auth.RegistrationWithVerification(regLogin, regEmail, regPass)
+	if err != nil {
+		t.Fatal("RegistrationWithVerification error: ", err)
+	}
+
+	msg, ok := mailer.Last(regEmail)
+	if !ok || msg.Kind != authentication.MailVerification {
+		t.Fatal("verification mail was not sent: ", msg)
+	}
+	if !strings.Contains(msg.Text, "https://example.com/auth/verification?key="+string(key)) {
+		t.Fatal("verification mail does not contain link: ", msg.Text)
+	}
+
+	_, err = auth.Authentication(regLogin, regPass)
+	if !errors.Is(err, authentication.ErrEmailNotVerified) {
+		t.Fatal("Authentication unverified email error: ", err)
+	}
+
+	if err := auth.VerifyEmail(key); err != nil {
+		t.Fatal("VerifyEmail error: ", err)
+	}
+
+	if err := auth.VerifyEmail(key); !errors.Is(err, authentication.ErrEmailSecretKeyNotFound) {
+		t.Fatal("VerifyEmail reuse key error: ", err)
+	}
+
+	verified, err := profile.IsEmailVerified()
+	if err != nil || !verified {
+		t.Fatal("profile.IsEmailVerified error: ", verified, err)
+	}
+
+	if _, err := auth.ResendVerification(regEmail); !errors.Is(err, authentication.ErrEmailAlreadyVerified) {
+		t.Fatal("ResendVerification verified email error: ", err)
+	}
+
+	if _, err := auth.Authentication(regLogin, regPass); err != nil {
+		t.Fatal("Authentication verified email error: ", err)
+	}
+
+	recoveryKey, err := auth.ForgotPassword(regEmail)
+	if err != nil {
+		t.Fatal("auth.ForgotPassword error: ", err)
+	}
+	if msg, _ := mailer.Last(regEmail); msg.Kind != authentication.MailRecovery || !strings.Contains(msg.HTML, string(recoveryKey)) {
+		t.Fatal("recovery mail was not sent: ", msg)
+	}
+	//ключ восстановления не подходит для других писем и не сгорает от таких попыток
+	if err := auth.VerifyEmail(recoveryKey); !errors.Is(err, authentication.ErrEmailSecretKeyNotFound) {
+		t.Fatal("VerifyEmail with recovery key error: ", err)
+	}
+	if _, _, err := auth.RedeemMagicLink(recoveryKey, 60); !errors.Is(err, authentication.ErrEmailSecretKeyNotFound) {
+		t.Fatal("RedeemMagicLink with recovery key error: ", err)
+	}
+	if err := auth.AllowedChangeEmail(recoveryKey, changeEmail); !errors.Is(err, authentication.ErrEmailSecretKeyNotFound) {
+		t.Fatal("AllowedChangeEmail with recovery key error: ", err)
+	}
+	if err := auth.RecoveryPassword(recoveryKey, regPass); err != nil {
+		t.Fatal("auth.RecoveryPassword error: ", err)
+	}
+	if msg, _ := mailer.Last(regEmail); msg.Kind != authentication.MailSecurityNotice {
+		t.Fatal("security notice was not sent: ", msg)
+	}
+
+	if err := profile.DeleteProfile(regPass); err != nil {
+		t.Fatal("DeleteProfile error:", err)
+	}
+}
+
+func testEmailCodes(dr authentication.DriverStorage, t *testing.T) {
+	auth := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:        dr,
+		EmailLifeTimeSecond:  60 * 60 * 24,
+		ProfilePasswordSalt:  []byte("test password salt"),
+		TokenSecretKey:       []byte("token secret keu"),
+		EmailCodeDigits:      6,
+		EmailCodeMaxAttempts: 3,
+	})
+
+	profile, err := auth.Registration(regLogin, regEmail, regPass)
+	if err != nil {
+		t.Fatal("error profile registration", err)
+	}
+
+	code, err := auth.ForgotPassword(regEmail)
+	if err != nil {
+		t.Fatal("auth.ForgotPassword error: ", err)
+	}
+	if len(code) != 6 {
+		t.Fatal("auth.ForgotPassword code length: ", code)
+	}
+
+	if err := auth.RecoveryPassword(code, changePassword); !errors.Is(err, authentication.ErrEmailSecretKeyNotFound) {
+		t.Fatal("RecoveryPassword with code error: ", err)
+	}
+
+	wrongCode := "000000"
+	if string(code) == wrongCode {
+		wrongCode = "111111"
+	}
+	if err := auth.RecoveryPasswordByCode(regEmail, wrongCode, changePassword); !errors.Is(err, authentication.ErrEmailCodeInvalid) {
+		t.Fatal("RecoveryPasswordByCode wrong code error: ", err)
+	}
+	//код не зависит от регистра, в котором пользователь ввел E-MAIL
+	if err := auth.RecoveryPasswordByCode(strings.ToUpper(regEmail), string(code), changePassword); err != nil {
+		t.Fatal("RecoveryPasswordByCode mixed case email error: ", err)
+	}
+	if err := auth.RecoveryPasswordByCode(regEmail, string(code), changePassword); !errors.Is(err, authentication.ErrEmailSecretKeyNotFound) {
+		t.Fatal("RecoveryPasswordByCode reuse code error: ", err)
+	}
+
+	//код сжигается после EmailCodeMaxAttempts неверных попыток
+	code, err = auth.ForgotPassword(regEmail)
+	if err != nil {
+		t.Fatal("auth.ForgotPassword error: ", err)
+	}
+	wrongCode = "000000"
+	if string(code) == wrongCode {
+		wrongCode = "111111"
+	}
+	for i := 0; i < 2; i++ {
+		if err := auth.RecoveryPasswordByCode(regEmail, wrongCode, regPass); !errors.Is(err, authentication.ErrEmailCodeInvalid) {
+			t.Fatal("RecoveryPasswordByCode wrong code error: ", err)
+		}
+	}
+	if err := auth.RecoveryPasswordByCode(regEmail, wrongCode, regPass); !errors.Is(err, authentication.ErrEmailCodeAttemptsExceeded) {
+		t.Fatal("RecoveryPasswordByCode attempts exceeded error: ", err)
+	}
+	if err := auth.RecoveryPasswordByCode(regEmail, string(code), regPass); !errors.Is(err, authentication.ErrEmailSecretKeyNotFound) {
+		t.Fatal("RecoveryPasswordByCode burned code error: ", err)
+	}
+
+	//незарегистрированный E-MAIL неотличим от неверного кода
+	if err := auth.RecoveryPasswordByCode("unknown-"+regEmail, wrongCode, regPass); !errors.Is(err, authentication.ErrEmailSecretKeyNotFound) {
+		t.Fatal("RecoveryPasswordByCode unknown email error: ", err)
+	}
+
+	//параллельные попытки не обходят EmailCodeMaxAttempts
+	if _, err = auth.ForgotPassword(regEmail); err != nil {
+		t.Fatal("auth.ForgotPassword error: ", err)
+	}
+	var wg sync.WaitGroup
+	var invalid int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := auth.RecoveryPasswordByCode(regEmail, "12345678", regPass); errors.Is(err, authentication.ErrEmailCodeInvalid) {
+				atomic.AddInt32(&invalid, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if invalid > 2 {
+		t.Fatal("RecoveryPasswordByCode parallel attempts: ", invalid)
+	}
+
+	code, err = profile.ChangeEmail(changePassword)
+	if err != nil {
+		t.Fatal("profile ChangeEmail error: ", err)
+	}
+	if err := auth.AllowedChangeEmailByCode(changeEmail, string(code), regEmail); !errors.Is(err, authentication.ErrEmailSecretKeyNotFound) {
+		t.Fatal("AllowedChangeEmailByCode foreign email error: ", err)
+	}
+	if err := auth.AllowedChangeEmailByCode(regEmail, string(code), changeEmail); err != nil {
+		t.Fatal("AllowedChangeEmailByCode error: ", err)
+	}
+	if email, _ := profile.GetEmail(); email != changeEmail {
+		t.Fatal("profile email != changeEmail: ", email)
+	}
+
+	if err := profile.DeleteProfile(changePassword); err != nil {
+		t.Fatal("DeleteProfile error:", err)
+	}
+}
+
+func testConsumeSecretKey(dr authentication.DriverStorage, t *testing.T) {
+	key := authentication.EmailSecretKey("consume-secret-key-test")
+	if err := dr.EmailNewSecretKey(key, authentication.EmailKeyRecovery, regEmail, 60); err != nil {
+		t.Fatal("EmailNewSecretKey error: ", err)
+	}
+	//прогреваем кеш ChGormDriver
+	if _, err := dr.EmailReadSecretKey(key); err != nil {
+		t.Fatal("EmailReadSecretKey error: ", err)
+	}
+	//ключ другого назначения не гасится и остается действительным
+	if _, err := dr.EmailConsumeSecretKey(key, authentication.EmailKeyMagicLink); !errors.Is(err, authentication.ErrEmailSecretKeyNotFound) {
+		t.Fatal("EmailConsumeSecretKey with other purpose error: ", err)
+	}
+
+	var wg sync.WaitGroup
+	var consumed int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			email, err := dr.EmailConsumeSecretKey(key, authentication.EmailKeyRecovery)
+			if err == nil && email == regEmail {
+				atomic.AddInt32(&consumed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if consumed != 1 {
+		t.Fatal("EmailConsumeSecretKey consumed times: ", consumed)
+	}
+	if _, err := dr.EmailReadSecretKey(key); !errors.Is(err, authentication.ErrEmailSecretKeyNotFound) {
+		t.Fatal("EmailReadSecretKey after consume error: ", err)
+	}
+}
+
+func testEmailChange(dr authentication.DriverStorage, t *testing.T) {
+	mailer := mailers.NewMemory()
+	auth := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:       dr,
+		EmailLifeTimeSecond: 60 * 60 * 24,
+		ProfilePasswordSalt: []byte("test password salt"),
+		TokenSecretKey:      []byte("token secret keu"),
+		Mailer:              mailer,
+	})
+
+	profile, err := auth.Registration(regLogin, regEmail, regPass)
+	if err != nil {
+		t.Fatal("error profile registration", err)
+	}
+
+	if _, err := profile.RequestEmailChange("regPass", changeEmail); !errors.Is(err, authentication.ErrWrongPassword) {
+		t.Fatal("RequestEmailChange wrong password error: ", err)
+	}
+
+	mailKey := func(email string, kind authentication.MailKind) authentication.EmailSecretKey {
+		msg, ok := mailer.Last(email)
+		if !ok || msg.Kind != kind {
+			t.Fatal("mail not sent: ", kind)
+		}
+		for _, line := range strings.Split(msg.Text, "\n") {
+			if strings.Contains(line, "key: ") {
+				fields := strings.Fields(line)
+				return authentication.EmailSecretKey(fields[len(fields)-1])
+			}
+		}
+		t.Fatal("key not found in mail: ", msg.Text)
+		return ""
+	}
+
+	change, err := profile.RequestEmailChange(regPass, changeEmail)
+	if err != nil {
+		t.Fatal("RequestEmailChange error: ", err)
+	}
+	//ключи приходят только письмами
+	if change.OldKey != "" || change.NewKey != "" {
+		t.Fatal("RequestEmailChange returned confirmation keys")
+	}
+	oldKey, newKey := mailKey(regEmail, authentication.MailChangeEmail), mailKey(changeEmail, authentication.MailChangeEmailNew)
+
+	change, err = auth.ConfirmEmailChange(newKey)
+	if err != nil || change.State != authentication.EmailChangeNewConfirmed || change.OldKey != "" {
+		t.Fatal("ConfirmEmailChange new key error: ", err)
+	}
+	if email, _ := profile.GetEmail(); email != regEmail {
+		t.Fatal("email changed before old address confirmation: ", email)
+	}
+
+	change, err = auth.ConfirmEmailChange(oldKey)
+	if err != nil || change.State != authentication.EmailChangeApplied {
+		t.Fatal("ConfirmEmailChange old key error: ", err)
+	}
+	if email, _ := profile.GetEmail(); email != changeEmail {
+		t.Fatal("email was not changed: ", email)
+	}
+	if verified, _ := profile.IsEmailVerified(); !verified {
+		t.Fatal("new email is not verified")
+	}
+	if msg, _ := mailer.Last(regEmail); msg.Kind != authentication.MailChangeEmailUndo || !strings.Contains(msg.Text, string(change.UndoKey)) {
+		t.Fatal("undo email was not sent: ", msg)
+	}
+
+	tok, err := auth.NewToken(profile, 60)
+	if err != nil {
+		t.Fatal("NewToken error: ", err)
+	}
+	if _, err := auth.ReadToken(tok); err != nil {
+		t.Fatal("ReadToken error: ", err)
+	}
+
+	if err := auth.UndoEmailChange(oldKey); !errors.Is(err, authentication.ErrEmailChangeNotFound) {
+		t.Fatal("UndoEmailChange with confirmation key error: ", err)
+	}
+	//старый адрес занят другим профилем: отмена не проходит и не расходует ключ
+	blocker, err := auth.Registration("other_login", regEmail, regPass)
+	if err != nil {
+		t.Fatal("Registration with released email error: ", err)
+	}
+	if err := auth.UndoEmailChange(change.UndoKey); !errors.Is(err, authentication.ErrEmailNotUnique) {
+		t.Fatal("UndoEmailChange with taken email error: ", err)
+	}
+	if email, _ := profile.GetEmail(); email != changeEmail {
+		t.Fatal("email changed by failed undo: ", email)
+	}
+	if err := blocker.DeleteProfile(regPass); err != nil {
+		t.Fatal("DeleteProfile error: ", err)
+	}
+
+	if err := auth.UndoEmailChange(change.UndoKey); err != nil {
+		t.Fatal("UndoEmailChange error: ", err)
+	}
+	if email, _ := profile.GetEmail(); email != regEmail {
+		t.Fatal("email was not restored: ", email)
+	}
+	if _, err := auth.ReadToken(tok); !errors.Is(err, authentication.ErrTokenNotFound) {
+		t.Fatal("ReadToken after undo error: ", err)
+	}
+	if err := auth.UndoEmailChange(change.UndoKey); !errors.Is(err, authentication.ErrEmailChangeNotFound) {
+		t.Fatal("UndoEmailChange reuse error: ", err)
+	}
+
+	if err := profile.DeleteProfile(regPass); err != nil {
+		t.Fatal("DeleteProfile error:", err)
+	}
+}
+
+func testMagicLink(dr authentication.DriverStorage, t *testing.T) {
+	cfg := authentication.AuthConfig{
+		DriverStorage:       dr,
+		EmailLifeTimeSecond: 60 * 60 * 24,
+		ProfilePasswordSalt: []byte("test password salt"),
+		TokenSecretKey:      []byte("token secret keu"),
+	}
+	if _, err := authentication.NewAuth(cfg).RequestMagicLink(regEmail); !errors.Is(err, authentication.ErrEmailNotFound) {
+		t.Fatal("RequestMagicLink unknown email error: ", err)
+	}
+
+	cfg.MagicLinkAutoRegistration = true
+	auth := authentication.NewAuth(cfg)
+
+	key, err := auth.RequestMagicLink(regEmail)
+	if err != nil {
+		t.Fatal("RequestMagicLink error: ", err)
+	}
+
+	profile, tok, err := auth.RedeemMagicLink(key, 60)
+	if err != nil {
+		t.Fatal("RedeemMagicLink error: ", err)
+	}
+	if tokProfile, err := auth.ReadToken(tok); err != nil || tokProfile.ProfileID != profile.ProfileID {
+		t.Fatal("ReadToken magic link token error: ", err)
+	}
+	if verified, _ := profile.IsEmailVerified(); !verified {
+		t.Fatal("magic link email is not verified")
+	}
+	if _, _, err := auth.RedeemMagicLink(key, 60); !errors.Is(err, authentication.ErrEmailSecretKeyNotFound) {
+		t.Fatal("RedeemMagicLink reuse error: ", err)
+	}
+	if _, err := auth.Authentication(regEmail, ""); !errors.Is(err, authentication.ErrWrongLoginOrPassword) {
+		t.Fatal("Authentication of passwordless profile error: ", err)
+	}
+
+	//повторный вход использует существующий профиль
+	key, err = auth.RequestMagicLink(regEmail)
+	if err != nil {
+		t.Fatal("RequestMagicLink error: ", err)
+	}
+	again, _, err := auth.RedeemMagicLink(key, 60)
+	if err != nil || again.ProfileID != profile.ProfileID {
+		t.Fatal("RedeemMagicLink existing profile error: ", err)
+	}
+
+	//вход по ссылке требует второй фактор, как и вход по паролю
+	enrollment, err := again.EnrollTOTP()
+	if err != nil {
+		t.Fatal("EnrollTOTP error: ", err)
+	}
+	code, _ := authentication.TOTPCode(enrollment.Secret, time.Now())
+	if err := again.ConfirmTOTP(code); err != nil {
+		t.Fatal("ConfirmTOTP error: ", err)
+	}
+	key, err = auth.RequestMagicLink(regEmail)
+	if err != nil {
+		t.Fatal("RequestMagicLink error: ", err)
+	}
+	if _, tok, err := auth.RedeemMagicLink(key, 60); !errors.Is(err, authentication.ErrMFARequired) || tok != "" {
+		t.Fatal("RedeemMagicLink with TOTP error: ", err)
+	}
+
+	if err := dr.DelProfile(profile.ProfileID); err != nil {
+		t.Fatal("DelProfile error:", err)
+	}
+}
+
+func TestTOTPCode(t *testing.T) {
+	//RFC 6238, приложение B: секрет "12345678901234567890", T = 59
+	code, err := authentication.TOTPCode("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", time.Unix(59, 0))
+	if err != nil {
+		t.Fatal("TOTPCode error: ", err)
+	}
+	if code != "287082" {
+		t.Fatal("TOTPCode RFC 6238 vector: ", code)
+	}
+}
+
+func testTOTP(dr authentication.DriverStorage, t *testing.T) {
+	auth := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:       dr,
+		EmailLifeTimeSecond: 60 * 60 * 24,
+		ProfilePasswordSalt: []byte("test password salt"),
+		TokenSecretKey:      []byte("token secret keu"),
+		TOTPIssuer:          "Example",
+		MFAMaxAttempts:      2,
+	})
+
+	profile, err := auth.Registration(regLogin, regEmail, regPass)
+	if err != nil {
+		t.Fatal("error profile registration", err)
+	}
+
+	enrollment, err := profile.EnrollTOTP()
+	if err != nil {
+		t.Fatal("EnrollTOTP error: ", err)
+	}
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/Example:"+regLogin+"?") {
+		t.Fatal("EnrollTOTP URI: ", enrollment.URI)
+	}
+
+	//до подтверждения второй фактор не требуется
+	if _, err := auth.Authentication(regLogin, regPass); err != nil {
+		t.Fatal("Authentication with unconfirmed TOTP error: ", err)
+	}
+
+	now := time.Now()
+	code, _ := authentication.TOTPCode(enrollment.Secret, now)
+	if err := profile.ConfirmTOTP(code); err != nil {
+		t.Fatal("ConfirmTOTP error: ", err)
+	}
+	if _, err := profile.EnrollTOTP(); !errors.Is(err, authentication.ErrTOTPAlreadyEnrolled) {
+		t.Fatal("EnrollTOTP enrolled error: ", err)
+	}
+
+	_, err = auth.Authentication(regLogin, regPass)
+	var mfa *authentication.MFARequiredError
+	if !errors.As(err, &mfa) || len(mfa.Methods) != 1 || mfa.Methods[0] != authentication.MFATOTP {
+		t.Fatal("Authentication with TOTP error: ", err)
+	}
+
+	//код шага подтверждения повторно не принимается
+	if _, err := auth.CompleteMFA(mfa.Challenge, authentication.MFATOTP, code); !errors.Is(err, authentication.ErrMFACodeInvalid) {
+		t.Fatal("CompleteMFA replayed code error: ", err)
+	}
+
+	next, _ := authentication.TOTPCode(enrollment.Secret, now.Add(30*time.Second))
+	mfaProfile, err := auth.CompleteMFA(mfa.Challenge, authentication.MFATOTP, next)
+	if err != nil || mfaProfile.ProfileID != profile.ProfileID {
+		t.Fatal("CompleteMFA error: ", err)
+	}
+	if _, err := auth.CompleteMFA(mfa.Challenge, authentication.MFATOTP, next); !errors.Is(err, authentication.ErrMFAChallengeNotFound) {
+		t.Fatal("CompleteMFA reuse challenge error: ", err)
+	}
+
+	//вызов сгорает после MFAMaxAttempts неверных кодов
+	_, err = auth.Authentication(regLogin, regPass)
+	if !errors.As(err, &mfa) {
+		t.Fatal("Authentication with TOTP error: ", err)
+	}
+	if _, err := auth.CompleteMFA(mfa.Challenge, authentication.MFATOTP, "000000"); !errors.Is(err, authentication.ErrMFACodeInvalid) {
+		t.Fatal("CompleteMFA wrong code error: ", err)
+	}
+	if _, err := auth.CompleteMFA(mfa.Challenge, authentication.MFATOTP, "000000"); !errors.Is(err, authentication.ErrMFAAttemptsExceeded) {
+		t.Fatal("CompleteMFA attempts exceeded error: ", err)
+	}
+
+	//параллельные попытки не обходят MFAMaxAttempts
+	_, err = auth.Authentication(regLogin, regPass)
+	if !errors.As(err, &mfa) {
+		t.Fatal("Authentication with TOTP error: ", err)
+	}
+	var wg sync.WaitGroup
+	var invalid int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := auth.CompleteMFA(mfa.Challenge, authentication.MFATOTP, "000000"); errors.Is(err, authentication.ErrMFACodeInvalid) {
+				atomic.AddInt32(&invalid, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if invalid > 1 {
+		t.Fatal("CompleteMFA parallel attempts: ", invalid)
+	}
+
+	if err := profile.DisableTOTP(regPass); err != nil {
+		t.Fatal("DisableTOTP error: ", err)
+	}
+	if _, err := auth.Authentication(regLogin, regPass); err != nil {
+		t.Fatal("Authentication after DisableTOTP error: ", err)
+	}
+
+	if err := profile.DeleteProfile(regPass); err != nil {
+		t.Fatal("DeleteProfile error:", err)
+	}
+}
+
+func testRecoveryCodes(dr authentication.DriverStorage, t *testing.T) {
+	auth := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:       dr,
+		EmailLifeTimeSecond: 60 * 60 * 24,
+		ProfilePasswordSalt: []byte("test password salt"),
+		TokenSecretKey:      []byte("token secret keu"),
+	})
+
+	profile, err := auth.Registration(regLogin, regEmail, regPass)
+	if err != nil {
+		t.Fatal("error profile registration", err)
+	}
+	enrollment, err := profile.EnrollTOTP()
+	if err != nil {
+		t.Fatal("EnrollTOTP error: ", err)
+	}
+	code, _ := authentication.TOTPCode(enrollment.Secret, time.Now())
+	if err := profile.ConfirmTOTP(code); err != nil {
+		t.Fatal("ConfirmTOTP error: ", err)
+	}
+
+	if _, err := profile.GenerateRecoveryCodes("regPass"); !errors.Is(err, authentication.ErrWrongPassword) {
+		t.Fatal("GenerateRecoveryCodes wrong password error: ", err)
+	}
+	codes, err := profile.GenerateRecoveryCodes(regPass)
+	if err != nil || len(codes) != 10 {
+		t.Fatal("GenerateRecoveryCodes error: ", err, codes)
+	}
+	if n, err := profile.RecoveryCodesRemaining(); err != nil || n != 10 {
+		t.Fatal("RecoveryCodesRemaining error: ", err, n)
+	}
+
+	_, err = auth.Authentication(regLogin, regPass)
+	var mfa *authentication.MFARequiredError
+	if !errors.As(err, &mfa) || len(mfa.Methods) != 2 || mfa.Methods[1] != authentication.MFARecoveryCode {
+		t.Fatal("Authentication with recovery codes error: ", err)
+	}
+
+	//код принимается в другом регистре и с пробелами
+	typed := " " + strings.ToUpper(codes[0]) + " "
+	if _, err := auth.CompleteMFA(mfa.Challenge, authentication.MFARecoveryCode, typed); err != nil {
+		t.Fatal("CompleteMFA recovery code error: ", err)
+	}
+	if n, _ := profile.RecoveryCodesRemaining(); n != 9 {
+		t.Fatal("RecoveryCodesRemaining after use: ", n)
+	}
+
+	_, err = auth.Authentication(regLogin, regPass)
+	if !errors.As(err, &mfa) {
+		t.Fatal("Authentication with recovery codes error: ", err)
+	}
+	if _, err := auth.CompleteMFA(mfa.Challenge, authentication.MFARecoveryCode, codes[0]); !errors.Is(err, authentication.ErrMFACodeInvalid) {
+		t.Fatal("CompleteMFA reused recovery code error: ", err)
+	}
+
+	//новый набор заменяет прежний
+	if _, err := profile.GenerateRecoveryCodes(regPass); err != nil {
+		t.Fatal("GenerateRecoveryCodes error: ", err)
+	}
+	if _, err := auth.CompleteMFA(mfa.Challenge, authentication.MFARecoveryCode, codes[1]); !errors.Is(err, authentication.ErrMFACodeInvalid) {
+		t.Fatal("CompleteMFA replaced recovery code error: ", err)
+	}
+
+	if err := profile.DeleteProfile(regPass); err != nil {
+		t.Fatal("DeleteProfile error:", err)
+	}
+}
+
+func testMFAEmail(dr authentication.DriverStorage, t *testing.T) {
+	mailer := mailers.NewMemory()
+	auth := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:           dr,
+		EmailLifeTimeSecond:     60 * 60 * 24,
+		ProfilePasswordSalt:     []byte("test password salt"),
+		TokenSecretKey:          []byte("token secret keu"),
+		Mailer:                  mailer,
+		MFAEmailCodeMaxAttempts: 2,
+	})
+
+	profile, key, err := auth.RegistrationWithVerification(regLogin, regEmail, regPass)
+	if err != nil {
+		t.Fatal("error profile registration", err)
+	}
+	if err := profile.EnableEmailMFA(regPass); !errors.Is(err, authentication.ErrEmailNotVerified) {
+		t.Fatal("EnableEmailMFA unverified email error: ", err)
+	}
+	if err := auth.VerifyEmail(key); err != nil {
+		t.Fatal("VerifyEmail error: ", err)
+	}
+	if err := profile.EnableEmailMFA(regPass); err != nil {
+		t.Fatal("EnableEmailMFA error: ", err)
+	}
+
+	lastCode := func() string {
+		msg, ok := mailer.Last(regEmail)
+		if !ok || msg.Kind != authentication.MailMFACode {
+			t.Fatal("mfa code mail not sent")
+		}
+		fields := strings.Fields(strings.SplitN(msg.Text, "\n", 2)[0])
+		return fields[len(fields)-1]
+	}
+
+	_, err = auth.Authentication(regLogin, regPass)
+	var mfa *authentication.MFARequiredError
+	if !errors.As(err, &mfa) || mfa.Methods[0] != authentication.MFAEmail {
+		t.Fatal("Authentication with email mfa error: ", err)
+	}
+	code := lastCode()
+
+	if err := auth.ResendMFACode(mfa.Challenge); !errors.Is(err, authentication.ErrMFAResendThrottled) {
+		t.Fatal("ResendMFACode throttle error: ", err)
+	}
+
+	//код сгорает после MFAEmailCodeMaxAttempts неверных попыток
+	for i := 0; i < 2; i++ {
+		if _, err := auth.CompleteMFA(mfa.Challenge, authentication.MFAEmail, "bad"); !errors.Is(err, authentication.ErrMFACodeInvalid) {
+			t.Fatal("CompleteMFA wrong email code error: ", err)
+		}
+	}
+	if _, err := auth.CompleteMFA(mfa.Challenge, authentication.MFAEmail, code); !errors.Is(err, authentication.ErrMFACodeExpired) {
+		t.Fatal("CompleteMFA burnt email code error: ", err)
+	}
+
+	_, err = auth.Authentication(regLogin, regPass)
+	if !errors.As(err, &mfa) {
+		t.Fatal("Authentication with email mfa error: ", err)
+	}
+	prof, err := auth.CompleteMFA(mfa.Challenge, authentication.MFAEmail, lastCode())
+	if err != nil || prof.ProfileID != profile.ProfileID {
+		t.Fatal("CompleteMFA email code error: ", err)
+	}
+
+	if err := profile.DisableEmailMFA(regPass); err != nil {
+		t.Fatal("DisableEmailMFA error: ", err)
+	}
+	if _, err := auth.Authentication(regLogin, regPass); err != nil {
+		t.Fatal("Authentication after DisableEmailMFA error: ", err)
+	}
+
+	if err := profile.DeleteProfile(regPass); err != nil {
+		t.Fatal("DeleteProfile error: ", err)
+	}
+}
+
+func testReauthenticate(dr authentication.DriverStorage, t *testing.T) {
+	auth := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:       dr,
+		EmailLifeTimeSecond: 60 * 60 * 24,
+		ProfilePasswordSalt: []byte("test password salt"),
+		TokenSecretKey:      []byte("token secret keu"),
+	})
+
+	profile, err := auth.Registration(regLogin, regEmail, regPass)
+	if err != nil {
+		t.Fatal("error profile registration", err)
+	}
+	tok, err := auth.NewToken(profile, 60)
+	if err != nil {
+		t.Fatal("NewToken error: ", err)
+	}
+
+	if _, err := auth.RequireFreshAuth(tok, 60); err != nil {
+		t.Fatal("RequireFreshAuth fresh token error: ", err)
+	}
+	if _, err := auth.RequireFreshAuth(tok, -1); !errors.Is(err, authentication.ErrReauthenticationRequired) {
+		t.Fatal("RequireFreshAuth stale token error: ", err)
+	}
+
+	if _, err := auth.Reauthenticate(tok, "regPass"); !errors.Is(err, authentication.ErrWrongPassword) {
+		t.Fatal("Reauthenticate wrong password error: ", err)
+	}
+	prof, err := auth.Reauthenticate(tok, regPass)
+	if err != nil || prof.ProfileID != profile.ProfileID {
+		t.Fatal("Reauthenticate error: ", err)
+	}
+	if _, err := auth.RequireFreshAuth(tok, 60); err != nil {
+		t.Fatal("RequireFreshAuth after Reauthenticate error: ", err)
+	}
+
+	//неверные пароли учитываются, как при входе
+	throttled := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:       dr,
+		EmailLifeTimeSecond: 60 * 60 * 24,
+		ProfilePasswordSalt: []byte("test password salt"),
+		TokenSecretKey:      []byte("token secret keu"),
+		AttemptStore:        authentication.NewMemoryAttemptStore(),
+		LoginAttemptPolicy:  authentication.AttemptPolicy{BackoffAfter: 2, BaseDelaySecond: 60},
+	})
+	for i := 0; i < 2; i++ {
+		if _, err := throttled.Reauthenticate(tok, "regPass"); !errors.Is(err, authentication.ErrWrongPassword) {
+			t.Fatal("Reauthenticate wrong password error: ", err)
+		}
+	}
+	if _, err := throttled.Reauthenticate(tok, regPass); !errors.Is(err, authentication.ErrLoginThrottled) {
+		t.Fatal("Reauthenticate throttled error: ", err)
+	}
+
+	//при включенном втором факторе одного пароля недостаточно
+	enrollment, err := profile.EnrollTOTP()
+	if err != nil {
+		t.Fatal("EnrollTOTP error: ", err)
+	}
+	now := time.Now()
+	code, _ := authentication.TOTPCode(enrollment.Secret, now)
+	if err := profile.ConfirmTOTP(code); err != nil {
+		t.Fatal("ConfirmTOTP error: ", err)
+	}
+	_, err = auth.Reauthenticate(tok, regPass)
+	var mfa *authentication.MFARequiredError
+	if !errors.As(err, &mfa) {
+		t.Fatal("Reauthenticate with TOTP error: ", err)
+	}
+	next, _ := authentication.TOTPCode(enrollment.Secret, now.Add(30*time.Second))
+	if _, err := auth.CompleteMFA(mfa.Challenge, authentication.MFATOTP, next); !errors.Is(err, authentication.ErrMFAChallengeNotFound) {
+		t.Fatal("CompleteMFA with reauthentication challenge error: ", err)
+	}
+	if _, err := auth.CompleteReauthentication(tok, mfa.Challenge, authentication.MFATOTP, next); err != nil {
+		t.Fatal("CompleteReauthentication error: ", err)
+	}
+
+	//недавняя аутентификация заменяет пароль
+	if err := profile.DeleteProfile(""); !errors.Is(err, authentication.ErrWrongPassword) {
+		t.Fatal("DeleteProfile without password error: ", err)
+	}
+	fresh, err := auth.RequireFreshAuth(tok, 60)
+	if err != nil {
+		t.Fatal("RequireFreshAuth error: ", err)
+	}
+	if _, err := profile.RequestEmailChange("", changeEmail); !errors.Is(err, authentication.ErrWrongPassword) {
+		t.Fatal("RequestEmailChange without password error: ", err)
+	}
+	if _, err := fresh.RequestEmailChange("", changeEmail); err != nil {
+		t.Fatal("RequestEmailChange with fresh auth error: ", err)
+	}
+	if err := fresh.DisableTOTP(""); err != nil {
+		t.Fatal("DisableTOTP with fresh auth error: ", err)
+	}
+	if err := fresh.DeleteProfile(""); err != nil {
+		t.Fatal("DeleteProfile error: ", err)
+	}
+	if _, err := auth.RequireFreshAuth(tok, 60); err == nil {
+		t.Fatal("RequireFreshAuth of deleted profile token")
+	}
+}
+
+func testBruteForce(dr authentication.DriverStorage, t *testing.T) {
+	cfg := authentication.AuthConfig{
+		DriverStorage:       dr,
+		EmailLifeTimeSecond: 60 * 60 * 24,
+		ProfilePasswordSalt: []byte("test password salt"),
+		TokenSecretKey:      []byte("token secret keu"),
+		AttemptStore:        authentication.NewMemoryAttemptStore(),
+		LoginAttemptPolicy:  authentication.AttemptPolicy{BackoffAfter: 2, BaseDelaySecond: 60, LockoutAfter: 4},
+		IPAttemptPolicy:     authentication.AttemptPolicy{BackoffAfter: 3, BaseDelaySecond: 60},
+	}
+	auth := authentication.NewAuth(cfg)
+
+	profile, err := auth.Registration(regLogin, regEmail, regPass)
+	if err != nil {
+		t.Fatal("error profile registration", err)
+	}
+
+	//успешный вход сбрасывает счетчик
+	if _, err := auth.Authentication(regLogin, "regPass"); !errors.Is(err, authentication.ErrWrongLoginOrPassword) {
+		t.Fatal("Authentication wrong password error: ", err)
+	}
+	if _, err := auth.Authentication(regLogin, regPass); err != nil {
+		t.Fatal("Authentication error: ", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := auth.Authentication(regLogin, "regPass"); !errors.Is(err, authentication.ErrWrongLoginOrPassword) {
+			t.Fatal("Authentication wrong password error: ", err)
+		}
+	}
+	_, err = auth.Authentication(regLogin, regPass)
+	var blocked *authentication.LoginBlockedError
+	if !errors.As(err, &blocked) || !errors.Is(err, authentication.ErrLoginThrottled) || !blocked.RetryAfter.After(time.Now()) {
+		t.Fatal("Authentication backoff error: ", err)
+	}
+
+	cfg.AttemptStore = authentication.NewMemoryAttemptStore()
+	cfg.LoginAttemptPolicy = authentication.AttemptPolicy{BackoffAfter: 5, LockoutAfter: 2, LockoutSecond: 60}
+	auth = authentication.NewAuth(cfg)
+	for i := 0; i < 2; i++ {
+		auth.Authentication(regLogin, "regPass")
+	}
+	if _, err := auth.Authentication(regLogin, regPass); !errors.Is(err, authentication.ErrAccountLocked) {
+		t.Fatal("Authentication lockout error: ", err)
+	}
+
+	//перебор разных логинов с одного IP, вход в свой аккаунт не сбрасывает счетчик IP
+	cfg.AttemptStore = authentication.NewMemoryAttemptStore()
+	auth = authentication.NewAuth(cfg)
+	client := auth.WithMeta(authentication.RequestMeta{IP: "192.0.2.1"})
+	for _, login := range []string{"a", "b"} {
+		client.Authentication(login, "x")
+	}
+	if _, err := client.Authentication(regLogin, regPass); err != nil {
+		t.Fatal("Authentication error: ", err)
+	}
+	client.Authentication("c", "x")
+	if _, err := client.Authentication("d", "x"); !errors.Is(err, authentication.ErrLoginThrottled) {
+		t.Fatal("Authentication ip backoff error: ", err)
+	}
+
+	if err := profile.DeleteProfile(regPass); err != nil {
+		t.Fatal("DeleteProfile error: ", err)
+	}
+}
+
+func TestGormAttemptStore(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:attempts?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := drivers.NewGormAttemptStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		state, err := store.Fail("login:test", 60)
+		if err != nil || state.Failures != i {
+			t.Fatal("Fail error: ", err, state)
+		}
+	}
+	if state, err := store.Get("login:test", 60); err != nil || state.Failures != 3 {
+		t.Fatal("Get error: ", err, state)
+	}
+	//окно истекло, счет начинается заново
+	if state, err := store.Fail("login:test", -1); err != nil || state.Failures != 1 {
+		t.Fatal("Fail after window error: ", err, state)
+	}
+	if err := store.Reset("login:test"); err != nil {
+		t.Fatal("Reset error: ", err)
+	}
+	if state, err := store.Get("login:test", 60); err != nil || state.Failures != 0 {
+		t.Fatal("Get after Reset error: ", err, state)
+	}
+}
+
+func testEvents(dr authentication.DriverStorage, t *testing.T) {
+	var types []authentication.EventType
+	var buf bytes.Buffer
+	auth := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:       dr,
+		EmailLifeTimeSecond: 60 * 60 * 24,
+		ProfilePasswordSalt: []byte("test password salt"),
+		TokenSecretKey:      []byte("token secret keu"),
+		EventSubscriber: authentication.MultiSubscriber(
+			authentication.EventSubscriberFunc(func(event *authentication.Event) error {
+				if event.Meta.IP != "192.0.2.1" {
+					t.Fatal("event without request meta: ", event.Type)
+				}
+				types = append(types, event.Type)
+				return nil
+			}),
+			drivers.NewJSONLinesSink(&buf),
+		),
+	}).WithMeta(authentication.RequestMeta{IP: "192.0.2.1"})
+
+	profile, err := auth.Registration(regLogin, regEmail, regPass)
+	if err != nil {
+		t.Fatal("error profile registration", err)
+	}
+	auth.Authentication(regLogin, "regPass")
+	if _, err := auth.Authentication(regLogin, regPass); err != nil {
+		t.Fatal("Authentication error: ", err)
+	}
+	tok, err := auth.NewToken(profile, 60)
+	if err != nil {
+		t.Fatal("NewToken error: ", err)
+	}
+	if err := auth.DelPublicToken(tok, profile.ProfileID); err != nil {
+		t.Fatal("DelPublicToken error: ", err)
+	}
+	if err := profile.ChangePassword(regPass, changePassword); err != nil {
+		t.Fatal("ChangePassword error: ", err)
+	}
+	if _, err := profile.GenerateRecoveryCodes(changePassword); err != nil {
+		t.Fatal("GenerateRecoveryCodes error: ", err)
+	}
+	if err := profile.DeleteProfile(changePassword); err != nil {
+		t.Fatal("DeleteProfile error: ", err)
+	}
+
+	want := []authentication.EventType{
+		authentication.EventRegistered, authentication.EventLoginFailed, authentication.EventLoginSucceeded,
+		authentication.EventTokenIssued, authentication.EventTokenRevoked, authentication.EventPasswordChanged,
+		authentication.EventRecoveryCodesGenerated, authentication.EventProfileDeleted,
+	}
+	if len(types) != len(want) {
+		t.Fatal("events: ", types)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatal("events: ", types)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	event := new(authentication.Event)
+	if len(lines) != len(want) || json.Unmarshal([]byte(lines[0]), event) != nil || event.Data["login"] != regLogin {
+		t.Fatal("json lines sink: ", buf.String())
+	}
+}
+
+func testNewDevice(dr authentication.DriverStorage, t *testing.T) {
+	mailer := mailers.NewMemory()
+	cfg := authentication.AuthConfig{
+		DriverStorage:       dr,
+		EmailLifeTimeSecond: 60 * 60 * 24,
+		ProfilePasswordSalt: []byte("test password salt"),
+		TokenSecretKey:      []byte("token secret keu"),
+		Mailer:              mailer,
+		NewDeviceDetection:  true,
+	}
+	auth := authentication.NewAuth(cfg)
+	home := auth.WithMeta(authentication.RequestMeta{IP: "192.0.2.1", UserAgent: "home browser"})
+	other := auth.WithMeta(authentication.RequestMeta{IP: "198.51.100.7", UserAgent: "other browser"})
+
+	lastKey := func(kind authentication.MailKind) authentication.EmailSecretKey {
+		msg, ok := mailer.Last(regEmail)
+		if !ok || msg.Kind != kind {
+			t.Fatal("mail not sent: ", kind)
+		}
+		for _, line := range strings.Split(msg.Text, "\n") {
+			if strings.Contains(line, "key: ") {
+				fields := strings.Fields(line)
+				return authentication.EmailSecretKey(fields[len(fields)-1])
+			}
+		}
+		t.Fatal("key not found in mail: ", msg.Text)
+		return ""
+	}
+
+	profile, err := home.Registration(regLogin, regEmail, regPass)
+	if err != nil {
+		t.Fatal("error profile registration", err)
+	}
+	mailer.Reset()
+
+	//первое устройство и повторный вход с него не вызывают писем
+	for i := 0; i < 2; i++ {
+		if _, err := home.Authentication(regLogin, regPass); err != nil {
+			t.Fatal("Authentication error: ", err)
+		}
+	}
+	if len(mailer.Messages()) != 0 {
+		t.Fatal("mail on known device sign-in: ", mailer.Messages())
+	}
+
+	prof, err := other.Authentication(regLogin, regPass)
+	if err != nil {
+		t.Fatal("Authentication from new device error: ", err)
+	}
+	tok, err := other.NewToken(prof, 60)
+	if err != nil {
+		t.Fatal("NewToken error: ", err)
+	}
+	if err := auth.RevokeSignIn(lastKey(authentication.MailNewSignIn)); err != nil {
+		t.Fatal("RevokeSignIn error: ", err)
+	}
+	if _, err := auth.ReadToken(tok); !errors.Is(err, authentication.ErrTokenNotFound) {
+		t.Fatal("ReadToken after RevokeSignIn error: ", err)
+	}
+	devices, err := profile.KnownDevices()
+	if err != nil || len(devices) != 1 {
+		t.Fatal("KnownDevices error: ", err, devices)
+	}
+
+	//устройство не запоминается, если уведомление не ушло
+	mailer.Fail(errors.New("smtp down"))
+	if _, err := other.Authentication(regLogin, regPass); !errors.Is(err, authentication.ErrMailNotDelivered) {
+		t.Fatal("Authentication with failed new sign-in mail error: ", err)
+	}
+	mailer.Fail(nil)
+	if devices, err := profile.KnownDevices(); err != nil || len(devices) != 1 {
+		t.Fatal("KnownDevices after failed mail error: ", err, devices)
+	}
+
+	//вход по ссылке тоже проверяет устройство
+	key, err := auth.RequestMagicLink(regEmail)
+	if err != nil {
+		t.Fatal("RequestMagicLink error: ", err)
+	}
+	if _, _, err := other.RedeemMagicLink(key, 60); err != nil {
+		t.Fatal("RedeemMagicLink from new device error: ", err)
+	}
+	if err := auth.RevokeSignIn(lastKey(authentication.MailNewSignIn)); err != nil {
+		t.Fatal("RevokeSignIn after magic link error: ", err)
+	}
+
+	cfg.NewDeviceConfirmation = true
+	other = authentication.NewAuth(cfg).WithMeta(authentication.RequestMeta{IP: "198.51.100.7", UserAgent: "other browser"})
+	if _, err := other.Authentication(regLogin, regPass); !errors.Is(err, authentication.ErrDeviceConfirmationRequired) {
+		t.Fatal("Authentication with device confirmation error: ", err)
+	}
+	prof, tok, err = other.ConfirmDevice(lastKey(authentication.MailConfirmDevice), 60)
+	if err != nil || prof.ProfileID != profile.ProfileID {
+		t.Fatal("ConfirmDevice error: ", err)
+	}
+	if _, err := auth.ReadToken(tok); err != nil {
+		t.Fatal("ReadToken after ConfirmDevice error: ", err)
+	}
+	if _, err := other.Authentication(regLogin, regPass); err != nil {
+		t.Fatal("Authentication from confirmed device error: ", err)
+	}
+
+	if err := profile.DeleteProfile(regPass); err != nil {
+		t.Fatal("DeleteProfile error: ", err)
+	}
+}
+
+func testChallenge(dr authentication.DriverStorage, t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"success":%t}`, r.FormValue("response") == "ok")
+	}))
+	defer srv.Close()
+
+	auth := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:       dr,
+		EmailLifeTimeSecond: 60 * 60 * 24,
+		ProfilePasswordSalt: []byte("test password salt"),
+		TokenSecretKey:      []byte("token secret keu"),
+		AttemptStore:        authentication.NewMemoryAttemptStore(),
+		ChallengeVerifier:   captcha.New(captcha.Config{VerifyURL: srv.URL, Secret: "secret"}),
+		ChallengePolicy: func(signals *authentication.RiskSignals) bool {
+			return signals.LoginFailures >= 1 || signals.IPRequests > 1
+		},
+	})
+	meta := authentication.RequestMeta{IP: "192.0.2.1"}
+	client := auth.WithMeta(meta)
+
+	profile, err := client.Registration(regLogin, regEmail, regPass)
+	if err != nil {
+		t.Fatal("error profile registration", err)
+	}
+
+	if _, err := client.ForgotPassword(regEmail); err != nil {
+		t.Fatal("ForgotPassword error: ", err)
+	}
+	if _, err := client.ForgotPassword(regEmail); !errors.Is(err, authentication.ErrChallengeRequired) {
+		t.Fatal("ForgotPassword without challenge error: ", err)
+	}
+	meta.ChallengeResponse = "bad"
+	if _, err := auth.WithMeta(meta).ForgotPassword(regEmail); !errors.Is(err, authentication.ErrChallengeFailed) {
+		t.Fatal("ForgotPassword with rejected challenge error: ", err)
+	}
+	meta.ChallengeResponse = "ok"
+	if _, err := auth.WithMeta(meta).ForgotPassword(regEmail); err != nil {
+		t.Fatal("ForgotPassword with challenge error: ", err)
+	}
+
+	if _, err := client.Authentication(regLogin, "regPass"); !errors.Is(err, authentication.ErrWrongLoginOrPassword) {
+		t.Fatal("Authentication wrong password error: ", err)
+	}
+	if _, err := client.Authentication(regLogin, regPass); !errors.Is(err, authentication.ErrChallengeRequired) {
+		t.Fatal("Authentication without challenge error: ", err)
+	}
+	if _, err := auth.WithMeta(meta).Authentication(regLogin, regPass); err != nil {
+		t.Fatal("Authentication with challenge error: ", err)
+	}
+
+	//профиль без подтвержденных устройств неотличим от неизвестного логина
+	auth = authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:       dr,
+		EmailLifeTimeSecond: 60 * 60 * 24,
+		ProfilePasswordSalt: []byte("test password salt"),
+		TokenSecretKey:      []byte("token secret keu"),
+		ChallengeVerifier:   captcha.New(captcha.Config{VerifyURL: srv.URL, Secret: "secret"}),
+		NewDeviceDetection:  true,
+	})
+	client = auth.WithMeta(authentication.RequestMeta{IP: "198.51.100.1", UserAgent: "test"})
+	for _, login := range []string{regLogin, "unknown_login"} {
+		if _, err := client.Authentication(login, regPass); !errors.Is(err, authentication.ErrChallengeRequired) {
+			t.Fatal("Authentication from new IP without challenge error: ", login, err)
+		}
+	}
+	if _, err := auth.WithMeta(authentication.RequestMeta{IP: "198.51.100.1", UserAgent: "test", ChallengeResponse: "ok"}).Authentication(regLogin, regPass); err != nil {
+		t.Fatal("Authentication from new IP with challenge error: ", err)
+	}
+	if _, err := client.Authentication(regLogin, regPass); err != nil {
+		t.Fatal("Authentication from known IP error: ", err)
+	}
+
+	if err := profile.DeleteProfile(regPass); err != nil {
+		t.Fatal("DeleteProfile error: ", err)
+	}
+}
+
+func testProofOfWork(dr authentication.DriverStorage, t *testing.T) {
+	auth := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:            dr,
+		EmailLifeTimeSecond:      60 * 60 * 24,
+		ProfilePasswordSalt:      []byte("test password salt"),
+		TokenSecretKey:           []byte("token secret keu"),
+		ProofOfWorkDifficulty:    8,
+		ProofOfWorkRatePerMinute: 1,
+	})
+	withStamp := func(stamp string) *authentication.Auth {
+		return auth.WithMeta(authentication.RequestMeta{ProofOfWork: stamp})
+	}
+
+	if _, err := auth.Registration(regLogin, regEmail, regPass); !errors.Is(err, authentication.ErrProofOfWorkRequired) {
+		t.Fatal("Registration without proof of work error: ", err)
+	}
+
+	pow, err := auth.NewProofOfWork(authentication.ChallengeRegistration)
+	if err != nil || pow.Difficulty != 8 {
+		t.Fatal("NewProofOfWork error: ", err, pow)
+	}
+	stamp := authentication.SolveProofOfWork(pow)
+	profile, err := withStamp(stamp).Registration(regLogin, regEmail, regPass)
+	if err != nil {
+		t.Fatal("Registration with proof of work error: ", err)
+	}
+	if _, err := withStamp(stamp).Registration("other_login", "other@gmail.com", regPass); !errors.Is(err, authentication.ErrProofOfWorkUsed) {
+		t.Fatal("Registration with used proof of work error: ", err)
+	}
+	if _, err := withStamp(stamp).ForgotPassword(regEmail); !errors.Is(err, authentication.ErrProofOfWorkInvalid) {
+		t.Fatal("ForgotPassword with registration proof of work error: ", err)
+	}
+
+	//выдача задач бесплатна и сложность не повышает, частые принятые решения повышают
+	for i := 0; i < 2; i++ {
+		pow, err = auth.NewProofOfWork(authentication.ChallengeForgotPassword)
+		if err != nil || pow.Difficulty != 8 {
+			t.Fatal("NewProofOfWork error: ", err, pow)
+		}
+		if _, err := withStamp(authentication.SolveProofOfWork(pow)).ForgotPassword(regEmail); err != nil {
+			t.Fatal("ForgotPassword with proof of work error: ", err)
+		}
+	}
+	pow, err = auth.NewProofOfWork(authentication.ChallengeForgotPassword)
+	if err != nil || pow.Difficulty != 9 {
+		t.Fatal("NewProofOfWork under load error: ", err, pow)
+	}
+
+	if err := profile.DeleteProfile(regPass); err != nil {
+		t.Fatal("DeleteProfile error: ", err)
+	}
+}
+
+func TestCanonicalEmail(t *testing.T) {
+	for email, want := range map[string]string{
+		" Admin@Example.COM ":     "admin@example.com",
+		"ＡＤＭＩＮ＠example.com":       "admin@example.com",
+		"user@Bücher.example":     "user@xn--bcher-kva.example",
+		"user@münchen.de":         "user@xn--mnchen-3ya.de",
+		"user@пример.испытание":   "user@xn--e1afmkfd.xn--80akhbyknj4f",
+		"Straße@example.com":      "strasse@example.com",
+		"no-at-sign.example.com ": "no-at-sign.example.com",
+	} {
+		if got := authentication.CanonicalEmail(email); got != want {
+			t.Fatalf("CanonicalEmail(%q) = %q, want %q", email, got, want)
+		}
+	}
+	if authentication.LoginSkeleton("pаypa1") != authentication.LoginSkeleton("PayPal") {
+		t.Fatal("LoginSkeleton confusables are not equal")
+	}
+}
+
+func testCanonical(dr authentication.DriverStorage, t *testing.T) {
+	auth := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:        dr,
+		EmailLifeTimeSecond:  60 * 60 * 24,
+		ProfilePasswordSalt:  []byte("test password salt"),
+		TokenSecretKey:       []byte("token secret keu"),
+		LoginConfusableCheck: true,
+	})
+
+	profile, err := auth.Registration(" Admin_Auth_Test", regEmail, regPass)
+	if err != nil {
+		t.Fatal("error profile registration", err)
+	}
+	for _, login := range []string{regLogin, "ＡＤＭＩＮ_ＡＵＴＨ_ＴＥＳＴ", "аdmin_auth_test"} {
+		if _, err := auth.Registration(login, "other@gmail.com", regPass); !errors.Is(err, authentication.ErrLoginNotUnique) {
+			t.Fatal("Registration with equivalent login error: ", login, err)
+		}
+	}
+	if _, err := auth.Registration("other_login", strings.ToUpper(regEmail), regPass); !errors.Is(err, authentication.ErrEmailNotUnique) {
+		t.Fatal("Registration with equivalent email error: ", err)
+	}
+
+	prof, err := auth.Authentication("ADMIN_AUTH_TEST ", regPass)
+	if err != nil || prof.ProfileID != profile.ProfileID {
+		t.Fatal("Authentication with equivalent login error: ", err)
+	}
+	if login, err := prof.GetLogin(); err != nil || login != " Admin_Auth_Test" {
+		t.Fatal("display login is lost: ", login, err)
+	}
+
+	if err := profile.DeleteProfile(regPass); err != nil {
+		t.Fatal("DeleteProfile error: ", err)
+	}
+}
+
+// testUniqueViolation NewProfile без предварительных проверок повторяет проигравшую гонку регистраций
+func testUniqueViolation(dr authentication.DriverStorage, t *testing.T) {
+	pid, err := dr.NewProfile(regLogin, regEmail, "")
+	if err != nil {
+		t.Fatal("NewProfile error: ", err)
+	}
+	if _, err := dr.NewProfile(strings.ToUpper(regLogin), changeEmail, ""); !errors.Is(err, authentication.ErrLoginNotUnique) {
+		t.Fatal("NewProfile with taken login error: ", err)
+	}
+	if _, err := dr.NewProfile("other_login", strings.ToUpper(regEmail), ""); !errors.Is(err, authentication.ErrEmailNotUnique) {
+		t.Fatal("NewProfile with taken email error: ", err)
+	}
+
+	other, err := dr.NewProfile("other_login", changeEmail, "")
+	if err != nil {
+		t.Fatal("NewProfile error: ", err)
+	}
+	if err := dr.SetEmailByProfileID(other, regEmail); !errors.Is(err, authentication.ErrEmailNotUnique) {
+		t.Fatal("SetEmailByProfileID with taken email error: ", err)
+	}
+
+	for _, id := range []authentication.ProfileID{pid, other} {
+		if err := dr.DelProfile(id); err != nil {
+			t.Fatal("DelProfile error: ", err)
+		}
+	}
+}
+
+func testAccountStatus(dr authentication.DriverStorage, t *testing.T) {
+	auth := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:       dr,
+		EmailLifeTimeSecond: 60 * 60 * 24,
+		ProfilePasswordSalt: []byte("test password salt"),
+		TokenSecretKey:      []byte("token secret keu"),
+	})
+
+	profile, err := auth.Registration(regLogin, regEmail, regPass)
+	if err != nil {
+		t.Fatal("error profile registration", err)
+	}
+	tok, err := auth.NewToken(profile, 60)
+	if err != nil {
+		t.Fatal("NewToken error: ", err)
+	}
+
+	until := time.Now().Add(time.Hour)
+	if err := auth.SetProfileStatus(profile.ProfileID, authentication.StatusSuspended, "spam", until); err != nil {
+		t.Fatal("SetProfileStatus error: ", err)
+	}
+	if _, err := auth.ReadToken(tok); !errors.Is(err, authentication.ErrTokenNotFound) {
+		t.Fatal("ReadToken after suspension error: ", err)
+	}
+	_, err = auth.Authentication(regLogin, regPass)
+	var statusErr *authentication.AccountStatusError
+	if !errors.As(err, &statusErr) || !errors.Is(err, authentication.ErrAccountSuspended) || statusErr.Reason != "spam" || statusErr.Until.Unix() != until.Unix() {
+		t.Fatal("Authentication of suspended profile error: ", err)
+	}
+	if _, err := auth.ProfileByID(profile.ProfileID); !errors.Is(err, authentication.ErrAccountSuspended) {
+		t.Fatal("ProfileByID of suspended profile error: ", err)
+	}
+
+	if err := auth.SetProfileStatus(profile.ProfileID, authentication.StatusBanned, "", time.Time{}); err != nil {
+		t.Fatal("SetProfileStatus error: ", err)
+	}
+	if _, err := auth.Authentication(regLogin, regPass); !errors.Is(err, authentication.ErrAccountBanned) {
+		t.Fatal("Authentication of banned profile error: ", err)
+	}
+	//токен, выданный до блокировки в обход SetProfileStatus, не проходит повторную аутентификацию
+	if err := dr.SetProfileStatus(profile.ProfileID, &authentication.ProfileStatus{Status: authentication.StatusActive}); err != nil {
+		t.Fatal("SetProfileStatus error: ", err)
+	}
+	stale, err := auth.NewToken(profile, 60)
+	if err != nil {
+		t.Fatal("NewToken error: ", err)
+	}
+	if err := dr.SetProfileStatus(profile.ProfileID, &authentication.ProfileStatus{Status: authentication.StatusBanned}); err != nil {
+		t.Fatal("SetProfileStatus error: ", err)
+	}
+	if _, err := auth.Reauthenticate(stale, regPass); !errors.Is(err, authentication.ErrAccountBanned) {
+		t.Fatal("Reauthenticate of banned profile error: ", err)
+	}
+	if _, err := auth.RequireFreshAuth(stale, 60); !errors.Is(err, authentication.ErrAccountBanned) {
+		t.Fatal("RequireFreshAuth of banned profile error: ", err)
+	}
+	if err := auth.SetProfileStatus(profile.ProfileID, "deleted", "", time.Time{}); !errors.Is(err, authentication.ErrAccountStatusInvalid) {
+		t.Fatal("SetProfileStatus invalid status error: ", err)
+	}
+
+	//истекшая блокировка не мешает входу
+	if err := auth.SetProfileStatus(profile.ProfileID, authentication.StatusSuspended, "", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal("SetProfileStatus error: ", err)
+	}
+	if _, err := auth.Authentication(regLogin, regPass); err != nil {
+		t.Fatal("Authentication after suspension end error: ", err)
+	}
+
+	if err := auth.SetProfileStatus(profile.ProfileID, authentication.StatusActive, "", time.Time{}); err != nil {
+		t.Fatal("SetProfileStatus error: ", err)
+	}
+	status, err := auth.ProfileStatus(profile.ProfileID)
+	if err != nil || status.Status != authentication.StatusActive {
+		t.Fatal("ProfileStatus error: ", err, status)
+	}
+	tok, err = auth.NewToken(profile, 60)
+	if err != nil {
+		t.Fatal("NewToken error: ", err)
+	}
+	if _, err := auth.ReadToken(tok); err != nil {
+		t.Fatal("ReadToken of active profile error: ", err)
+	}
+
+	if err := profile.DeleteProfile(regPass); err != nil {
+		t.Fatal("DeleteProfile error: ", err)
+	}
+}
+
+func testSoftDelete(dr authentication.DriverStorage, t *testing.T) {
+	mailer := mailers.NewMemory()
+	auth := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:             dr,
+		EmailLifeTimeSecond:       60 * 60 * 24,
+		ProfilePasswordSalt:       []byte("test password salt"),
+		TokenSecretKey:            []byte("token secret keu"),
+		Mailer:                    mailer,
+		DeletionGracePeriodSecond: 60 * 60,
+	})
+
+	profile, err := auth.Registration(regLogin, regEmail, regPass)
+	if err != nil {
+		t.Fatal("error profile registration", err)
+	}
+	tok, err := auth.NewToken(profile, 60)
+	if err != nil {
+		t.Fatal("NewToken error: ", err)
+	}
+
+	before := time.Now()
+	key, err := profile.DeleteProfileWithRestore(regPass)
+	if err != nil || key == "" {
+		t.Fatal("DeleteProfileWithRestore error: ", err)
+	}
+	after := time.Now()
+	msg, ok := mailer.Last(regEmail)
+	if !ok || msg.Kind != authentication.MailRestoreProfile || !strings.Contains(msg.Text, string(key)) {
+		t.Fatal("restore mail not sent")
+	}
+	//в письме дата окончательного удаления, а не время отправки
+	const layout = "2006-01-02 15:04 MST"
+	if !strings.Contains(msg.Text, before.Add(time.Hour).Format(layout)) && !strings.Contains(msg.Text, after.Add(time.Hour).Format(layout)) {
+		t.Fatal("restore mail purge date: ", msg.Text)
+	}
+	if _, err := auth.Authentication(regLogin, regPass); !errors.Is(err, authentication.ErrAccountPendingDeletion) {
+		t.Fatal("Authentication of deleted profile error: ", err)
+	}
+	if _, err := auth.ReadToken(tok); !errors.Is(err, authentication.ErrTokenNotFound) {
+		t.Fatal("ReadToken of deleted profile error: ", err)
+	}
+	if _, err := auth.Registration(regLogin, changeEmail, regPass); !errors.Is(err, authentication.ErrLoginNotUnique) {
+		t.Fatal("Registration with login of deleted profile error: ", err)
+	}
+	//блокировка не отменяет удаление
+	if err := auth.SetProfileStatus(profile.ProfileID, authentication.StatusSuspended, "", time.Now().Add(time.Hour)); !errors.Is(err, authentication.ErrAccountPendingDeletion) {
+		t.Fatal("SetProfileStatus of deleted profile error: ", err)
+	}
+
+	if _, err := auth.RestoreProfile(key); err != nil {
+		t.Fatal("RestoreProfile error: ", err)
+	}
+	if _, err := auth.RestoreProfile(key); !errors.Is(err, authentication.ErrRestoreKeyNotFound) {
+		t.Fatal("RestoreProfile with used key error: ", err)
+	}
+	if _, err := auth.Authentication(regLogin, regPass); err != nil {
+		t.Fatal("Authentication of restored profile error: ", err)
+	}
+
+	//срок восстановления истек
+	if err := dr.MarkProfileDeleted(profile.ProfileID, "expired key", time.Now().Unix()-1); err != nil {
+		t.Fatal("MarkProfileDeleted error: ", err)
+	}
+	if _, err := auth.RestoreProfile("expired key"); !errors.Is(err, authentication.ErrRestoreKeyNotFound) {
+		t.Fatal("RestoreProfile after grace period error: ", err)
+	}
+	if n, err := auth.PurgeDeletedProfiles(); err != nil || n != 1 {
+		t.Fatal("PurgeDeletedProfiles error: ", err, n)
+	}
+	if _, err := auth.ProfileByID(profile.ProfileID); !errors.Is(err, authentication.ErrProfileIdNotFound) {
+		t.Fatal("ProfileByID after purge error: ", err)
+	}
+}
+
+func testExport(dr authentication.DriverStorage, t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:export?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, err := drivers.NewGormAuditSink(db, []byte("token secret keu"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:       dr,
+		EmailLifeTimeSecond: 60 * 60 * 24,
+		ProfilePasswordSalt: []byte("test password salt"),
+		TokenSecretKey:      []byte("token secret keu"),
+		EventSubscriber:     sink,
+		AuditEventReader:    sink,
+		ExportHooks: map[string]authentication.ExportHook{
+			"orders": func(profileID authentication.ProfileID) (interface{}, error) {
+				return []string{"order-1"}, nil
+			},
+		},
+	})
+
+	profile, err := auth.Registration(regLogin, regEmail, regPass)
+	if err != nil {
+		t.Fatal("error profile registration", err)
+	}
+	tok, err := auth.NewToken(profile, 60)
+	if err != nil {
+		t.Fatal("NewToken error: ", err)
+	}
+	change, err := profile.RequestEmailChange(regPass, changeEmail)
+	if err != nil {
+		t.Fatal("RequestEmailChange error: ", err)
+	}
+
+	bs, err := profile.Export()
+	if err != nil {
+		t.Fatal("Export error: ", err)
+	}
+	for _, secret := range []string{string(tok), string(change.OldKey), string(change.NewKey), regPass} {
+		if strings.Contains(string(bs), secret) {
+			t.Fatal("Export contains secret: ", secret)
+		}
+	}
+
+	exp := new(authentication.ProfileExport)
+	if err := json.Unmarshal(bs, exp); err != nil {
+		t.Fatal("Export is not JSON: ", err)
+	}
+	if exp.Profile.ID != profile.ProfileID || exp.Profile.Login != regLogin || exp.Profile.Email != regEmail || exp.Profile.Status != authentication.StatusActive {
+		t.Fatal("Export profile: ", exp.Profile)
+	}
+	if len(exp.Sessions) != 1 || len(exp.EmailChanges) != 1 || exp.EmailChanges[0].NewEmail != changeEmail {
+		t.Fatal("Export sessions or email changes: ", exp.Sessions, exp.EmailChanges)
+	}
+	if exp.MFA.TOTP || exp.MFA.RecoveryCodesCount != 0 || len(exp.MFA.Passkeys) != 0 {
+		t.Fatal("Export MFA: ", exp.MFA)
+	}
+	if len(exp.Events) == 0 || exp.Sections["orders"] == nil {
+		t.Fatal("Export events or sections: ", exp.Events, exp.Sections)
+	}
+	for _, event := range exp.Events {
+		if _, ok := event.Data["token"]; ok {
+			t.Fatal("Export event contains token ID: ", event)
+		}
+	}
+
+	if err := profile.DeleteProfile(regPass); err != nil {
+		t.Fatal("DeleteProfile error: ", err)
+	}
+}
+
+func testAnonymize(dr authentication.DriverStorage, t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:anonymize?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, err := drivers.NewGormAuditSink(db, []byte("token secret keu"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonlPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	jsonl, err := drivers.OpenJSONLinesFile(jsonlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jsonl.Close()
+	auth := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:       dr,
+		EmailLifeTimeSecond: 60 * 60 * 24,
+		ProfilePasswordSalt: []byte("test password salt"),
+		TokenSecretKey:      []byte("token secret keu"),
+		EventSubscriber:     authentication.MultiSubscriber(sink, jsonl),
+		AuditScrubber:       authentication.MultiAuditScrubber(sink, jsonl),
+	}).WithMeta(authentication.RequestMeta{IP: "192.0.2.1", UserAgent: "test agent"})
+
+	profile, err := auth.Registration(regLogin, regEmail, regPass)
+	if err != nil {
+		t.Fatal("error profile registration", err)
+	}
+	if _, err := auth.Authentication(regLogin, regPass); err != nil {
+		t.Fatal("Authentication error: ", err)
+	}
+	tok, err := auth.NewToken(profile, 60)
+	if err != nil {
+		t.Fatal("NewToken error: ", err)
+	}
+	if _, err := profile.RequestEmailChange(regPass, changeEmail); err != nil {
+		t.Fatal("RequestEmailChange error: ", err)
+	}
+	//запись без профиля, например блокировка входа, узнается по логину
+	blocked := &authentication.Event{
+		Type: authentication.EventLoginBlocked,
+		Time: time.Now(),
+		Meta: authentication.RequestMeta{IP: "192.0.2.1"},
+		Data: map[string]string{"login": strings.ToUpper(regLogin)},
+	}
+	if err := authentication.MultiSubscriber(sink, jsonl).HandleEvent(blocked); err != nil {
+		t.Fatal("HandleEvent error: ", err)
+	}
+
+	if err := auth.AnonymizeProfile(profile.ProfileID); err != nil {
+		t.Fatal("AnonymizeProfile error: ", err)
+	}
+	//следующие неудачные входы со старым логином уже не относятся к профилю
+	unknown, err := sink.Events(0, 0)
+	if err != nil {
+		t.Fatal("Events error: ", err)
+	}
+	bs, err := os.ReadFile(jsonlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.ReadToken(tok); !errors.Is(err, authentication.ErrTokenNotFound) {
+		t.Fatal("ReadToken of anonymized profile error: ", err)
+	}
+	if _, err := auth.ProfileByID(profile.ProfileID); !errors.Is(err, authentication.ErrAccountAnonymized) {
+		t.Fatal("ProfileByID of anonymized profile error: ", err)
+	}
+	if err := auth.SetProfileStatus(profile.ProfileID, authentication.StatusActive, "", time.Time{}); !errors.Is(err, authentication.ErrAccountAnonymized) {
+		t.Fatal("SetProfileStatus of anonymized profile error: ", err)
+	}
+	if _, err := auth.Authentication(regLogin, regPass); !errors.Is(err, authentication.ErrWrongLoginOrPassword) {
+		t.Fatal("Authentication with erased login error: ", err)
+	}
+	login, err := dr.GetLogin(profile.ProfileID)
+	if err != nil || strings.Contains(login, regLogin) {
+		t.Fatal("login is not replaced: ", err, login)
+	}
+	if changes, err := dr.ListEmailChanges(profile.ProfileID); err != nil || len(changes) != 0 {
+		t.Fatal("email changes are not erased: ", err, changes)
+	}
+
+	events, err := sink.Events(profile.ProfileID, 0)
+	if err != nil || len(events) == 0 {
+		t.Fatal("Events error: ", err, events)
+	}
+	for _, event := range append(events, unknown...) {
+		if event.Type != authentication.EventProfileAnonymized && event.Type != authentication.EventAuditScrubbed &&
+			(event.Meta.IP != "" || event.Meta.UserAgent != "" || len(event.Data) != 0) {
+			t.Fatal("audit event is not scrubbed: ", event)
+		}
+	}
+	if err := sink.VerifyAuditChain(); err != nil {
+		t.Fatal("VerifyAuditChain after scrub error: ", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(bs)), "\n") {
+		event := &authentication.Event{}
+		if err := json.Unmarshal([]byte(line), event); err != nil {
+			t.Fatal("jsonl audit line error: ", err)
+		}
+		if event.Type != authentication.EventProfileAnonymized && (event.Meta.IP != "" || event.Meta.UserAgent != "" || len(event.Data) != 0) {
+			t.Fatal("jsonl audit event is not scrubbed: ", line)
+		}
+	}
+
+	//логин и E-MAIL снова свободны
+	again, err := auth.Registration(regLogin, regEmail, regPass)
+	if err != nil {
+		t.Fatal("Registration with erased login error: ", err)
+	}
+	for _, pid := range []authentication.ProfileID{again.ProfileID, profile.ProfileID} {
+		if err := dr.DelProfile(pid); err != nil {
+			t.Fatal("DelProfile error: ", err)
+		}
+	}
+}
+
+func testAttributes(dr authentication.DriverStorage, t *testing.T) {
+	schema, err := authentication.NewAttrSchema(
+		authentication.AttrDef{Name: "display_name", Type: authentication.AttrString, MaxLength: 20},
+		authentication.AttrDef{Name: "locale", Type: authentication.AttrString, Indexed: true, Default: "en"},
+		authentication.AttrDef{Name: "age", Type: authentication.AttrInt},
+		authentication.AttrDef{Name: "birthday", Type: authentication.AttrTime},
+	)
+	if err != nil {
+		t.Fatal("NewAttrSchema error: ", err)
+	}
+	if err := schema.Register(authentication.AttrDef{Name: "age", Type: authentication.AttrInt}); !errors.Is(err, authentication.ErrAttrSchemaInvalid) {
+		t.Fatal("Register duplicate error: ", err)
+	}
+	auth := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:       dr,
+		EmailLifeTimeSecond: 60 * 60 * 24,
+		ProfilePasswordSalt: []byte("test password salt"),
+		TokenSecretKey:      []byte("token secret keu"),
+		AttrSchema:          schema,
+	})
+
+	profile, err := auth.Registration(regLogin, regEmail, regPass)
+	if err != nil {
+		t.Fatal("error profile registration", err)
+	}
+	if locale, err := profile.GetAttr("locale"); err != nil || locale != "en" {
+		t.Fatal("GetAttr default error: ", err, locale)
+	}
+
+	birthday := time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC)
+	err = profile.SetAttrs(map[string]interface{}{"display_name": "Tester", "locale": "uk", "age": 30, "birthday": birthday})
+	if err != nil {
+		t.Fatal("SetAttrs error: ", err)
+	}
+	if age, err := profile.GetAttr("age"); err != nil || age != int64(30) {
+		t.Fatal("GetAttr int error: ", err, age)
+	}
+	if value, err := profile.GetAttr("birthday"); err != nil || !value.(time.Time).Equal(birthday) {
+		t.Fatal("GetAttr time error: ", err, value)
+	}
+
+	if err := profile.SetAttr("age", "thirty"); !errors.Is(err, authentication.ErrAttrType) {
+		t.Fatal("SetAttr wrong type error: ", err)
+	}
+	if err := profile.SetAttr("nickname", "x"); !errors.Is(err, authentication.ErrAttrUnknown) {
+		t.Fatal("SetAttr unknown error: ", err)
+	}
+	err = profile.SetAttrs(map[string]interface{}{"locale": "ru", "display_name": strings.Repeat("x", 21)})
+	if !errors.Is(err, authentication.ErrAttrTooLong) {
+		t.Fatal("SetAttrs too long error: ", err)
+	}
+	if locale, err := profile.GetAttr("locale"); err != nil || locale != "uk" {
+		t.Fatal("SetAttrs is not atomic: ", err, locale)
+	}
+
+	ids, err := auth.FindProfilesByAttr("locale", "uk", 0)
+	if err != nil || len(ids) != 1 || ids[0] != profile.ProfileID {
+		t.Fatal("FindProfilesByAttr error: ", err, ids)
+	}
+	if _, err := auth.FindProfilesByAttr("age", 30, 0); !errors.Is(err, authentication.ErrAttrNotIndexed) {
+		t.Fatal("FindProfilesByAttr not indexed error: ", err)
+	}
+
+	if err := profile.SetAttr("display_name", nil); err != nil {
+		t.Fatal("SetAttr nil error: ", err)
+	}
+	attrs, err := profile.Attrs()
+	if err != nil || len(attrs) != 3 || attrs["display_name"] != nil {
+		t.Fatal("Attrs error: ", err, attrs)
+	}
+
+	if err := profile.DeleteProfile(regPass); err != nil {
+		t.Fatal("DeleteProfile error: ", err)
+	}
+	if ids, err := auth.FindProfilesByAttr("locale", "uk", 0); err != nil || len(ids) != 0 {
+		t.Fatal("attributes are not deleted with profile: ", err, ids)
+	}
+}
+
+func testRBAC(dr authentication.DriverStorage, t *testing.T) {
+	auth := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:       dr,
+		EmailLifeTimeSecond: 60 * 60 * 24,
+		ProfilePasswordSalt: []byte("test password salt"),
+		TokenSecretKey:      []byte("token secret keu"),
+	})
+
+	if err := auth.SaveRole(authentication.RoleDef{Name: "viewer", Permissions: []authentication.Permission{"articles:read"}}); err != nil {
+		t.Fatal("SaveRole error: ", err)
+	}
+	editor := authentication.RoleDef{Name: "editor", Permissions: []authentication.Permission{"articles:write"}, Parents: []authentication.Role{"viewer"}}
+	if err := auth.SaveRole(editor); err != nil {
+		t.Fatal("SaveRole with parent error: ", err)
+	}
+	if err := auth.SaveRole(authentication.RoleDef{Name: "viewer", Parents: []authentication.Role{"editor"}}); !errors.Is(err, authentication.ErrRoleCycle) {
+		t.Fatal("SaveRole cycle error: ", err)
+	}
+	if err := auth.SaveRole(authentication.RoleDef{Name: "admin", Parents: []authentication.Role{"owner"}}); !errors.Is(err, authentication.ErrRoleNotFound) {
+		t.Fatal("SaveRole with unknown parent error: ", err)
+	}
+	if role, err := auth.Role("editor"); err != nil || len(role.Permissions) != 1 || len(role.Parents) != 1 || role.Parents[0] != "viewer" {
+		t.Fatal("Role error: ", err, role)
+	}
+
+	profile, err := auth.Registration(regLogin, regEmail, regPass)
+	if err != nil {
+		t.Fatal("error profile registration", err)
+	}
+	if ok, err := profile.HasPermission("articles:read"); err != nil || ok {
+		t.Fatal("HasPermission without roles: ", err, ok)
+	}
+	if err := auth.AssignRole(profile.ProfileID, "editor"); err != nil {
+		t.Fatal("AssignRole error: ", err)
+	}
+	if err := auth.AssignRole(profile.ProfileID, "editor"); err != nil {
+		t.Fatal("AssignRole repeat error: ", err)
+	}
+	if err := auth.AssignRole(profile.ProfileID, "owner"); !errors.Is(err, authentication.ErrRoleNotFound) {
+		t.Fatal("AssignRole unknown role error: ", err)
+	}
+
+	if ok, err := profile.HasPermission("articles:read"); err != nil || !ok {
+		t.Fatal("HasPermission inherited: ", err, ok)
+	}
+	if err := profile.RequirePermission("articles:delete"); !errors.Is(err, authentication.ErrPermissionDenied) {
+		t.Fatal("RequirePermission error: ", err)
+	}
+	if ok, err := profile.HasRole("viewer"); err != nil || !ok {
+		t.Fatal("HasRole inherited: ", err, ok)
+	}
+	if perms, err := profile.Permissions(); err != nil || len(perms) != 2 || perms[0] != "articles:read" {
+		t.Fatal("Permissions error: ", err, perms)
+	}
+
+	//изменение роли сразу видно в этом процессе
+	editor.Permissions = append(editor.Permissions, "articles:delete")
+	if err := auth.SaveRole(editor); err != nil {
+		t.Fatal("SaveRole update error: ", err)
+	}
+	if ok, err := profile.HasPermission("articles:delete"); err != nil || !ok {
+		t.Fatal("HasPermission after role update: ", err, ok)
+	}
+
+	if err := auth.RevokeRole(profile.ProfileID, "editor"); err != nil {
+		t.Fatal("RevokeRole error: ", err)
+	}
+	if roles, err := profile.Roles(); err != nil || len(roles) != 0 {
+		t.Fatal("Roles after revoke: ", err, roles)
+	}
+
+	if err := auth.AssignRole(profile.ProfileID, "viewer"); err != nil {
+		t.Fatal("AssignRole error: ", err)
+	}
+	for _, name := range []authentication.Role{"viewer", "editor"} {
+		if err := auth.DeleteRole(name); err != nil {
+			t.Fatal("DeleteRole error: ", err)
+		}
+	}
+	if roles, err := profile.Roles(); err != nil || len(roles) != 0 {
+		t.Fatal("Roles after DeleteRole: ", err, roles)
+	}
+	if roles, err := auth.Roles(); err != nil || len(roles) != 0 {
+		t.Fatal("Roles are not deleted: ", err, roles)
+	}
+
+	if err := profile.DeleteProfile(regPass); err != nil {
+		t.Fatal("DeleteProfile error: ", err)
+	}
+}
+
+func TestGormAuditSink(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:audit?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, err := drivers.NewGormAuditSink(db, []byte("token secret keu"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, typ := range []authentication.EventType{authentication.EventLoginFailed, authentication.EventLoginSucceeded} {
+		err := sink.HandleEvent(&authentication.Event{
+			Type:      typ,
+			Time:      time.Now(),
+			ProfileID: 7,
+			Meta:      authentication.RequestMeta{IP: "192.0.2.1"},
+			Data:      map[string]string{"login": regLogin},
+		})
+		if err != nil {
+			t.Fatal("HandleEvent error: ", err)
+		}
+	}
+
+	events, err := sink.Events(7, 10)
+	if err != nil || len(events) != 2 {
+		t.Fatal("Events error: ", err, events)
+	}
+	if events[0].Type != authentication.EventLoginSucceeded || events[0].Meta.IP != "192.0.2.1" || events[0].Data["login"] != regLogin {
+		t.Fatal("Events order or content: ", events[0])
+	}
+
+	if err := sink.VerifyAuditChain(); err != nil {
+		t.Fatal("VerifyAuditChain error: ", err)
+	}
+
+	//правка записи без пересчета хешей
+	db.Model(&drivers.GormAuditEventModel{}).Where("id = 1").Update("ip", "198.51.100.1")
+	var chainErr *authentication.AuditChainError
+	if err := sink.VerifyAuditChain(); !errors.As(err, &chainErr) || chainErr.EventID != 1 {
+		t.Fatal("VerifyAuditChain edited record error: ", err)
+	}
+	db.Model(&drivers.GormAuditEventModel{}).Where("id = 1").Update("ip", "192.0.2.1")
+
+	//стирание без подписанной записи о нем
+	orig := &drivers.GormAuditEventModel{}
+	db.First(orig, 1)
+	db.Model(&drivers.GormAuditEventModel{}).Where("id = 1").Updates(map[string]interface{}{"ip": "", "data": "null", "scrubbed": true})
+	if err := sink.VerifyAuditChain(); !errors.As(err, &chainErr) || chainErr.EventID != 1 {
+		t.Fatal("VerifyAuditChain unrecorded scrub error: ", err)
+	}
+	db.Save(orig)
+
+	//удаление последней записи обнаруживает подписанная отметка
+	db.Where("id = 2").Delete(&drivers.GormAuditEventModel{})
+	if err := sink.VerifyAuditChain(); !errors.As(err, &chainErr) || chainErr.EventID != 2 {
+		t.Fatal("VerifyAuditChain truncated chain error: ", err)
+	}
+}
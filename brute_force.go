@@ -0,0 +1,278 @@
+package authentication
+
+import (
+	"sync"
+	"time"
+)
+
+// RequestMeta сведения о клиенте, от имени которого выполняется вызов
+type RequestMeta struct {
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	DeviceID  string `json:"device_id,omitempty"`
+	//ChallengeResponse ответ клиента на CAPTCHA для ChallengeVerifier
+	ChallengeResponse string `json:"-"`
+	//ProofOfWork штамп решенной задачи из SolveProofOfWork
+	ProofOfWork string `json:"-"`
+}
+
+// WithMeta возвращает копию Auth, вызовы которой выполняются от имени клиента meta
+func (a *Auth) WithMeta(meta RequestMeta) *Auth {
+	c := *a
+	c.meta = meta
+	return &c
+}
+
+// AttemptPolicy пороги неудачных попыток. После BackoffAfter неудач каждая следующая
+// попытка ждет BaseDelaySecond, удваиваясь до MaxDelaySecond. После LockoutAfter
+// неудач вход блокируется на LockoutSecond. Счетчик забывается через WindowSecond
+// после последней неудачи
+type AttemptPolicy struct {
+	BackoffAfter    int
+	BaseDelaySecond int64
+	MaxDelaySecond  int64
+	LockoutAfter    int
+	LockoutSecond   int64
+	WindowSecond    int64
+}
+
+var (
+	defaultLoginAttemptPolicy = AttemptPolicy{
+		BackoffAfter:    3,
+		BaseDelaySecond: 1,
+		MaxDelaySecond:  60,
+		LockoutAfter:    10,
+		LockoutSecond:   15 * 60,
+		WindowSecond:    60 * 60,
+	}
+	//с одного IP могут входить многие пользователи за NAT
+	defaultIPAttemptPolicy = AttemptPolicy{
+		BackoffAfter:    20,
+		BaseDelaySecond: 1,
+		MaxDelaySecond:  60,
+		LockoutAfter:    100,
+		LockoutSecond:   15 * 60,
+		WindowSecond:    60 * 60,
+	}
+)
+
+func (p AttemptPolicy) withDefaults(def AttemptPolicy) AttemptPolicy {
+	if p.BackoffAfter <= 0 {
+		p.BackoffAfter = def.BackoffAfter
+	}
+	if p.BaseDelaySecond <= 0 {
+		p.BaseDelaySecond = def.BaseDelaySecond
+	}
+	if p.MaxDelaySecond <= 0 {
+		p.MaxDelaySecond = def.MaxDelaySecond
+	}
+	if p.LockoutAfter <= 0 {
+		p.LockoutAfter = def.LockoutAfter
+	}
+	if p.LockoutSecond <= 0 {
+		p.LockoutSecond = def.LockoutSecond
+	}
+	if p.WindowSecond <= 0 {
+		p.WindowSecond = def.WindowSecond
+	}
+	return p
+}
+
+// blockedUntil unix время, до которого попытки отклоняются, и признак блокировки
+func (p AttemptPolicy) blockedUntil(state *AttemptState) (int64, bool) {
+	if state.Failures >= p.LockoutAfter {
+		return state.LastFailure + p.LockoutSecond, true
+	}
+	if state.Failures < p.BackoffAfter {
+		return 0, false
+	}
+
+	delay := p.BaseDelaySecond
+	for i := p.BackoffAfter; i < state.Failures && delay < p.MaxDelaySecond; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelaySecond {
+		delay = p.MaxDelaySecond
+	}
+	return state.LastFailure + delay, false
+}
+
+type AttemptState struct {
+	Failures int
+	//LastFailure unix время последней неудачи
+	LastFailure int64
+}
+
+// AttemptStore хранит счетчики неудачных попыток по ключу (логин или IP)
+type AttemptStore interface {
+	//Fail должен атомарно увеличивать счетчик и возвращать новое состояние.
+	//Если последняя неудача старше windowSecond, счет начинается заново
+	Fail(key string, windowSecond int64) (*AttemptState, error)
+	//Get возвращает нулевое состояние для неизвестного или устаревшего ключа
+	Get(key string, windowSecond int64) (*AttemptState, error)
+	Reset(key string) error
+}
+
+// LoginBlockedError возвращает Authentication, пока попытки входа отклоняются.
+// errors.Is различает ErrLoginThrottled и ErrAccountLocked
+type LoginBlockedError struct {
+	Err        error
+	RetryAfter time.Time
+}
+
+func (e *LoginBlockedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *LoginBlockedError) Unwrap() error {
+	return e.Err
+}
+
+type bruteForce struct {
+	store       AttemptStore
+	loginPolicy AttemptPolicy
+	ipPolicy    AttemptPolicy
+}
+
+func newBruteForce(cfg AuthConfig) *bruteForce {
+	if cfg.AttemptStore == nil {
+		return nil
+	}
+	return &bruteForce{
+		store:       cfg.AttemptStore,
+		loginPolicy: cfg.LoginAttemptPolicy.withDefaults(defaultLoginAttemptPolicy),
+		ipPolicy:    cfg.IPAttemptPolicy.withDefaults(defaultIPAttemptPolicy),
+	}
+}
+
+type attemptKey struct {
+	key    string
+	policy AttemptPolicy
+	//account блокировка ключа блокирует учетную запись, а не клиента
+	account bool
+}
+
+func (b *bruteForce) loginKey(login string) attemptKey {
+	return attemptKey{"login:" + CanonicalLogin(login), b.loginPolicy, true}
+}
+
+func (b *bruteForce) keys(login string, meta RequestMeta) []attemptKey {
+	keys := []attemptKey{b.loginKey(login)}
+	if meta.IP != "" {
+		keys = append(keys, attemptKey{"ip:" + meta.IP, b.ipPolicy, false})
+	}
+	return keys
+}
+
+// check отклоняет попытку до проверки пароля
+func (b *bruteForce) check(login string, meta RequestMeta) error {
+	if b == nil {
+		return nil
+	}
+	now := time.Now().Unix()
+	for _, k := range b.keys(login, meta) {
+		state, err := b.store.Get(k.key, k.policy.WindowSecond)
+		if err != nil {
+			return err
+		}
+		until, locked := k.policy.blockedUntil(state)
+		if until <= now {
+			continue
+		}
+		if locked && k.account {
+			return &LoginBlockedError{Err: ErrAccountLocked, RetryAfter: time.Unix(until, 0)}
+		}
+		return &LoginBlockedError{Err: ErrLoginThrottled, RetryAfter: time.Unix(until, 0)}
+	}
+	return nil
+}
+
+func (b *bruteForce) fail(login string, meta RequestMeta) error {
+	if b == nil {
+		return nil
+	}
+	for _, k := range b.keys(login, meta) {
+		if _, err := b.store.Fail(k.key, k.policy.WindowSecond); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reset сбрасывает только счетчик учетной записи: вход в свой аккаунт
+// не должен обнулять счетчик IP, с которого подбирают чужие пароли
+func (b *bruteForce) reset(login string) error {
+	if b == nil {
+		return nil
+	}
+	return b.store.Reset(b.loginKey(login).key)
+}
+
+// memoryAttemptStoreSweep через сколько вызовов Fail удаляются устаревшие ключи
+const memoryAttemptStoreSweep = 1024
+
+// memoryAttemptStore подходит для одного процесса, счетчики теряются при перезапуске
+type memoryAttemptStore struct {
+	mu    sync.Mutex
+	items map[string]*memoryAttempt
+	fails int
+}
+
+type memoryAttempt struct {
+	AttemptState
+	windowSecond int64
+}
+
+func (item *memoryAttempt) expired(now int64) bool {
+	return item.LastFailure+item.windowSecond < now
+}
+
+func NewMemoryAttemptStore() AttemptStore {
+	return &memoryAttemptStore{items: make(map[string]*memoryAttempt)}
+}
+
+func (m *memoryAttemptStore) Fail(key string, windowSecond int64) (*AttemptState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().Unix()
+	m.fails++
+	if m.fails%memoryAttemptStoreSweep == 0 {
+		for k, item := range m.items {
+			if item.expired(now) {
+				delete(m.items, k)
+			}
+		}
+	}
+
+	item, ok := m.items[key]
+	if !ok || item.expired(now) {
+		item = &memoryAttempt{}
+		m.items[key] = item
+	}
+	item.windowSecond = windowSecond
+	item.Failures++
+	item.LastFailure = now
+	state := item.AttemptState
+	return &state, nil
+}
+
+func (m *memoryAttemptStore) Get(key string, windowSecond int64) (*AttemptState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, ok := m.items[key]
+	if !ok || item.LastFailure+windowSecond < time.Now().Unix() {
+		return new(AttemptState), nil
+	}
+	state := item.AttemptState
+	return &state, nil
+}
+
+func (m *memoryAttemptStore) Reset(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.items, key)
+	return nil
+}
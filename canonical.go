@@ -0,0 +1,183 @@
+package authentication
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// CanonicalLogin форма логина для сравнения: без пробелов по краям, NFKC и свертка регистра.
+// "Admin", "admin" и "ＡＤＭＩＮ" дают одну форму
+func CanonicalLogin(login string) string {
+	//свертка регистра может нарушить нормализацию, поэтому NFKC применяется повторно
+	return norm.NFKC.String(cases.Fold().String(norm.NFKC.String(strings.TrimSpace(login))))
+}
+
+// CanonicalEmail локальная часть приводится как логин, домен дополнительно переводится в punycode
+func CanonicalEmail(email string) string {
+	email = CanonicalLogin(email)
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return email
+	}
+
+	labels := strings.Split(email[at+1:], ".")
+	for i, label := range labels {
+		if !isASCII(label) {
+			labels[i] = "xn--" + punycode(label)
+		}
+	}
+	return email[:at+1] + strings.Join(labels, ".")
+}
+
+// loginConfusables наиболее частые символы, похожие на латиницу. Не полная таблица Unicode TR39
+var loginConfusables = strings.NewReplacer(
+	//кириллица
+	"а", "a", "в", "b", "е", "e", "ё", "e", "к", "k", "м", "m", "н", "h", "о", "o", "р", "p",
+	"с", "c", "т", "t", "у", "y", "х", "x", "і", "i", "ї", "i", "ј", "j", "ѕ", "s", "ԁ", "d",
+	"һ", "h", "ӏ", "l", "ԛ", "q", "ԝ", "w",
+	//греческий
+	"α", "a", "β", "b", "ε", "e", "ι", "i", "κ", "k", "ν", "v", "ο", "o", "ρ", "p", "τ", "t",
+	"υ", "u", "χ", "x",
+	//цифры и сочетания латиницы
+	"0", "o", "1", "l", "|", "l", "rn", "m", "vv", "w",
+)
+
+// LoginSkeleton форма логина, в которой похожие символы совпадают: "admin" и "аdmin"
+// с кириллической "а", "paypal" и "paypa1" дают один скелет
+func LoginSkeleton(login string) string {
+	return loginConfusables.Replace(CanonicalLogin(login))
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// параметры punycode из RFC 3492
+const (
+	punycodeBase        = 36
+	punycodeTMin        = 1
+	punycodeTMax        = 26
+	punycodeSkew        = 38
+	punycodeDamp        = 700
+	punycodeInitialBias = 72
+	punycodeInitialN    = 128
+)
+
+func punycode(label string) string {
+	runes := []rune(label)
+	out := make([]byte, 0, len(label)+8)
+	for _, r := range runes {
+		if r < 0x80 {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	if basic > 0 {
+		out = append(out, '-')
+	}
+
+	n, delta, bias := rune(punycodeInitialN), 0, punycodeInitialBias
+	for h := basic; h < len(runes); {
+		m := rune(0x10FFFF)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+		delta += int(m-n) * (h + 1)
+		n = m
+
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := punycodeBase; ; k += punycodeBase {
+				t := k - bias
+				if t < punycodeTMin {
+					t = punycodeTMin
+				} else if t > punycodeTMax {
+					t = punycodeTMax
+				}
+				if q < t {
+					break
+				}
+				out = append(out, punycodeDigit(t+(q-t)%(punycodeBase-t)))
+				q = (q - t) / (punycodeBase - t)
+			}
+			out = append(out, punycodeDigit(q))
+			bias = punycodeAdapt(delta, h+1, h == basic)
+			delta = 0
+			h++
+		}
+		delta++
+		n++
+	}
+	return string(out)
+}
+
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punycodeAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= punycodeDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > ((punycodeBase-punycodeTMin)*punycodeTMax)/2 {
+		delta /= punycodeBase - punycodeTMin
+		k += punycodeBase
+	}
+	return k + (punycodeBase-punycodeTMin+1)*delta/(delta+punycodeSkew)
+}
+
+// canonicalStorage приводит логин и E-MAIL к канонической форме перед каждым поиском профиля.
+// Формы для отображения сохраняются как есть
+type canonicalStorage struct {
+	DriverStorage
+}
+
+func canonicalDriverStorage(st DriverStorage) DriverStorage {
+	return &canonicalStorage{st}
+}
+
+func (c *canonicalStorage) IsUniqueLogin(login string) (bool, error) {
+	return c.DriverStorage.IsUniqueLogin(CanonicalLogin(login))
+}
+
+func (c *canonicalStorage) IsUniqueEmail(email string) (bool, error) {
+	return c.DriverStorage.IsUniqueEmail(CanonicalEmail(email))
+}
+
+func (c *canonicalStorage) GetPasswordByLogin(login string) (*ResultPasswordByLogin, error) {
+	return c.DriverStorage.GetPasswordByLogin(CanonicalLogin(login))
+}
+
+func (c *canonicalStorage) GetProfileIDByEmail(email string) (ProfileID, error) {
+	return c.DriverStorage.GetProfileIDByEmail(CanonicalEmail(email))
+}
+
+func (c *canonicalStorage) GetLoginByEmail(email string) (string, error) {
+	return c.DriverStorage.GetLoginByEmail(CanonicalEmail(email))
+}
+
+func (c *canonicalStorage) SetPasswordProfileByEmail(email string, password string) error {
+	return c.DriverStorage.SetPasswordProfileByEmail(CanonicalEmail(email), password)
+}
@@ -0,0 +1,89 @@
+package captcha
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/v-grabko1999/authentication"
+)
+
+const (
+	HCaptchaVerifyURL  = "https://api.hcaptcha.com/siteverify"
+	ReCaptchaVerifyURL = "https://www.google.com/recaptcha/api/siteverify"
+)
+
+// Config hCaptcha и reCAPTCHA проверяют ответ одинаково: POST формы secret, response
+// и remoteip на адрес проверки
+type Config struct {
+	//VerifyURL адрес проверки, например HCaptchaVerifyURL
+	VerifyURL string
+	Secret    string
+	//MinScore минимальная оценка reCAPTCHA v3, 0 - оценка не проверяется
+	MinScore float64
+	//Hostname если задан, должен совпадать с сайтом, на котором решена CAPTCHA
+	Hostname string
+	//Client по умолчанию http.Client с таймаутом 10 секунд
+	Client *http.Client
+}
+
+func New(cfg Config) authentication.ChallengeVerifier {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &HTTPVerifier{cfg: cfg}
+}
+
+func NewHCaptcha(secret string) authentication.ChallengeVerifier {
+	return New(Config{VerifyURL: HCaptchaVerifyURL, Secret: secret})
+}
+
+func NewReCaptcha(secret string, minScore float64) authentication.ChallengeVerifier {
+	return New(Config{VerifyURL: ReCaptchaVerifyURL, Secret: secret, MinScore: minScore})
+}
+
+type HTTPVerifier struct {
+	cfg Config
+}
+
+type verifyResponse struct {
+	Success    bool     `json:"success"`
+	Score      *float64 `json:"score"`
+	Hostname   string   `json:"hostname"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+func (v *HTTPVerifier) VerifyChallenge(response string, meta authentication.RequestMeta) error {
+	form := url.Values{"secret": {v.cfg.Secret}, "response": {response}}
+	if meta.IP != "" {
+		form.Set("remoteip", meta.IP)
+	}
+
+	resp, err := v.cfg.Client.PostForm(v.cfg.VerifyURL, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("captcha verify: unexpected status %d", resp.StatusCode)
+	}
+
+	res := new(verifyResponse)
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return err
+	}
+
+	if !res.Success {
+		return fmt.Errorf("%w: %s", authentication.ErrChallengeFailed, strings.Join(res.ErrorCodes, ", "))
+	}
+	if v.cfg.Hostname != "" && res.Hostname != v.cfg.Hostname {
+		return fmt.Errorf("%w: hostname %q", authentication.ErrChallengeFailed, res.Hostname)
+	}
+	if v.cfg.MinScore > 0 && (res.Score == nil || *res.Score < v.cfg.MinScore) {
+		return fmt.Errorf("%w: score is too low", authentication.ErrChallengeFailed)
+	}
+	return nil
+}
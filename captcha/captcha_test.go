@@ -0,0 +1,53 @@
+package captcha_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/v-grabko1999/authentication"
+	"github.com/v-grabko1999/authentication/captcha"
+)
+
+// siteverifyStandIn принимает ответ "ok" с оценкой 0.9 и ответ "low" с оценкой 0.1
+func siteverifyStandIn(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.FormValue("secret") != "secret" {
+			t.Error("siteverify bad request: ", r.Method, r.Form)
+		}
+		if r.FormValue("remoteip") != "192.0.2.1" {
+			t.Error("siteverify remoteip: ", r.FormValue("remoteip"))
+		}
+		switch r.FormValue("response") {
+		case "ok":
+			fmt.Fprint(w, `{"success":true,"score":0.9,"hostname":"example.com"}`)
+		case "low":
+			fmt.Fprint(w, `{"success":true,"score":0.1,"hostname":"example.com"}`)
+		default:
+			fmt.Fprint(w, `{"success":false,"error-codes":["invalid-input-response"]}`)
+		}
+	}))
+}
+
+func TestHTTPVerifier(t *testing.T) {
+	srv := siteverifyStandIn(t)
+	defer srv.Close()
+	meta := authentication.RequestMeta{IP: "192.0.2.1"}
+
+	v := captcha.New(captcha.Config{VerifyURL: srv.URL, Secret: "secret", MinScore: 0.5, Hostname: "example.com"})
+	if err := v.VerifyChallenge("ok", meta); err != nil {
+		t.Fatal("VerifyChallenge error: ", err)
+	}
+	for _, response := range []string{"low", "bad"} {
+		if err := v.VerifyChallenge(response, meta); !errors.Is(err, authentication.ErrChallengeFailed) {
+			t.Fatal("VerifyChallenge rejected response error: ", response, err)
+		}
+	}
+
+	v = captcha.New(captcha.Config{VerifyURL: srv.URL, Secret: "secret", Hostname: "other.example.com"})
+	if err := v.VerifyChallenge("ok", meta); !errors.Is(err, authentication.ErrChallengeFailed) {
+		t.Fatal("VerifyChallenge hostname error: ", err)
+	}
+}
@@ -0,0 +1,140 @@
+package authentication
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errCBOR = errors.New("cbor: malformed data")
+
+// cborMaxDepth защищает от слишком глубокой вложенности во входных данных
+const cborMaxDepth = 16
+
+// cborDecode минимальный декодер CBOR (RFC 8949) для attestationObject и COSE ключей WebAuthn.
+// Возвращает значение и количество прочитанных байт.
+// Целые числа декодируются в int64, строки байт в []byte, карты в map[interface{}]interface{}
+func cborDecode(bs []byte) (interface{}, int, error) {
+	return cborDecodeItem(bs, 0)
+}
+
+func cborHead(bs []byte) (major byte, arg uint64, n int, err error) {
+	if len(bs) < 1 {
+		return 0, 0, 0, errCBOR
+	}
+	major = bs[0] >> 5
+	info := bs[0] & 0x1f
+	switch {
+	case info < 24:
+		return major, uint64(info), 1, nil
+	case info == 24:
+		if len(bs) < 2 {
+			return 0, 0, 0, errCBOR
+		}
+		return major, uint64(bs[1]), 2, nil
+	case info == 25:
+		if len(bs) < 3 {
+			return 0, 0, 0, errCBOR
+		}
+		return major, uint64(binary.BigEndian.Uint16(bs[1:])), 3, nil
+	case info == 26:
+		if len(bs) < 5 {
+			return 0, 0, 0, errCBOR
+		}
+		return major, uint64(binary.BigEndian.Uint32(bs[1:])), 5, nil
+	case info == 27:
+		if len(bs) < 9 {
+			return 0, 0, 0, errCBOR
+		}
+		return major, binary.BigEndian.Uint64(bs[1:]), 9, nil
+	}
+	//неопределенная длина в WebAuthn не используется
+	return 0, 0, 0, errCBOR
+}
+
+func cborDecodeItem(bs []byte, depth int) (interface{}, int, error) {
+	if depth > cborMaxDepth {
+		return nil, 0, errCBOR
+	}
+	major, arg, n, err := cborHead(bs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, 0, errCBOR
+		}
+		return int64(arg), n, nil
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, 0, errCBOR
+		}
+		return -1 - int64(arg), n, nil
+	case 2, 3:
+		if arg > uint64(len(bs)-n) {
+			return nil, 0, errCBOR
+		}
+		end := n + int(arg)
+		if major == 3 {
+			return string(bs[n:end]), end, nil
+		}
+		return append([]byte(nil), bs[n:end]...), end, nil
+	case 4:
+		if arg > uint64(len(bs)) {
+			return nil, 0, errCBOR
+		}
+		arr := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			v, m, err := cborDecodeItem(bs[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			arr = append(arr, v)
+			n += m
+		}
+		return arr, n, nil
+	case 5:
+		if arg > uint64(len(bs)) {
+			return nil, 0, errCBOR
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, kn, err := cborDecodeItem(bs[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += kn
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, 0, errCBOR
+			}
+			v, vn, err := cborDecodeItem(bs[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += vn
+			m[k] = v
+		}
+		return m, n, nil
+	case 6:
+		//тег игнорируется, возвращается помеченное значение
+		v, m, err := cborDecodeItem(bs[n:], depth+1)
+		return v, n + m, err
+	case 7:
+		//числа с плавающей точкой в WebAuthn не используются
+		if n != 1 {
+			break
+		}
+		switch arg {
+		case 20:
+			return false, n, nil
+		case 21:
+			return true, n, nil
+		case 22, 23:
+			return nil, n, nil
+		}
+	}
+	return nil, 0, errCBOR
+}
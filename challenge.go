@@ -0,0 +1,138 @@
+package authentication
+
+// ChallengeAction вызов, перед которым может потребоваться проверка человека
+type ChallengeAction string
+
+const (
+	ChallengeRegistration   ChallengeAction = "registration"
+	ChallengeAuthentication ChallengeAction = "authentication"
+	ChallengeForgotPassword ChallengeAction = "forgot_password"
+)
+
+// ChallengeVerifier проверяет ответ клиента на CAPTCHA. Ответ передается в
+// RequestMeta.ChallengeResponse. Отклоненный ответ должен давать ErrChallengeFailed
+type ChallengeVerifier interface {
+	VerifyChallenge(response string, meta RequestMeta) error
+}
+
+// RiskSignals счетчики берутся из AttemptStore и равны нулю, если он не задан
+type RiskSignals struct {
+	Action ChallengeAction
+	Meta   RequestMeta
+	//LoginFailures неудачные входы логина за окно LoginAttemptPolicy
+	LoginFailures int
+	//IPFailures неудачные входы с IP клиента за окно IPAttemptPolicy
+	IPFailures int
+	//IPRequests вызовы Registration или ForgotPassword с IP клиента за окно IPAttemptPolicy, включая текущий
+	IPRequests int
+	//NewIP вход из сети, с которой профиль еще не входил. Требует NewDeviceDetection
+	NewIP bool
+}
+
+// ChallengePolicy решает по сигналам риска, нужна ли проверка человека
+type ChallengePolicy func(signals *RiskSignals) bool
+
+// DefaultChallengePolicy требует проверку после 3 неудачных входов, после 5 регистраций
+// или восстановлений пароля с одного IP и при входе из новой сети
+func DefaultChallengePolicy(signals *RiskSignals) bool {
+	return signals.LoginFailures >= 3 || signals.IPFailures >= 3 || signals.IPRequests > 5 || signals.NewIP
+}
+
+type challenge struct {
+	verifier ChallengeVerifier
+	policy   ChallengePolicy
+}
+
+func newChallenge(cfg AuthConfig) *challenge {
+	if cfg.ChallengeVerifier == nil {
+		return nil
+	}
+	policy := cfg.ChallengePolicy
+	if policy == nil {
+		policy = DefaultChallengePolicy
+	}
+	return &challenge{verifier: cfg.ChallengeVerifier, policy: policy}
+}
+
+// requireChallenge вызывается до проверки пароля, profileID равен 0 для неизвестного логина
+func (a *Auth) requireChallenge(action ChallengeAction, login string, profileID ProfileID) error {
+	if a.challenge == nil {
+		return nil
+	}
+	signals, err := a.riskSignals(action, login, profileID)
+	if err != nil {
+		return err
+	}
+	if !a.challenge.policy(signals) {
+		return nil
+	}
+
+	if a.meta.ChallengeResponse == "" {
+		a.emit(EventChallengeFailed, profileID, map[string]string{"action": string(action), "reason": "required"})
+		return ErrChallengeRequired
+	}
+	if err := a.challenge.verifier.VerifyChallenge(a.meta.ChallengeResponse, a.meta); err != nil {
+		a.emit(EventChallengeFailed, profileID, map[string]string{"action": string(action), "reason": "rejected"})
+		return err
+	}
+	return nil
+}
+
+func (a *Auth) riskSignals(action ChallengeAction, login string, profileID ProfileID) (*RiskSignals, error) {
+	signals := &RiskSignals{Action: action, Meta: a.meta}
+
+	if b := a.bruteForce; b != nil {
+		for _, k := range b.keys(login, a.meta) {
+			if k.account && login == "" {
+				continue
+			}
+			state, err := b.store.Get(k.key, k.policy.WindowSecond)
+			if err != nil {
+				return nil, err
+			}
+			if k.account {
+				signals.LoginFailures = state.Failures
+				continue
+			}
+			signals.IPFailures = state.Failures
+
+			//счетчик неудач AttemptStore считает здесь все вызовы с IP
+			if action != ChallengeAuthentication {
+				state, err := b.store.Fail(string(action)+":"+k.key, k.policy.WindowSecond)
+				if err != nil {
+					return nil, err
+				}
+				signals.IPRequests = state.Failures
+			}
+		}
+	}
+
+	if action == ChallengeAuthentication && a.newDeviceDetection && a.meta.IP != "" {
+		newIP, err := a.newIP(profileID)
+		if err != nil {
+			return nil, err
+		}
+		signals.NewIP = newIP
+	}
+	return signals, nil
+}
+
+// newIP неизвестный логин и профиль без подтвержденных устройств одинаково считаются новой сетью,
+// иначе ответ выдавал бы существование логина
+func (a *Auth) newIP(profileID ProfileID) (bool, error) {
+	if profileID == 0 {
+		return true, nil
+	}
+	devices, err := a.st.ListKnownDevices(profileID)
+	if err != nil {
+		return false, err
+	}
+
+	rng := ipRange(a.meta.IP)
+	for _, dev := range devices {
+		if dev.Confirmed && dev.IPRange == rng {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -1,5 +1,7 @@
 package authentication
 
+import "time"
+
 type DriverStorage interface {
 	//EmailReadSecretKey должен возвращать такие стандартные ошибки:
 	//authentication.ErrEmailSecretKeyNotFound - если записи с таким ключом в базе не найдено
@@ -35,22 +37,270 @@ type DriverStorage interface {
 	//authentication.ErrProfileIdNotFound - если профиля с таким ID не существует
 	GetLogin(profileID ProfileID) (login string, err error)
 
-	EmailNewSecretKey(key EmailSecretKey, email string, lifetime int64) error
+	//EmailNewSecretKey сохраняет ключ вместе с назначением
+	EmailNewSecretKey(key EmailSecretKey, purpose EmailKeyPurpose, email string, lifetime int64) error
 	EmailDeleteSecretKey(key EmailSecretKey) error
+
+	//EmailConsumeSecretKey должен атомарно читать и удалять ключ, так чтобы из
+	//нескольких одновременных вызовов успешным был только один.
+	//Ключ с другим назначением не гасится.
+	//Должен возвращать такие стандартные ошибки:
+	//authentication.ErrEmailSecretKeyNotFound - если ключ не найден или уже погашен
+	//authentication.ErrEmailSecretKeyNotFound - если назначение ключа не совпадает
+	//authentication.ErrEmailSecretKeyNotFound - если время жизни ключа истекло
+	EmailConsumeSecretKey(key EmailSecretKey, purpose EmailKeyPurpose) (email string, err error)
+
+	//EmailNewSecretCode должен заменять запись с тем же ключом и обнулять счетчик попыток
+	EmailNewSecretCode(key EmailSecretKey, purpose EmailKeyPurpose, email string, codeHash string, lifetime int64) error
+
+	//EmailReadSecretCode должен возвращать такие стандартные ошибки:
+	//authentication.ErrEmailSecretKeyNotFound - если записи с таким ключом в базе не найдено
+	//authentication.ErrEmailSecretKeyNotFound - если время жизни кода истекло
+	EmailReadSecretCode(key EmailSecretKey) (res *ResultSecretCode, err error)
+
+	//EmailIncSecretCodeAttempts атомарно увеличивает счетчик неверных попыток и возвращает новое значение
+	EmailIncSecretCodeAttempts(key EmailSecretKey) (attempts int, err error)
+
 	NewToken(tokenID TokenID, profileID ProfileID, lifeTime TokenLifeTime) error
 	DelToken(tokenID TokenID, profileID ProfileID) error
+	DelTokensByProfileID(profileID ProfileID) error
+
+	//ReadTokenAuthTime возвращает unix время последней проверки учетных данных владельцем токена.
+	//Должен возвращать такие стандартные ошибки:
+	//authentication.ErrTokenNotFound - если токена не существует или время его жизни истекло
+	ReadTokenAuthTime(tokenID TokenID) (authAt int64, err error)
+	SetTokenAuthTime(tokenID TokenID, authAt int64) error
+
+	//Поиск профиля по login и email получает CanonicalLogin и CanonicalEmail и сравнивает их
+	//с сохраненными каноническими формами. NewProfile и SetEmailByProfileID получают формы
+	//для отображения и должны сохранять рядом канонические формы и LoginSkeleton
 	IsUniqueLogin(login string) (bool, error)
 	IsUniqueEmail(email string) (bool, error)
+	IsUniqueLoginSkeleton(skeleton string) (bool, error)
 
+	//NewProfile должен возвращать такие стандартные ошибки, в том числе когда параллельная
+	//регистрация заняла логин или E-MAIL после проверки IsUniqueLogin и IsUniqueEmail:
+	//authentication.ErrLoginNotUnique - если логин занят
+	//authentication.ErrEmailNotUnique - если E-MAIL занят
 	NewProfile(login, email, password string) (ProfileID, error)
 	DelProfile(profileID ProfileID) error
 	ProfileExist(profileID ProfileID) (exists bool, err error)
 	SetPasswordProfileByEmail(email string, password string) error
 	SetPasswordProfileByProfileID(profileID ProfileID, password string) error
+
+	//SetEmailByProfileID должен сбрасывать признак подтверждения E-MAIL и возвращать
+	//authentication.ErrEmailNotUnique, если E-MAIL занят другим профилем
 	SetEmailByProfileID(profileID ProfileID, email string) error
+
+	//IsEmailVerified должен возвращать такие стандартные ошибки:
+	//authentication.ErrProfileIdNotFound - если профиля с таким ID не существует
+	IsEmailVerified(profileID ProfileID) (verified bool, err error)
+	SetEmailVerified(profileID ProfileID, verified bool) error
+
+	NewEmailChange(change *EmailChange) error
+
+	//GetEmailChangeByKey ищет заявку по OldKey, NewKey или UndoKey.
+	//Должен возвращать такие стандартные ошибки:
+	//authentication.ErrEmailChangeNotFound - если заявки с таким ключом не существует
+	GetEmailChangeByKey(key EmailSecretKey) (*EmailChange, error)
+
+	//UpdateEmailChange сохраняет State, UndoKey и UndoExpiries, только если текущее состояние равно prevState.
+	//Должен возвращать такие стандартные ошибки:
+	//authentication.ErrEmailChangeConflict - если состояние заявки уже изменилось
+	UpdateEmailChange(change *EmailChange, prevState EmailChangeState) error
+
+	//NewMFAChallenge method способ первого фактора, его ReadMFAChallenge возвращает в Method
+	NewMFAChallenge(challenge MFAChallengeID, profileID ProfileID, method string, lifetime int64) error
+
+	//ReadMFAChallenge должен возвращать такие стандартные ошибки:
+	//authentication.ErrMFAChallengeNotFound - если вызова не существует или время его жизни истекло
+	ReadMFAChallenge(challenge MFAChallengeID) (res *ResultMFAChallenge, err error)
+
+	//IncMFAChallengeAttempts атомарно увеличивает счетчик попыток и возвращает новое значение
+	IncMFAChallengeAttempts(challenge MFAChallengeID) (attempts int, err error)
+
+	//ConsumeMFAChallenge должен атомарно удалять вызов, успешным может быть только один вызов.
+	//Должен возвращать такие стандартные ошибки:
+	//authentication.ErrMFAChallengeNotFound - если вызова не существует или он уже погашен
+	ConsumeMFAChallenge(challenge MFAChallengeID) (ProfileID, error)
+	DelMFAChallenge(challenge MFAChallengeID) error
+
+	//SetMFAChallengeCode сохраняет хеш кода второго фактора из письма и сбрасывает его попытки.
+	//Должен атомарно возвращать ok false, если предыдущий код отправлен менее resendInterval секунд назад
+	SetMFAChallengeCode(challenge MFAChallengeID, codeHash string, lifetime int64, resendInterval int64) (ok bool, err error)
+	IncMFAChallengeCodeAttempts(challenge MFAChallengeID) (attempts int, err error)
+
+	//NewKnownDevice сохраняет устройство и заполняет device.ID
+	NewKnownDevice(device *KnownDevice) error
+	ListKnownDevices(profileID ProfileID) (devices []*KnownDevice, err error)
+	SeenKnownDevice(id int64) error
+	SetKnownDeviceToken(id int64, tokenID TokenID) error
+
+	//ConfirmKnownDevice должен атомарно подтверждать устройство и возвращать такие стандартные ошибки:
+	//authentication.ErrKnownDeviceNotFound - если ключ не найден, уже использован или истек
+	ConfirmKnownDevice(confirmKey EmailSecretKey) (device *KnownDevice, err error)
+
+	//GetKnownDeviceByRevokeKey должен возвращать такие стандартные ошибки:
+	//authentication.ErrKnownDeviceNotFound - если ключ не найден
+	GetKnownDeviceByRevokeKey(revokeKey EmailSecretKey) (device *KnownDevice, err error)
+	DelKnownDevice(id int64, profileID ProfileID) error
+
+	SetMFAEmail(profileID ProfileID, enabled bool) error
+	IsMFAEmailEnabled(profileID ProfileID) (enabled bool, err error)
+
+	//SetTOTP сохраняет зашифрованный секрет как неподтвержденный, заменяя прежний
+	SetTOTP(profileID ProfileID, secret string) error
+
+	//GetTOTP должен возвращать такие стандартные ошибки:
+	//authentication.ErrTOTPNotFound - если у профиля нет TOTP
+	GetTOTP(profileID ProfileID) (res *ResultTOTP, err error)
+	ConfirmTOTP(profileID ProfileID) error
+
+	//UseTOTPStep должен атомарно сохранять step, только если он больше последнего использованного
+	UseTOTPStep(profileID ProfileID, step int64) (ok bool, err error)
+	DelTOTP(profileID ProfileID) error
+
+	//SetRecoveryCodes заменяет все хеши кодов восстановления профиля
+	SetRecoveryCodes(profileID ProfileID, hashes []string) error
+
+	//UseRecoveryCode должен атомарно удалять код, успешным может быть только один вызов
+	UseRecoveryCode(profileID ProfileID, hash string) (ok bool, err error)
+	CountRecoveryCodes(profileID ProfileID) (count int, err error)
+
+	//NewWebAuthnSession сохраняет challenge церемонии WebAuthn, profileID 0 - вход
+	NewWebAuthnSession(challenge string, profileID ProfileID, lifetime int64) error
+
+	//ConsumeWebAuthnSession должен атомарно удалять сессию и возвращать такие стандартные ошибки:
+	//authentication.ErrWebAuthnSessionNotFound - если сессия не найдена, уже использована или истекла
+	ConsumeWebAuthnSession(challenge string) (profileID ProfileID, err error)
+
+	//NewWebAuthnCredential должен возвращать такие стандартные ошибки:
+	//authentication.ErrWebAuthnCredentialExists - если ключ с таким ID уже зарегистрирован
+	NewWebAuthnCredential(cred *WebAuthnCredential) error
+
+	//GetWebAuthnCredential должен возвращать такие стандартные ошибки:
+	//authentication.ErrWebAuthnCredentialNotFound - если ключ не найден
+	GetWebAuthnCredential(id string) (cred *WebAuthnCredential, err error)
+	ListWebAuthnCredentials(profileID ProfileID) (creds []*WebAuthnCredential, err error)
+
+	//UpdateWebAuthnSignCount должен атомарно сохранять signCount, только если он больше сохраненного
+	//(или оба равны 0), иначе возвращать authentication.ErrWebAuthnSignCount
+	UpdateWebAuthnSignCount(id string, signCount uint32) error
+
+	//DelWebAuthnCredential должен возвращать такие стандартные ошибки:
+	//authentication.ErrWebAuthnCredentialNotFound - если ключ не найден у профиля
+	DelWebAuthnCredential(id string, profileID ProfileID) error
+
+	//GetProfileStatus должен возвращать такие стандартные ошибки:
+	//authentication.ErrProfileIdNotFound - если профиля с таким ID не существует
+	GetProfileStatus(profileID ProfileID) (status *ProfileStatus, err error)
+	SetProfileStatus(profileID ProfileID, status *ProfileStatus) error
+
+	//MarkProfileDeleted должен переводить профиль в StatusPendingDeletion до purgeAt
+	//и сохранять ключ восстановления
+	MarkProfileDeleted(profileID ProfileID, restoreKey EmailSecretKey, purgeAt int64) error
+	//RestoreProfile должен атомарно возвращать профиль в StatusActive и гасить ключ.
+	//Должен возвращать такие стандартные ошибки:
+	//authentication.ErrRestoreKeyNotFound - если ключа нет или срок восстановления истек
+	RestoreProfile(restoreKey EmailSecretKey) (ProfileID, error)
+	//ListProfilesToPurge возвращает удаленные профили, чей срок восстановления истек до before
+	ListProfilesToPurge(before int64, limit int) ([]ProfileID, error)
+
+	//UseProofOfWorkNonce должен атомарно помечать задачу использованной до expiries
+	//и возвращать authentication.ErrProofOfWorkUsed при повторе
+	UseProofOfWorkNonce(nonce string, expiries int64) error
+
+	//GetProfileRecord должен возвращать такие стандартные ошибки:
+	//authentication.ErrProfileIdNotFound - если профиля с таким ID не существует
+	GetProfileRecord(profileID ProfileID) (res *ResultProfile, err error)
+	//ListTokens возвращает неистекшие токены профиля
+	ListTokens(profileID ProfileID) (tokens []*ResultToken, err error)
+	//ListEmailChanges возвращает все заявки на смену E-MAIL профиля, от старых к новым
+	ListEmailChanges(profileID ProfileID) (changes []*EmailChange, err error)
+
+	//AnonymizeProfile должен атомарно заменить логин и E-MAIL профиля на login и email, стереть пароль,
+	//удалить токены, секреты MFA, ключи доступа, устройства, атрибуты, роли, заявки на смену E-MAIL и ключи из писем
+	//на старый E-MAIL, и перевести профиль в StatusAnonymized. Строка профиля и ее ID сохраняются.
+	//Должен возвращать такие стандартные ошибки:
+	//authentication.ErrProfileIdNotFound - если профиля с таким ID не существует
+	AnonymizeProfile(profileID ProfileID, login, email string) error
+
+	//GetAttrs возвращает все атрибуты профиля в виде строк, пустой map - если атрибутов нет
+	GetAttrs(profileID ProfileID) (attrs map[string]string, err error)
+	//SetAttrs должен атомарно сохранять все атрибуты, nil удаляет атрибут.
+	//Должен возвращать такие стандартные ошибки:
+	//authentication.ErrProfileIdNotFound - если профиля с таким ID не существует
+	SetAttrs(profileID ProfileID, attrs map[string]*ResultAttr) error
+	//FindProfilesByAttr ищет только среди атрибутов, сохраненных с Indexed. limit <= 0 - без ограничения
+	FindProfilesByAttr(name, value string, limit int) (profileIDs []ProfileID, err error)
+
+	//SaveRole создает роль или атомарно заменяет ее разрешения и родителей
+	SaveRole(role *RoleDef) error
+	//DelRole удаляет роль вместе с ее назначениями и наследованием от нее.
+	//Должен возвращать такие стандартные ошибки:
+	//authentication.ErrRoleNotFound - если роли не существует
+	DelRole(name Role) error
+	//GetRole должен возвращать такие стандартные ошибки:
+	//authentication.ErrRoleNotFound - если роли не существует
+	GetRole(name Role) (role *RoleDef, err error)
+	ListRoles() (roles []*RoleDef, err error)
+	//AssignRole повторное назначение не является ошибкой.
+	//Должен возвращать такие стандартные ошибки:
+	//authentication.ErrRoleNotFound - если роли не существует
+	//authentication.ErrProfileIdNotFound - если профиля с таким ID не существует
+	AssignRole(profileID ProfileID, role Role) error
+	RevokeRole(profileID ProfileID, role Role) error
+	//ListProfileRoles возвращает роли, назначенные профилю напрямую
+	ListProfileRoles(profileID ProfileID) (roles []Role, err error)
 }
 
 type ResultPasswordByLogin struct {
 	ProfileID ProfileID
-	Password  string
+	//Login форма для отображения, с ней вычислен хеш пароля
+	Login         string
+	Password      string
+	EmailVerified bool
+}
+
+type ResultSecretCode struct {
+	Email    string
+	CodeHash string
+	Attempts int
+}
+
+type ResultMFAChallenge struct {
+	ProfileID ProfileID
+	//Method способ первого фактора
+	Method   string
+	Attempts int
+
+	CodeHash     string
+	CodeExpiries int64
+	CodeAttempts int
+}
+
+type ResultTOTP struct {
+	Secret    string
+	Confirmed bool
+	LastStep  int64
+}
+
+type ResultProfile struct {
+	Login         string
+	Email         string
+	EmailVerified bool
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+type ResultAttr struct {
+	Value string
+	//Indexed значение участвует в FindProfilesByAttr
+	Indexed bool
+}
+
+type ResultToken struct {
+	TokenID  TokenID
+	Expiries int64
+	AuthAt   int64
 }
@@ -2,6 +2,7 @@ package drivers
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -11,10 +12,13 @@ import (
 )
 
 func NewChGorm(ch *cache.Cache, db *gorm.DB) (authentication.DriverStorage, error) {
-	err := db.AutoMigrate(&GormProfileModel{}, &GormTokenModel{}, &GormEmailSecretKeyModel{})
+	err := autoMigrate(db)
 	if err != nil {
 		return nil, err
 	}
+	if err := backfillCanonical(db); err != nil {
+		return nil, err
+	}
 	dr := new(ChGormDriver)
 	dr.db = db
 	dr.cache = ch
@@ -28,8 +32,8 @@ type ChGormDriver struct {
 	poolInt64 *authentication.Int64ToBytes
 }
 
-func (ch *ChGormDriver) EmailNewSecretKey(key authentication.EmailSecretKey, email string, lifetime int64) error {
-	err := ch.GormDriver.EmailNewSecretKey(key, email, lifetime)
+func (ch *ChGormDriver) EmailNewSecretKey(key authentication.EmailSecretKey, purpose authentication.EmailKeyPurpose, email string, lifetime int64) error {
+	err := ch.GormDriver.EmailNewSecretKey(key, purpose, email, lifetime)
 	if err != nil {
 		return err
 	}
@@ -72,6 +76,18 @@ func (ch *ChGormDriver) EmailDeleteSecretKey(key authentication.EmailSecretKey)
 	return ch.GormDriver.EmailDeleteSecretKey(key)
 }
 
+// EmailConsumeSecretKey гасит ключ в базе данных, кеш только очищается
+func (ch *ChGormDriver) EmailConsumeSecretKey(key authentication.EmailSecretKey, purpose authentication.EmailKeyPurpose) (string, error) {
+	email, err := ch.GormDriver.EmailConsumeSecretKey(key, purpose)
+	if delErr := ch.cache.Del([]byte(key)); delErr != nil && err == nil {
+		err = delErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return email, nil
+}
+
 func (ch *ChGormDriver) NewToken(tokenID authentication.TokenID, profileID authentication.ProfileID, lifeTime authentication.TokenLifeTime) error {
 	if err := ch.GormDriver.NewToken(tokenID, profileID, lifeTime); err != nil {
 		return err
@@ -86,6 +102,72 @@ func (ch *ChGormDriver) DelToken(tokenID authentication.TokenID, profileID authe
 
 	return ch.cache.Del([]byte(fmt.Sprint("token_", tokenID)))
 }
+func (ch *ChGormDriver) DelTokensByProfileID(profileID authentication.ProfileID) error {
+	var keys []string
+	err := ch.db.Model(&GormTokenModel{}).Where("profile_id = ?", int64(profileID)).Pluck("key", &keys).Error
+	if err != nil {
+		return err
+	}
+
+	if err := ch.GormDriver.DelTokensByProfileID(profileID); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := ch.cache.Del([]byte(fmt.Sprint("token_", key))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropProfileCache удаляет из кеша статус, атрибуты и роли профиля
+func (ch *ChGormDriver) dropProfileCache(profileID authentication.ProfileID) error {
+	for _, prefix := range []string{"status_", "attrs_", "roles_"} {
+		if err := ch.cache.Del([]byte(fmt.Sprint(prefix, profileID))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DelProfile токены удаляются заранее, чтобы очистить их кеш, вместе с остальным кешем профиля
+func (ch *ChGormDriver) DelProfile(profileID authentication.ProfileID) error {
+	if err := ch.DelTokensByProfileID(profileID); err != nil {
+		return err
+	}
+	if err := ch.dropProfileCache(profileID); err != nil {
+		return err
+	}
+	return ch.GormDriver.DelProfile(profileID)
+}
+
+// AnonymizeProfile кроме токенов и кеша профиля удаляются ключи из писем на старый E-MAIL
+func (ch *ChGormDriver) AnonymizeProfile(profileID authentication.ProfileID, login, email string) error {
+	oldEmail, err := ch.GormDriver.GetEmail(profileID)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	if err := ch.db.Model(&GormEmailSecretKeyModel{}).Where("email = ?", oldEmail).Pluck("key", &keys).Error; err != nil {
+		return err
+	}
+
+	if err := ch.DelTokensByProfileID(profileID); err != nil {
+		return err
+	}
+	if err := ch.GormDriver.AnonymizeProfile(profileID, login, email); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := ch.cache.Del([]byte(key)); err != nil {
+			return err
+		}
+	}
+	return ch.dropProfileCache(profileID)
+}
+
 func (ch *ChGormDriver) ReadToken(tokenID authentication.TokenID) (authentication.ProfileID, error) {
 	bsKey := []byte(fmt.Sprint("token_", tokenID))
 	val, exist, err := ch.cache.Get(bsKey)
@@ -113,3 +195,144 @@ func (ch *ChGormDriver) ReadToken(tokenID authentication.TokenID) (authenticatio
 
 	return authentication.ProfileID(model.ProfileID), ch.cache.Set(bsKey, ch.poolInt64.Conv(model.ProfileID), int(model.Expiries-time.Now().Unix()))
 }
+
+// chStatusCacheSecond статус, измененный в другом процессе, применяется с этой задержкой
+const chStatusCacheSecond = 60
+
+func (ch *ChGormDriver) GetProfileStatus(profileID authentication.ProfileID) (*authentication.ProfileStatus, error) {
+	bsKey := []byte(fmt.Sprint("status_", profileID))
+	val, exist, err := ch.cache.Get(bsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	status := new(authentication.ProfileStatus)
+	if exist && json.Unmarshal(val, status) == nil {
+		return status, nil
+	}
+
+	status, err = ch.GormDriver.GetProfileStatus(profileID)
+	if err != nil {
+		return nil, err
+	}
+	val, err = json.Marshal(status)
+	if err != nil {
+		return nil, err
+	}
+	return status, ch.cache.Set(bsKey, val, chStatusCacheSecond)
+}
+
+func (ch *ChGormDriver) MarkProfileDeleted(profileID authentication.ProfileID, restoreKey authentication.EmailSecretKey, purgeAt int64) error {
+	if err := ch.GormDriver.MarkProfileDeleted(profileID, restoreKey, purgeAt); err != nil {
+		return err
+	}
+	return ch.cache.Del([]byte(fmt.Sprint("status_", profileID)))
+}
+
+func (ch *ChGormDriver) RestoreProfile(restoreKey authentication.EmailSecretKey) (authentication.ProfileID, error) {
+	profileID, err := ch.GormDriver.RestoreProfile(restoreKey)
+	if err != nil {
+		return 0, err
+	}
+	return profileID, ch.cache.Del([]byte(fmt.Sprint("status_", profileID)))
+}
+
+func (ch *ChGormDriver) SetProfileStatus(profileID authentication.ProfileID, status *authentication.ProfileStatus) error {
+	if err := ch.GormDriver.SetProfileStatus(profileID, status); err != nil {
+		return err
+	}
+	return ch.cache.Del([]byte(fmt.Sprint("status_", profileID)))
+}
+
+// chAttrsCacheSecond атрибуты, измененные в другом процессе, видны с этой задержкой
+const chAttrsCacheSecond = 60
+
+func (ch *ChGormDriver) GetAttrs(profileID authentication.ProfileID) (map[string]string, error) {
+	bsKey := []byte(fmt.Sprint("attrs_", profileID))
+	val, exist, err := ch.cache.Get(bsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var attrs map[string]string
+	if exist && json.Unmarshal(val, &attrs) == nil && attrs != nil {
+		return attrs, nil
+	}
+
+	attrs, err = ch.GormDriver.GetAttrs(profileID)
+	if err != nil {
+		return nil, err
+	}
+	val, err = json.Marshal(attrs)
+	if err != nil {
+		return nil, err
+	}
+	return attrs, ch.cache.Set(bsKey, val, chAttrsCacheSecond)
+}
+
+func (ch *ChGormDriver) SetAttrs(profileID authentication.ProfileID, attrs map[string]*authentication.ResultAttr) error {
+	if err := ch.GormDriver.SetAttrs(profileID, attrs); err != nil {
+		return err
+	}
+	return ch.cache.Del([]byte(fmt.Sprint("attrs_", profileID)))
+}
+
+// chRolesCacheSecond назначения ролей, измененные в другом процессе, видны с этой задержкой
+const chRolesCacheSecond = 60
+
+func (ch *ChGormDriver) ListProfileRoles(profileID authentication.ProfileID) ([]authentication.Role, error) {
+	bsKey := []byte(fmt.Sprint("roles_", profileID))
+	val, exist, err := ch.cache.Get(bsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var roles []authentication.Role
+	if exist && json.Unmarshal(val, &roles) == nil && roles != nil {
+		return roles, nil
+	}
+
+	roles, err = ch.GormDriver.ListProfileRoles(profileID)
+	if err != nil {
+		return nil, err
+	}
+	val, err = json.Marshal(roles)
+	if err != nil {
+		return nil, err
+	}
+	return roles, ch.cache.Set(bsKey, val, chRolesCacheSecond)
+}
+
+func (ch *ChGormDriver) AssignRole(profileID authentication.ProfileID, role authentication.Role) error {
+	if err := ch.GormDriver.AssignRole(profileID, role); err != nil {
+		return err
+	}
+	return ch.cache.Del([]byte(fmt.Sprint("roles_", profileID)))
+}
+
+func (ch *ChGormDriver) RevokeRole(profileID authentication.ProfileID, role authentication.Role) error {
+	if err := ch.GormDriver.RevokeRole(profileID, role); err != nil {
+		return err
+	}
+	return ch.cache.Del([]byte(fmt.Sprint("roles_", profileID)))
+}
+
+// DelRole профили с удаляемой ролью находятся заранее, чтобы очистить их кеш
+func (ch *ChGormDriver) DelRole(name authentication.Role) error {
+	var ids []int64
+	err := ch.db.Model(&GormProfileRoleModel{}).Where("role = ?", string(name)).Pluck("profile_id", &ids).Error
+	if err != nil {
+		return err
+	}
+
+	if err := ch.GormDriver.DelRole(name); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := ch.cache.Del([]byte(fmt.Sprint("roles_", id))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
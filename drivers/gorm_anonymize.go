@@ -0,0 +1,48 @@
+package drivers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/v-grabko1999/authentication"
+	"gorm.io/gorm"
+)
+
+func (g *GormDriver) AnonymizeProfile(profileID authentication.ProfileID, login, email string) error {
+	return g.db.Transaction(func(tx *gorm.DB) error {
+		model := &GormProfileModel{}
+		err := tx.Select("id", "email").Where("id = ?", int64(profileID)).First(model).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return authentication.ErrProfileIdNotFound
+			}
+			return err
+		}
+
+		for _, m := range profileModels {
+			if err := tx.Where("profile_id = ?", int64(profileID)).Delete(m).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("email = ?", model.Email).Delete(&GormEmailSecretKeyModel{}).Error; err != nil {
+			return err
+		}
+
+		loginCanonical, emailCanonical := authentication.CanonicalLogin(login), authentication.CanonicalEmail(email)
+		return tx.Model(&GormProfileModel{}).Where("id = ?", int64(profileID)).Updates(map[string]interface{}{
+			"login":             login,
+			"email":             email,
+			"password":          "",
+			"login_canonical":   loginCanonical,
+			"email_canonical":   emailCanonical,
+			"login_skeleton":    authentication.LoginSkeleton(login),
+			"status":            string(authentication.StatusAnonymized),
+			"status_reason":     "",
+			"status_until":      0,
+			"status_changed_at": time.Now().Unix(),
+			"restore_key":       "",
+			"email_verified":    false,
+			"mfa_email":         false,
+		}).Error
+	})
+}
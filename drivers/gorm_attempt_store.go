@@ -0,0 +1,91 @@
+package drivers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/v-grabko1999/authentication"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type GormAttemptModel struct {
+	Key         string `gorm:"primarykey;size:255;autoIncrement:false"`
+	Failures    int
+	LastFailure int64 `gorm:"index"`
+}
+
+// GormAttemptStore счетчики неудачных попыток входа, общие для нескольких процессов
+type GormAttemptStore struct {
+	db *gorm.DB
+}
+
+func NewGormAttemptStore(db *gorm.DB) (authentication.AttemptStore, error) {
+	if err := db.AutoMigrate(&GormAttemptModel{}); err != nil {
+		return nil, err
+	}
+	return &GormAttemptStore{db: db}, nil
+}
+
+func (g *GormAttemptStore) Fail(key string, windowSecond int64) (*authentication.AttemptState, error) {
+	now := time.Now().Unix()
+	//устаревший счетчик начинается заново одним запросом, без гонки чтения и записи
+	update := func() (int64, error) {
+		res := g.db.Model(&GormAttemptModel{}).Where("key = ?", key).Updates(map[string]interface{}{
+			"failures":     gorm.Expr("CASE WHEN last_failure < ? THEN 1 ELSE failures + 1 END", now-windowSecond),
+			"last_failure": now,
+		})
+		return res.RowsAffected, res.Error
+	}
+
+	affected, err := update()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		res := g.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&GormAttemptModel{
+			Key:         key,
+			Failures:    1,
+			LastFailure: now,
+		})
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		//строку успел создать параллельный запрос
+		if res.RowsAffected == 0 {
+			if _, err := update(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	model := &GormAttemptModel{}
+	if err := g.db.Where("key = ?", key).First(model).Error; err != nil {
+		return nil, err
+	}
+	return &authentication.AttemptState{Failures: model.Failures, LastFailure: model.LastFailure}, nil
+}
+
+func (g *GormAttemptStore) Get(key string, windowSecond int64) (*authentication.AttemptState, error) {
+	model := &GormAttemptModel{}
+	err := g.db.Where("key = ?", key).First(model).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return new(authentication.AttemptState), nil
+		}
+		return nil, err
+	}
+	if model.LastFailure+windowSecond < time.Now().Unix() {
+		return new(authentication.AttemptState), nil
+	}
+	return &authentication.AttemptState{Failures: model.Failures, LastFailure: model.LastFailure}, nil
+}
+
+func (g *GormAttemptStore) Reset(key string) error {
+	return g.db.Where("key = ?", key).Delete(&GormAttemptModel{}).Error
+}
+
+// Purge удаляет счетчики без неудач за последние windowSecond секунд
+func (g *GormAttemptStore) Purge(windowSecond int64) error {
+	return g.db.Where("last_failure < ?", time.Now().Unix()-windowSecond).Delete(&GormAttemptModel{}).Error
+}
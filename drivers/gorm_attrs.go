@@ -0,0 +1,85 @@
+package drivers
+
+import (
+	"github.com/v-grabko1999/authentication"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GormProfileAttrModel IndexedValue заполнен только у индексируемых атрибутов, чтобы индекс
+// оставался коротким и поиск не находил неиндексируемые значения
+type GormProfileAttrModel struct {
+	ProfileID    int64            `gorm:"primarykey;autoIncrement:false"`
+	Profile      GormProfileModel `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Name         string           `gorm:"primarykey;size:64;index:idx_profile_attr_lookup,priority:1"`
+	Value        string           `gorm:"size:1024"`
+	IndexedValue *string          `gorm:"size:255;index:idx_profile_attr_lookup,priority:2"`
+}
+
+func (g *GormDriver) GetAttrs(profileID authentication.ProfileID) (map[string]string, error) {
+	var models []GormProfileAttrModel
+	err := g.db.Select("name", "value").Where("profile_id = ?", int64(profileID)).Find(&models).Error
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := make(map[string]string, len(models))
+	for _, model := range models {
+		attrs[model.Name] = model.Value
+	}
+	return attrs, nil
+}
+
+func (g *GormDriver) SetAttrs(profileID authentication.ProfileID, attrs map[string]*authentication.ResultAttr) error {
+	exists, err := g.ProfileExist(profileID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return authentication.ErrProfileIdNotFound
+	}
+
+	return g.db.Transaction(func(tx *gorm.DB) error {
+		for name, attr := range attrs {
+			if attr == nil {
+				err := tx.Where("profile_id = ? AND name = ?", int64(profileID), name).Delete(&GormProfileAttrModel{}).Error
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			model := &GormProfileAttrModel{ProfileID: int64(profileID), Name: name, Value: attr.Value}
+			if attr.Indexed {
+				value := attr.Value
+				model.IndexedValue = &value
+			}
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "profile_id"}, {Name: "name"}},
+				DoUpdates: clause.AssignmentColumns([]string{"value", "indexed_value"}),
+			}).Create(model).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (g *GormDriver) FindProfilesByAttr(name, value string, limit int) ([]authentication.ProfileID, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	var ids []int64
+	err := g.db.Model(&GormProfileAttrModel{}).Where("name = ? AND indexed_value = ?", name, value).
+		Order("profile_id").Limit(limit).Pluck("profile_id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]authentication.ProfileID, len(ids))
+	for i, id := range ids {
+		res[i] = authentication.ProfileID(id)
+	}
+	return res, nil
+}
@@ -0,0 +1,402 @@
+package drivers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/v-grabko1999/authentication"
+	"gorm.io/gorm"
+)
+
+// gormAuditAppendRetries попытки дописать запись, если цепочку продлил параллельный процесс
+const gormAuditAppendRetries = 5
+
+// auditVerifyBatch записи при проверке цепочки читаются пачками
+const auditVerifyBatch = 500
+
+// GormAuditEventModel запись связана с предыдущей через PrevHash. Уникальный индекс
+// по PrevHash не дает двум процессам продолжить цепочку от одной записи.
+// Персональные данные (IP, UserAgent, DeviceID, Data) входят в Hash только через DataHash,
+// поэтому их можно стереть (Scrubbed), не разрывая цепочку. Каждое стирание
+// перечисляет подписанная запись EventAuditScrubbed в той же цепочке
+type GormAuditEventModel struct {
+	ID        int64     `gorm:"primarykey"`
+	Type      string    `gorm:"size:64;index"`
+	Time      time.Time `gorm:"index"`
+	ProfileID int64     `gorm:"index"`
+	IP        string    `gorm:"size:64"`
+	UserAgent string    `gorm:"size:512"`
+	DeviceID  string    `gorm:"size:255"`
+	//Data подробности события в JSON
+	Data     string
+	Scrubbed bool
+
+	DataHash string `gorm:"size:64"`
+	PrevHash string `gorm:"size:64;uniqueIndex"`
+	Hash     string `gorm:"size:64;index"`
+}
+
+// GormAuditCheckpointModel подписанная отметка конца цепочки. Обнаруживает
+// пересчет всей цепочки после правки и удаление последних записей
+type GormAuditCheckpointModel struct {
+	ID        int64  `gorm:"primarykey"`
+	EventID   int64  `gorm:"index"`
+	Hash      string `gorm:"size:64"`
+	Signature string `gorm:"size:64"`
+	CreatedAt time.Time
+}
+
+func (model *GormAuditEventModel) dataHash() string {
+	h := sha256.New()
+	for _, value := range []string{model.IP, model.UserAgent, model.DeviceID, model.Data} {
+		writeAuditField(h, value)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (model *GormAuditEventModel) hash() string {
+	h := sha256.New()
+	writeAuditField(h, model.PrevHash)
+	writeAuditField(h, model.Type)
+	writeAuditField(h, fmt.Sprint(model.Time.UnixMilli()))
+	writeAuditField(h, fmt.Sprint(model.ProfileID))
+	writeAuditField(h, model.DataHash)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeAuditField длина перед значением исключает неоднозначность склейки полей
+func writeAuditField(h interface{ Write([]byte) (int, error) }, value string) {
+	var l [8]byte
+	binary.BigEndian.PutUint64(l[:], uint64(len(value)))
+	h.Write(l[:])
+	h.Write([]byte(value))
+}
+
+// GormAuditSink подписчик событий, сохраняющий их в цепочку аудита
+type GormAuditSink struct {
+	db              *gorm.DB
+	checkpointKey   []byte
+	checkpointEvery int64
+}
+
+// NewGormAuditSink checkpointKey подписывает отметки (обычно TokenSecretKey),
+// отметка создается после каждых checkpointEvery записей. 0 - только через Checkpoint
+func NewGormAuditSink(db *gorm.DB, checkpointKey []byte, checkpointEvery int64) (*GormAuditSink, error) {
+	if err := db.AutoMigrate(&GormAuditEventModel{}, &GormAuditCheckpointModel{}); err != nil {
+		return nil, err
+	}
+	return &GormAuditSink{db: db, checkpointKey: checkpointKey, checkpointEvery: checkpointEvery}, nil
+}
+
+func (g *GormAuditSink) HandleEvent(event *authentication.Event) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	model := &GormAuditEventModel{
+		Type: string(event.Type),
+		//время хранится с точностью до миллисекунд во всех базах данных
+		Time:      time.UnixMilli(event.Time.UnixMilli()),
+		ProfileID: int64(event.ProfileID),
+		IP:        event.Meta.IP,
+		UserAgent: event.Meta.UserAgent,
+		DeviceID:  event.Meta.DeviceID,
+		Data:      string(data),
+	}
+	return g.append(model, nil)
+}
+
+// append продлевает цепочку записью model. before выполняется в той же транзакции
+func (g *GormAuditSink) append(model *GormAuditEventModel, before func(tx *gorm.DB) error) error {
+	model.DataHash = model.dataHash()
+
+	for i := 0; ; i++ {
+		err := g.db.Transaction(func(tx *gorm.DB) error {
+			if before != nil {
+				if err := before(tx); err != nil {
+					return err
+				}
+			}
+			last := &GormAuditEventModel{}
+			err := tx.Select("id", "hash").Order("id desc").Limit(1).Find(last).Error
+			if err != nil {
+				return err
+			}
+
+			model.ID = 0
+			model.PrevHash = last.Hash
+			model.Hash = model.hash()
+			if err := tx.Create(model).Error; err != nil {
+				return err
+			}
+
+			if g.checkpointEvery > 0 && model.ID%g.checkpointEvery == 0 {
+				return g.checkpoint(tx, model)
+			}
+			return nil
+		})
+		if err == nil || i >= gormAuditAppendRetries {
+			return err
+		}
+
+		//нарушен уникальный индекс PrevHash - повтор от новой последней записи
+		var count int64
+		if cerr := g.db.Model(&GormAuditEventModel{}).Where("prev_hash = ?", model.PrevHash).Count(&count).Error; cerr != nil || count == 0 {
+			return err
+		}
+	}
+}
+
+// auditScrubbedData Data стертой записи
+const auditScrubbedData = "null"
+
+// scrubbed стертая запись может только потерять данные, но не получить новые
+func (model *GormAuditEventModel) scrubbed() bool {
+	return model.IP == "" && model.UserAgent == "" && model.DeviceID == "" && model.Data == auditScrubbedData
+}
+
+// ScrubProfile стирает IP, UserAgent, DeviceID и Data в записях профиля и в записях
+// с ProfileID 0 о его логине. Тип, время, ProfileID и хеши остаются. ID стертых записей
+// перечисляет запись EventAuditScrubbed, подписанная ключом отметок: VerifyAuditChain
+// принимает без DataHash только перечисленные в ней записи
+func (g *GormAuditSink) ScrubProfile(profileID authentication.ProfileID, login, email string) error {
+	ids, err := g.scrubTargets(profileID, login, email)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+
+	list := make([]string, len(ids))
+	for i, id := range ids {
+		list[i] = fmt.Sprint(id)
+	}
+	events := strings.Join(list, ",")
+	data, err := json.Marshal(map[string]string{"events": events, "signature": g.signScrub(events)})
+	if err != nil {
+		return err
+	}
+
+	return g.append(&GormAuditEventModel{
+		Type:      string(authentication.EventAuditScrubbed),
+		Time:      time.UnixMilli(time.Now().UnixMilli()),
+		ProfileID: int64(profileID),
+		Data:      string(data),
+	}, func(tx *gorm.DB) error {
+		return tx.Model(&GormAuditEventModel{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"ip":         "",
+			"user_agent": "",
+			"device_id":  "",
+			"data":       auditScrubbedData,
+			"scrubbed":   true,
+		}).Error
+	})
+}
+
+// scrubTargets ID нестертых записей профиля и записей с ProfileID 0 о его логине
+func (g *GormAuditSink) scrubTargets(profileID authentication.ProfileID, login, email string) ([]int64, error) {
+	//записи о прошлых стираниях подтверждают их и не стираются
+	var ids []int64
+	err := g.db.Model(&GormAuditEventModel{}).Where("profile_id = ? AND scrubbed = ? AND type <> ?", int64(profileID), false, string(authentication.EventAuditScrubbed)).
+		Order("id").Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var lastID int64
+	for {
+		var models []GormAuditEventModel
+		err := g.db.Where("profile_id = ? AND scrubbed = ? AND id > ?", 0, false, lastID).Order("id").Limit(auditVerifyBatch).Find(&models).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(models) == 0 {
+			return ids, nil
+		}
+		for i := range models {
+			event, err := models[i].toEvent()
+			if err != nil {
+				return nil, err
+			}
+			if authentication.AuditEventOf(event, profileID, login, email) {
+				ids = append(ids, models[i].ID)
+			}
+			lastID = models[i].ID
+		}
+	}
+}
+
+func (g *GormAuditSink) signScrub(events string) string {
+	mac := hmac.New(sha256.New, g.checkpointKey)
+	writeAuditField(mac, string(authentication.EventAuditScrubbed))
+	writeAuditField(mac, events)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// scrubRecord ID записей, стирание которых подтверждает подписанная запись EventAuditScrubbed
+func (g *GormAuditSink) scrubRecord(model *GormAuditEventModel) ([]int64, bool) {
+	var data map[string]string
+	if err := json.Unmarshal([]byte(model.Data), &data); err != nil {
+		return nil, false
+	}
+	if !hmac.Equal([]byte(g.signScrub(data["events"])), []byte(data["signature"])) {
+		return nil, false
+	}
+	var ids []int64
+	for _, s := range strings.Split(data["events"], ",") {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		ids = append(ids, id)
+	}
+	return ids, true
+}
+
+func (g *GormAuditSink) sign(eventID int64, hash string) string {
+	mac := hmac.New(sha256.New, g.checkpointKey)
+	writeAuditField(mac, fmt.Sprint(eventID))
+	writeAuditField(mac, hash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (g *GormAuditSink) checkpoint(tx *gorm.DB, last *GormAuditEventModel) error {
+	return tx.Create(&GormAuditCheckpointModel{
+		EventID:   last.ID,
+		Hash:      last.Hash,
+		Signature: g.sign(last.ID, last.Hash),
+	}).Error
+}
+
+// Checkpoint подписывает текущий конец цепочки. Предназначен для периодического вызова
+func (g *GormAuditSink) Checkpoint() error {
+	if len(g.checkpointKey) == 0 {
+		return errors.New("audit checkpoint key is not set")
+	}
+	last := &GormAuditEventModel{}
+	err := g.db.Select("id", "hash").Order("id desc").Limit(1).Find(last).Error
+	if err != nil || last.ID == 0 {
+		return err
+	}
+	return g.checkpoint(g.db, last)
+}
+
+// VerifyAuditChain проходит цепочку от начала и возвращает *authentication.AuditChainError
+// с первой поврежденной записью. Затем сверяет подписанные отметки
+func (g *GormAuditSink) VerifyAuditChain() error {
+	hashes := make(map[int64]string)
+	var checkpoints []GormAuditCheckpointModel
+	if err := g.db.Order("id").Find(&checkpoints).Error; err != nil {
+		return err
+	}
+	for _, cp := range checkpoints {
+		hashes[cp.EventID] = ""
+	}
+
+	//стертые записи ждут подтверждения записью EventAuditScrubbed
+	scrubbed := make(map[int64]bool)
+	prevHash := ""
+	var lastID int64
+	for {
+		var models []GormAuditEventModel
+		err := g.db.Where("id > ?", lastID).Order("id").Limit(auditVerifyBatch).Find(&models).Error
+		if err != nil {
+			return err
+		}
+		if len(models) == 0 {
+			break
+		}
+
+		for i := range models {
+			model := &models[i]
+			if model.PrevHash != prevHash {
+				return &authentication.AuditChainError{EventID: model.ID, Reason: "previous hash mismatch"}
+			}
+			if model.Scrubbed {
+				if !model.scrubbed() {
+					return &authentication.AuditChainError{EventID: model.ID, Reason: "scrubbed data is not empty"}
+				}
+				scrubbed[model.ID] = true
+			} else if model.dataHash() != model.DataHash {
+				return &authentication.AuditChainError{EventID: model.ID, Reason: "data hash mismatch"}
+			} else if model.Type == string(authentication.EventAuditScrubbed) {
+				ids, ok := g.scrubRecord(model)
+				if !ok {
+					return &authentication.AuditChainError{EventID: model.ID, Reason: "scrub record signature mismatch"}
+				}
+				for _, id := range ids {
+					delete(scrubbed, id)
+				}
+			}
+			if model.hash() != model.Hash {
+				return &authentication.AuditChainError{EventID: model.ID, Reason: "hash mismatch"}
+			}
+			if _, ok := hashes[model.ID]; ok {
+				hashes[model.ID] = model.Hash
+			}
+			prevHash = model.Hash
+			lastID = model.ID
+		}
+	}
+
+	if len(scrubbed) > 0 {
+		var first int64
+		for id := range scrubbed {
+			if first == 0 || id < first {
+				first = id
+			}
+		}
+		return &authentication.AuditChainError{EventID: first, Reason: "scrubbed without a scrub record"}
+	}
+
+	for _, cp := range checkpoints {
+		if !hmac.Equal([]byte(g.sign(cp.EventID, cp.Hash)), []byte(cp.Signature)) {
+			return &authentication.AuditChainError{EventID: cp.EventID, Reason: "checkpoint signature mismatch"}
+		}
+		if hashes[cp.EventID] != cp.Hash {
+			return &authentication.AuditChainError{EventID: cp.EventID, Reason: "checkpoint hash mismatch"}
+		}
+	}
+	return nil
+}
+
+// Events последние limit событий профиля, новые первыми. limit <= 0 - все события
+func (g *GormAuditSink) Events(profileID authentication.ProfileID, limit int) ([]*authentication.Event, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	var models []GormAuditEventModel
+	err := g.db.Where("profile_id = ?", int64(profileID)).Order("id desc").Limit(limit).Find(&models).Error
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*authentication.Event, len(models))
+	for i := range models {
+		events[i], err = models[i].toEvent()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+func (model *GormAuditEventModel) toEvent() (*authentication.Event, error) {
+	event := &authentication.Event{
+		Type:      authentication.EventType(model.Type),
+		Time:      model.Time,
+		ProfileID: authentication.ProfileID(model.ProfileID),
+		Meta: authentication.RequestMeta{
+			IP:        model.IP,
+			UserAgent: model.UserAgent,
+			DeviceID:  model.DeviceID,
+		},
+	}
+	return event, json.Unmarshal([]byte(model.Data), &event.Data)
+}
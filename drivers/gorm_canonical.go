@@ -0,0 +1,113 @@
+package drivers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/v-grabko1999/authentication"
+	"gorm.io/gorm"
+)
+
+// canonicalBackfillBatch профили без канонических форм заполняются пачками
+const canonicalBackfillBatch = 500
+
+// CanonicalCollision профиль ProfileID, чья каноническая форма совпала с формой ConflictsWith
+type CanonicalCollision struct {
+	Column        string
+	ProfileID     int64
+	ConflictsWith int64
+}
+
+// CanonicalCollisionError возвращает NewGorm, если у существующих профилей совпали канонические
+// формы логина или E-MAIL, например Admin и admin. Такие профили нужно переименовать вручную:
+// иначе более новый входил бы в более старый профиль
+type CanonicalCollisionError struct {
+	Collisions []CanonicalCollision
+}
+
+func (e *CanonicalCollisionError) Error() string {
+	list := make([]string, 0, len(e.Collisions))
+	for _, c := range e.Collisions {
+		list = append(list, fmt.Sprintf("profile %d %s conflicts with profile %d", c.ProfileID, c.Column, c.ConflictsWith))
+	}
+	return "canonical form collisions: " + strings.Join(list, "; ")
+}
+
+// byLogin профиль без канонической формы находится только по точному совпадению
+func byLogin(canonical string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("login_canonical = ? OR (login_canonical IS NULL AND login = ?)", canonical, canonical)
+	}
+}
+
+func byEmail(canonical string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("email_canonical = ? OR (email_canonical IS NULL AND email = ?)", canonical, canonical)
+	}
+}
+
+// backfillCanonical заполняет канонические формы профилей, созданных до их появления.
+// Из профилей с совпавшей формой ее получает более старый, остальные перечисляются
+// в CanonicalCollisionError
+func backfillCanonical(db *gorm.DB) error {
+	var lastID int64
+	var collisions []CanonicalCollision
+	for {
+		var models []GormProfileModel
+		err := db.Select("id", "login", "email", "login_canonical", "email_canonical").
+			Where("id > ? AND (login_canonical IS NULL OR email_canonical IS NULL)", lastID).
+			Order("id").Limit(canonicalBackfillBatch).Find(&models).Error
+		if err != nil {
+			return err
+		}
+		if len(models) == 0 {
+			if len(collisions) > 0 {
+				return &CanonicalCollisionError{Collisions: collisions}
+			}
+			return nil
+		}
+
+		for i := range models {
+			model := &models[i]
+			lastID = model.ID
+			updates := map[string]interface{}{"login_skeleton": authentication.LoginSkeleton(model.Login)}
+
+			for _, col := range []struct {
+				name  string
+				value *string
+				form  string
+			}{
+				{"login_canonical", model.LoginCanonical, authentication.CanonicalLogin(model.Login)},
+				{"email_canonical", model.EmailCanonical, authentication.CanonicalEmail(model.Email)},
+			} {
+				if col.value != nil {
+					continue
+				}
+				owner, err := canonicalOwner(db, col.name, col.form)
+				if err != nil {
+					return err
+				}
+				if owner != 0 {
+					collisions = append(collisions, CanonicalCollision{Column: col.name, ProfileID: model.ID, ConflictsWith: owner})
+					continue
+				}
+				updates[col.name] = col.form
+			}
+
+			if err := db.Model(&GormProfileModel{}).Where("id = ?", model.ID).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// canonicalOwner ID профиля с такой канонической формой или 0
+func canonicalOwner(db *gorm.DB, column, value string) (id int64, err error) {
+	err = db.Model(&GormProfileModel{}).Select("id").Limit(1).Where(column+" = ?", value).Find(&id).Error
+	return
+}
+
+func canonicalTaken(db *gorm.DB, column, value string) (taken bool, err error) {
+	err = db.Model(&GormProfileModel{}).Select("count(*) > 0").Limit(1).Where(column+" = ?", value).Find(&taken).Error
+	return
+}
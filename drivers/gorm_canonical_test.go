@@ -0,0 +1,60 @@
+package drivers
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestBackfillCanonicalCollisions(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:backfill?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := autoMigrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	//профили, созданные до появления канонических форм
+	models := []*GormProfileModel{
+		{Login: "Admin", Email: "admin@example.com"},
+		{Login: "admin", Email: "Other@Example.com"},
+		{Login: "bob", Email: "other@example.com"},
+	}
+	for _, model := range models {
+		if err := db.Create(model).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = NewGorm(db)
+	var collisions *CanonicalCollisionError
+	if !errors.As(err, &collisions) {
+		t.Fatal("NewGorm with collisions error: ", err)
+	}
+	want := []CanonicalCollision{
+		{Column: "login_canonical", ProfileID: models[1].ID, ConflictsWith: models[0].ID},
+		{Column: "email_canonical", ProfileID: models[2].ID, ConflictsWith: models[1].ID},
+	}
+	if !reflect.DeepEqual(collisions.Collisions, want) {
+		t.Fatal("CanonicalCollisionError collisions: ", collisions.Collisions)
+	}
+
+	//после переименования миграция завершается
+	if err := db.Model(&GormProfileModel{}).Where("id = ?", models[1].ID).Update("login", "admin2").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&GormProfileModel{}).Where("id = ?", models[2].ID).Update("email", "bob@example.com").Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewGorm(db); err != nil {
+		t.Fatal("NewGorm after rename error: ", err)
+	}
+	var left int64
+	if err := db.Model(&GormProfileModel{}).Where("login_canonical IS NULL OR email_canonical IS NULL").Count(&left).Error; err != nil || left != 0 {
+		t.Fatal("profiles without canonical forms: ", left, err)
+	}
+}
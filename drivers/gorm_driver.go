@@ -18,6 +18,23 @@ type GormProfileModel struct {
 	Email    string `gorm:"size:255;uniqueIndex"`
 	Password string `gorm:"size:225"`
 
+	//LoginCanonical и EmailCanonical формы для поиска и уникальности. NULL у профилей,
+	//чья форма при заполнении совпала с формой более старого профиля, см. CanonicalCollisionError
+	LoginCanonical *string `gorm:"size:255;uniqueIndex"`
+	EmailCanonical *string `gorm:"size:255;uniqueIndex"`
+	LoginSkeleton  string  `gorm:"size:255;index"`
+
+	//Status пустой у профилей, созданных до появления статусов, и означает active
+	Status          string `gorm:"size:16"`
+	StatusReason    string `gorm:"size:512"`
+	StatusUntil     int64
+	StatusChangedAt int64
+	//RestoreKey ключ восстановления удаленного профиля
+	RestoreKey string `gorm:"size:36;index"`
+
+	EmailVerified bool
+	MFAEmail      bool
+
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
@@ -25,15 +42,23 @@ type GormProfileModel struct {
 type GormTokenModel struct {
 	Key      string `gorm:"primarykey;size:36;autoIncrement:false"`
 	Expiries int64
+	//AuthAt время последней проверки учетных данных, для повторной аутентификации
+	AuthAt int64
 
 	ProfileID int64
 	Profile   GormProfileModel `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 }
 
 type GormEmailSecretKeyModel struct {
-	Key      string `gorm:"primarykey;size:36;autoIncrement:false"`
-	Email    string `gorm:"size:255;"`
+	Key   string `gorm:"primarykey;size:64;autoIncrement:false"`
+	Email string `gorm:"size:255;"`
+	//Purpose пустой у ключей, выданных до его появления, такие ключи не принимаются
+	Purpose  string `gorm:"size:32"`
 	Expiries int64
+
+	//заполняются только для цифровых кодов
+	CodeHash string `gorm:"size:64"`
+	Attempts int
 }
 
 func (model *GormEmailSecretKeyModel) read(db *gorm.DB, key authentication.EmailSecretKey) error {
@@ -48,11 +73,35 @@ func (model *GormEmailSecretKeyModel) read(db *gorm.DB, key authentication.Email
 	return nil
 }
 
+func autoMigrate(db *gorm.DB) error {
+	//профили, созданные до появления подтверждения E-MAIL, считаются подтвержденными,
+	//иначе EmailVerificationRequired закрыл бы им вход
+	grandfather := db.Migrator().HasTable(&GormProfileModel{}) && !db.Migrator().HasColumn(&GormProfileModel{}, "EmailVerified")
+
+	err := db.AutoMigrate(
+		&GormProfileModel{}, &GormTokenModel{}, &GormEmailSecretKeyModel{},
+		&GormEmailChangeModel{}, &GormMFAChallengeModel{}, &GormTOTPModel{},
+		&GormRecoveryCodeModel{}, &GormWebAuthnSessionModel{}, &GormWebAuthnCredentialModel{},
+		&GormKnownDeviceModel{}, &GormProofOfWorkNonceModel{}, &GormProfileAttrModel{},
+		&GormRoleModel{}, &GormRolePermissionModel{}, &GormRoleParentModel{}, &GormProfileRoleModel{},
+	)
+	if err != nil {
+		return err
+	}
+	if grandfather {
+		return db.Model(&GormProfileModel{}).Where("1 = 1").Update("email_verified", true).Error
+	}
+	return nil
+}
+
 func NewGorm(db *gorm.DB) (authentication.DriverStorage, error) {
-	err := db.AutoMigrate(&GormProfileModel{}, &GormTokenModel{}, &GormEmailSecretKeyModel{})
+	err := autoMigrate(db)
 	if err != nil {
 		return nil, err
 	}
+	if err := backfillCanonical(db); err != nil {
+		return nil, err
+	}
 	dr := new(GormDriver)
 	dr.db = db
 	return dr, nil
@@ -62,10 +111,11 @@ type GormDriver struct {
 	db *gorm.DB
 }
 
-func (g *GormDriver) EmailNewSecretKey(key authentication.EmailSecretKey, email string, lifetime int64) error {
+func (g *GormDriver) EmailNewSecretKey(key authentication.EmailSecretKey, purpose authentication.EmailKeyPurpose, email string, lifetime int64) error {
 	return g.db.Create(&GormEmailSecretKeyModel{
 		Key:      string(key),
 		Email:    email,
+		Purpose:  string(purpose),
 		Expiries: time.Now().Unix() + lifetime,
 	}).Error
 }
@@ -91,10 +141,86 @@ func (g *GormDriver) EmailDeleteSecretKey(key authentication.EmailSecretKey) err
 	return g.db.Delete(&GormEmailSecretKeyModel{Key: string(key)}).Error
 }
 
+// EmailConsumeSecretKey удаление с проверкой количества затронутых строк
+// гарантирует, что ключ будет погашен только одним вызовом
+func (g *GormDriver) EmailConsumeSecretKey(key authentication.EmailSecretKey, purpose authentication.EmailKeyPurpose) (string, error) {
+	model := &GormEmailSecretKeyModel{}
+	err := model.read(g.db, key)
+	if err != nil {
+		return "", err
+	}
+	if model.Purpose != string(purpose) {
+		return "", authentication.ErrEmailSecretKeyNotFound
+	}
+
+	res := g.db.Where("key = ? AND purpose = ?", string(key), string(purpose)).Delete(&GormEmailSecretKeyModel{})
+	if res.Error != nil {
+		return "", res.Error
+	}
+	if res.RowsAffected != 1 {
+		return "", authentication.ErrEmailSecretKeyNotFound
+	}
+
+	//время жизни секретного ключа истекло
+	if model.Expiries < time.Now().Unix() {
+		return "", authentication.ErrEmailSecretKeyNotFound
+	}
+	return model.Email, nil
+}
+
+func (g *GormDriver) EmailNewSecretCode(key authentication.EmailSecretKey, purpose authentication.EmailKeyPurpose, email string, codeHash string, lifetime int64) error {
+	return g.db.Save(&GormEmailSecretKeyModel{
+		Key:      string(key),
+		Email:    email,
+		Purpose:  string(purpose),
+		Expiries: time.Now().Unix() + lifetime,
+		CodeHash: codeHash,
+	}).Error
+}
+
+func (g *GormDriver) EmailReadSecretCode(key authentication.EmailSecretKey) (*authentication.ResultSecretCode, error) {
+	model := &GormEmailSecretKeyModel{}
+	err := model.read(g.db, key)
+	if err != nil {
+		return nil, err
+	}
+	//время жизни кода истекло
+	if model.Expiries < time.Now().Unix() {
+		if err := g.EmailDeleteSecretKey(key); err != nil {
+			return nil, err
+		}
+		return nil, authentication.ErrEmailSecretKeyNotFound
+	}
+
+	return &authentication.ResultSecretCode{
+		Email:    model.Email,
+		CodeHash: model.CodeHash,
+		Attempts: model.Attempts,
+	}, nil
+}
+
+func (g *GormDriver) EmailIncSecretCodeAttempts(key authentication.EmailSecretKey) (int, error) {
+	res := g.db.Model(&GormEmailSecretKeyModel{}).Where("key = ?", string(key)).Update("attempts", gorm.Expr("attempts + 1"))
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return 0, authentication.ErrEmailSecretKeyNotFound
+	}
+
+	model := &GormEmailSecretKeyModel{}
+	if err := g.db.Select("attempts").Where("key = ?", string(key)).First(model).Error; err != nil {
+		return 0, err
+	}
+	return model.Attempts, nil
+}
+
 func (g *GormDriver) NewToken(tokenID authentication.TokenID, profileID authentication.ProfileID, lifeTime authentication.TokenLifeTime) error {
+	now := time.Now().Unix()
 	return g.db.Create(&GormTokenModel{
 		Key:       string(tokenID),
-		Expiries:  time.Now().Unix() + int64(lifeTime),
+		Expiries:  now + int64(lifeTime),
+		AuthAt:    now,
 		ProfileID: int64(profileID),
 	}).Error
 }
@@ -128,34 +254,101 @@ func (g *GormDriver) DelToken(tokenID authentication.TokenID, profileID authenti
 	return g.db.Delete(&GormTokenModel{Key: string(tokenID)}).Error
 }
 
+func (g *GormDriver) ReadTokenAuthTime(tokenID authentication.TokenID) (int64, error) {
+	model := &GormTokenModel{}
+	if err := model.read(g.db, tokenID); err != nil {
+		return 0, err
+	}
+	if model.Expiries < time.Now().Unix() {
+		return 0, authentication.ErrTokenNotFound
+	}
+	return model.AuthAt, nil
+}
+
+func (g *GormDriver) SetTokenAuthTime(tokenID authentication.TokenID, authAt int64) error {
+	res := g.db.Model(&GormTokenModel{}).Where("key = ?", string(tokenID)).Update("auth_at", authAt)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return authentication.ErrTokenNotFound
+	}
+	return nil
+}
+
+func (g *GormDriver) DelTokensByProfileID(profileID authentication.ProfileID) error {
+	return g.db.Where("profile_id = ?", int64(profileID)).Delete(&GormTokenModel{}).Error
+}
+
 func (g *GormDriver) NewProfile(login, email, password string) (authentication.ProfileID, error) {
+	loginCanonical, emailCanonical := authentication.CanonicalLogin(login), authentication.CanonicalEmail(email)
 	model := &GormProfileModel{
-		Login:    login,
-		Email:    email,
-		Password: password,
+		Login:          login,
+		Email:          email,
+		Password:       password,
+		LoginCanonical: &loginCanonical,
+		EmailCanonical: &emailCanonical,
+		LoginSkeleton:  authentication.LoginSkeleton(login),
 	}
 	err := g.db.Create(model).Error
-	return authentication.ProfileID(model.ID), err
+	return authentication.ProfileID(model.ID), profileUniqueError(g.db, err, login, email)
+}
+
+// profileModels модели, которые принадлежат профилю. Удаляются вместе с ним
+// явно, так как sqlite по умолчанию не применяет внешние ключи
+var profileModels = []interface{}{
+	&GormTokenModel{}, &GormEmailChangeModel{}, &GormMFAChallengeModel{},
+	&GormTOTPModel{}, &GormRecoveryCodeModel{}, &GormWebAuthnSessionModel{},
+	&GormWebAuthnCredentialModel{}, &GormKnownDeviceModel{}, &GormProfileAttrModel{},
+	&GormProfileRoleModel{},
 }
 
 func (g *GormDriver) DelProfile(profileID authentication.ProfileID) error {
-	return g.db.Delete(&GormProfileModel{ID: int64(profileID)}).Error
+	return g.db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range profileModels {
+			if err := tx.Where("profile_id = ?", int64(profileID)).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&GormProfileModel{ID: int64(profileID)}).Error
+	})
 }
 
 func (g *GormDriver) SetPasswordProfileByEmail(email string, password string) error {
-	return g.db.Model(&GormProfileModel{}).Where("email = ?", email).Update("password", password).Error
+	return g.db.Model(&GormProfileModel{}).Scopes(byEmail(email)).Update("password", password).Error
 }
 
 func (g *GormDriver) SetPasswordProfileByProfileID(profileID authentication.ProfileID, password string) error {
 	return g.db.Model(&GormProfileModel{}).Where("id = ?", int64(profileID)).Update("password", password).Error
 }
 func (g *GormDriver) SetEmailByProfileID(profileID authentication.ProfileID, email string) error {
-	return g.db.Model(&GormProfileModel{}).Where("id = ?", int64(profileID)).Update("email", email).Error
+	err := g.db.Model(&GormProfileModel{}).Where("id = ?", int64(profileID)).Updates(map[string]interface{}{
+		"email":           email,
+		"email_canonical": authentication.CanonicalEmail(email),
+		"email_verified":  false,
+	}).Error
+	return profileUniqueError(g.db, err, "", email)
+}
+
+func (g *GormDriver) IsEmailVerified(profileID authentication.ProfileID) (verified bool, err error) {
+	model := &GormProfileModel{}
+	err = g.db.Select("email_verified").Where("id = ?", int64(profileID)).First(model).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = authentication.ErrProfileIdNotFound
+		}
+	}
+	verified = model.EmailVerified
+	return
+}
+
+func (g *GormDriver) SetEmailVerified(profileID authentication.ProfileID, verified bool) error {
+	return g.db.Model(&GormProfileModel{}).Where("id = ?", int64(profileID)).Update("email_verified", verified).Error
 }
 
 func (g *GormDriver) GetProfileIDByEmail(email string) (profileID authentication.ProfileID, err error) {
 	model := &GormProfileModel{}
-	err = g.db.Select("id").Where("email = ?", email).First(model).Error
+	err = g.db.Select("id").Scopes(byEmail(email)).First(model).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			err = authentication.ErrEmailNotFound
@@ -179,22 +372,24 @@ func (g *GormDriver) GetPasswordByID(profileID authentication.ProfileID) (passwo
 
 func (g *GormDriver) GetPasswordByLogin(login string) (res *authentication.ResultPasswordByLogin, err error) {
 	model := &GormProfileModel{}
-	err = g.db.Select([]string{"id", "password"}).Where("login = ?", login).First(model).Error
+	err = g.db.Select([]string{"id", "login", "password", "email_verified"}).Scopes(byLogin(login)).First(model).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			err = authentication.ErrLoginNotFound
 		}
 	}
 	res = &authentication.ResultPasswordByLogin{
-		ProfileID: authentication.ProfileID(model.ID),
-		Password:  model.Password,
+		ProfileID:     authentication.ProfileID(model.ID),
+		Login:         model.Login,
+		Password:      model.Password,
+		EmailVerified: model.EmailVerified,
 	}
 	return
 }
 
 func (g *GormDriver) GetLoginByEmail(email string) (login string, err error) {
 	model := &GormProfileModel{}
-	err = g.db.Select("login").Where("email = ?", email).First(model).Error
+	err = g.db.Select("login").Scopes(byEmail(email)).First(model).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			err = authentication.ErrEmailNotFound
@@ -210,12 +405,17 @@ func (g *GormDriver) ProfileExist(profileID authentication.ProfileID) (exists bo
 }
 
 func (g *GormDriver) IsUniqueLogin(login string) (exists bool, err error) {
-	err = g.db.Model(&GormProfileModel{}).Select("count(*) == 0").Limit(1).Where("login = ?", login).Find(&exists).Error
+	err = g.db.Model(&GormProfileModel{}).Select("count(*) == 0").Limit(1).Scopes(byLogin(login)).Find(&exists).Error
+	return
+}
+
+func (g *GormDriver) IsUniqueLoginSkeleton(skeleton string) (exists bool, err error) {
+	err = g.db.Model(&GormProfileModel{}).Select("count(*) == 0").Limit(1).Where("login_skeleton = ?", skeleton).Find(&exists).Error
 	return
 }
 
 func (g *GormDriver) IsUniqueEmail(email string) (exists bool, err error) {
-	err = g.db.Model(&GormProfileModel{}).Select("count(*) == 0").Limit(1).Where("email = ?", email).Find(&exists).Error
+	err = g.db.Model(&GormProfileModel{}).Select("count(*) == 0").Limit(1).Scopes(byEmail(email)).Find(&exists).Error
 	return
 }
 
@@ -0,0 +1,56 @@
+package drivers
+
+import (
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// legacyProfileModel таблица профилей без колонки email_verified. Канонические формы
+// заданы сразу: SQLite не добавляет UNIQUE колонки в существующую таблицу
+type legacyProfileModel struct {
+	ID             int64   `gorm:"primarykey"`
+	Login          string  `gorm:"size:255;uniqueIndex"`
+	Email          string  `gorm:"size:255;uniqueIndex"`
+	Password       string  `gorm:"size:225"`
+	LoginCanonical *string `gorm:"size:255;uniqueIndex"`
+	EmailCanonical *string `gorm:"size:255;uniqueIndex"`
+}
+
+func (legacyProfileModel) TableName() string {
+	return "gorm_profile_models"
+}
+
+func TestEmailVerifiedMigration(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:verified?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&legacyProfileModel{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&legacyProfileModel{Login: "old", Email: "old@example.com"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewGorm(db); err != nil {
+		t.Fatal("NewGorm error: ", err)
+	}
+	model := &GormProfileModel{}
+	if err := db.Where("login = ?", "old").First(model).Error; err != nil || !model.EmailVerified {
+		t.Fatal("existing profile is not verified: ", err, model.EmailVerified)
+	}
+
+	//новые профили и повторный запуск миграцию не затрагивают
+	if err := db.Create(&GormProfileModel{Login: "new", Email: "new@example.com"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewGorm(db); err != nil {
+		t.Fatal("NewGorm error: ", err)
+	}
+	model = &GormProfileModel{}
+	if err := db.Where("login = ?", "new").First(model).Error; err != nil || model.EmailVerified {
+		t.Fatal("new profile is verified: ", err, model.EmailVerified)
+	}
+}
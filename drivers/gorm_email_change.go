@@ -0,0 +1,101 @@
+package drivers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/v-grabko1999/authentication"
+	"gorm.io/gorm"
+)
+
+type GormEmailChangeModel struct {
+	ID        int64            `gorm:"primarykey"`
+	ProfileID int64            `gorm:"index"`
+	Profile   GormProfileModel `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	OldEmail string `gorm:"size:255"`
+	NewEmail string `gorm:"size:255"`
+	OldKey   string `gorm:"size:36;index"`
+	NewKey   string `gorm:"size:36;index"`
+	UndoKey  string `gorm:"size:36;index"`
+
+	State        string `gorm:"size:16"`
+	Expiries     int64
+	UndoExpiries int64
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (model *GormEmailChangeModel) fromChange(change *authentication.EmailChange) {
+	model.ID = change.ID
+	model.ProfileID = int64(change.ProfileID)
+	model.OldEmail = change.OldEmail
+	model.NewEmail = change.NewEmail
+	model.OldKey = string(change.OldKey)
+	model.NewKey = string(change.NewKey)
+	model.UndoKey = string(change.UndoKey)
+	model.State = string(change.State)
+	model.Expiries = change.Expiries
+	model.UndoExpiries = change.UndoExpiries
+}
+
+func (model *GormEmailChangeModel) toChange() *authentication.EmailChange {
+	return &authentication.EmailChange{
+		ID:           model.ID,
+		ProfileID:    authentication.ProfileID(model.ProfileID),
+		OldEmail:     model.OldEmail,
+		NewEmail:     model.NewEmail,
+		OldKey:       authentication.EmailSecretKey(model.OldKey),
+		NewKey:       authentication.EmailSecretKey(model.NewKey),
+		UndoKey:      authentication.EmailSecretKey(model.UndoKey),
+		State:        authentication.EmailChangeState(model.State),
+		Expiries:     model.Expiries,
+		UndoExpiries: model.UndoExpiries,
+		CreatedAt:    model.CreatedAt,
+	}
+}
+
+func (g *GormDriver) NewEmailChange(change *authentication.EmailChange) error {
+	model := &GormEmailChangeModel{}
+	model.fromChange(change)
+	err := g.db.Create(model).Error
+	if err != nil {
+		return err
+	}
+	change.ID = model.ID
+	change.CreatedAt = model.CreatedAt
+	return nil
+}
+
+func (g *GormDriver) GetEmailChangeByKey(key authentication.EmailSecretKey) (*authentication.EmailChange, error) {
+	if key == "" {
+		return nil, authentication.ErrEmailChangeNotFound
+	}
+
+	model := &GormEmailChangeModel{}
+	err := g.db.Where("old_key = ? OR new_key = ? OR undo_key = ?", string(key), string(key), string(key)).First(model).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = authentication.ErrEmailChangeNotFound
+		}
+		return nil, err
+	}
+	return model.toChange(), nil
+}
+
+// UpdateEmailChange условие на предыдущее состояние делает переходы атомарными
+func (g *GormDriver) UpdateEmailChange(change *authentication.EmailChange, prevState authentication.EmailChangeState) error {
+	res := g.db.Model(&GormEmailChangeModel{}).Where("id = ? AND state = ?", change.ID, string(prevState)).Updates(map[string]interface{}{
+		"state":         string(change.State),
+		"undo_key":      string(change.UndoKey),
+		"undo_expiries": change.UndoExpiries,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		return authentication.ErrEmailChangeConflict
+	}
+	return nil
+}
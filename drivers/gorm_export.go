@@ -0,0 +1,59 @@
+package drivers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/v-grabko1999/authentication"
+	"gorm.io/gorm"
+)
+
+func (g *GormDriver) GetProfileRecord(profileID authentication.ProfileID) (*authentication.ResultProfile, error) {
+	model := &GormProfileModel{}
+	err := g.db.Select("login", "email", "email_verified", "created_at", "updated_at").Where("id = ?", int64(profileID)).First(model).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, authentication.ErrProfileIdNotFound
+		}
+		return nil, err
+	}
+	return &authentication.ResultProfile{
+		Login:         model.Login,
+		Email:         model.Email,
+		EmailVerified: model.EmailVerified,
+		CreatedAt:     model.CreatedAt,
+		UpdatedAt:     model.UpdatedAt,
+	}, nil
+}
+
+func (g *GormDriver) ListTokens(profileID authentication.ProfileID) ([]*authentication.ResultToken, error) {
+	var models []GormTokenModel
+	err := g.db.Where("profile_id = ? AND expiries >= ?", int64(profileID), time.Now().Unix()).Order("auth_at").Find(&models).Error
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]*authentication.ResultToken, len(models))
+	for i := range models {
+		tokens[i] = &authentication.ResultToken{
+			TokenID:  authentication.TokenID(models[i].Key),
+			Expiries: models[i].Expiries,
+			AuthAt:   models[i].AuthAt,
+		}
+	}
+	return tokens, nil
+}
+
+func (g *GormDriver) ListEmailChanges(profileID authentication.ProfileID) ([]*authentication.EmailChange, error) {
+	var models []GormEmailChangeModel
+	err := g.db.Where("profile_id = ?", int64(profileID)).Order("id").Find(&models).Error
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]*authentication.EmailChange, len(models))
+	for i := range models {
+		changes[i] = models[i].toChange()
+	}
+	return changes, nil
+}
@@ -0,0 +1,137 @@
+package drivers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/v-grabko1999/authentication"
+	"gorm.io/gorm"
+)
+
+type GormKnownDeviceModel struct {
+	ID         int64            `gorm:"primarykey"`
+	ProfileID  int64            `gorm:"index"`
+	Profile    GormProfileModel `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	DeviceHash string           `gorm:"size:64"`
+	IPRange    string           `gorm:"size:64"`
+	IP         string           `gorm:"size:64"`
+	UserAgent  string           `gorm:"size:512"`
+	TokenID    string           `gorm:"size:36"`
+	RevokeKey  string           `gorm:"size:36;index"`
+	ConfirmKey string           `gorm:"size:36;index"`
+	Confirmed  bool
+	Expiries   int64
+	LastSeenAt time.Time
+
+	CreatedAt time.Time
+}
+
+func (model *GormKnownDeviceModel) toDevice() *authentication.KnownDevice {
+	return &authentication.KnownDevice{
+		ID:         model.ID,
+		ProfileID:  authentication.ProfileID(model.ProfileID),
+		DeviceHash: model.DeviceHash,
+		IPRange:    model.IPRange,
+		IP:         model.IP,
+		UserAgent:  model.UserAgent,
+		TokenID:    authentication.TokenID(model.TokenID),
+		RevokeKey:  authentication.EmailSecretKey(model.RevokeKey),
+		ConfirmKey: authentication.EmailSecretKey(model.ConfirmKey),
+		Confirmed:  model.Confirmed,
+		Expiries:   model.Expiries,
+		CreatedAt:  model.CreatedAt,
+		LastSeenAt: model.LastSeenAt,
+	}
+}
+
+func (g *GormDriver) NewKnownDevice(device *authentication.KnownDevice) error {
+	model := &GormKnownDeviceModel{
+		ProfileID:  int64(device.ProfileID),
+		DeviceHash: device.DeviceHash,
+		IPRange:    device.IPRange,
+		IP:         device.IP,
+		UserAgent:  device.UserAgent,
+		TokenID:    string(device.TokenID),
+		RevokeKey:  string(device.RevokeKey),
+		ConfirmKey: string(device.ConfirmKey),
+		Confirmed:  device.Confirmed,
+		Expiries:   device.Expiries,
+		LastSeenAt: time.Now(),
+	}
+	if err := g.db.Create(model).Error; err != nil {
+		return err
+	}
+	device.ID = model.ID
+	device.CreatedAt = model.CreatedAt
+	device.LastSeenAt = model.LastSeenAt
+	return nil
+}
+
+func (g *GormDriver) ListKnownDevices(profileID authentication.ProfileID) ([]*authentication.KnownDevice, error) {
+	var models []GormKnownDeviceModel
+	if err := g.db.Where("profile_id = ?", int64(profileID)).Order("id").Find(&models).Error; err != nil {
+		return nil, err
+	}
+
+	devices := make([]*authentication.KnownDevice, len(models))
+	for i := range models {
+		devices[i] = models[i].toDevice()
+	}
+	return devices, nil
+}
+
+func (g *GormDriver) SeenKnownDevice(id int64) error {
+	return g.db.Model(&GormKnownDeviceModel{}).Where("id = ?", id).Update("last_seen_at", time.Now()).Error
+}
+
+func (g *GormDriver) SetKnownDeviceToken(id int64, tokenID authentication.TokenID) error {
+	return g.db.Model(&GormKnownDeviceModel{}).Where("id = ?", id).Update("token_id", string(tokenID)).Error
+}
+
+func (g *GormDriver) ConfirmKnownDevice(confirmKey authentication.EmailSecretKey) (*authentication.KnownDevice, error) {
+	model := &GormKnownDeviceModel{}
+	err := g.db.Where("confirm_key = ? AND confirmed = ?", string(confirmKey), false).First(model).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = authentication.ErrKnownDeviceNotFound
+		}
+		return nil, err
+	}
+	if model.Expiries < time.Now().Unix() {
+		return nil, authentication.ErrKnownDeviceNotFound
+	}
+
+	res := g.db.Model(&GormKnownDeviceModel{}).Where("id = ? AND confirmed = ?", model.ID, false).
+		Updates(map[string]interface{}{"confirmed": true, "last_seen_at": time.Now()})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected != 1 {
+		return nil, authentication.ErrKnownDeviceNotFound
+	}
+	model.Confirmed = true
+	return model.toDevice(), nil
+}
+
+func (g *GormDriver) GetKnownDeviceByRevokeKey(revokeKey authentication.EmailSecretKey) (*authentication.KnownDevice, error) {
+	model := &GormKnownDeviceModel{}
+	err := g.db.Where("revoke_key = ?", string(revokeKey)).First(model).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = authentication.ErrKnownDeviceNotFound
+		}
+		return nil, err
+	}
+	return model.toDevice(), nil
+}
+
+func (g *GormDriver) DelKnownDevice(id int64, profileID authentication.ProfileID) error {
+	res := g.db.Where("id = ? AND profile_id = ?", id, int64(profileID)).Delete(&GormKnownDeviceModel{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		return authentication.ErrKnownDeviceNotFound
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package drivers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/v-grabko1999/authentication"
+	"gorm.io/gorm"
+)
+
+type GormMFAChallengeModel struct {
+	Key       string `gorm:"primarykey;size:36;autoIncrement:false"`
+	ProfileID int64
+	Profile   GormProfileModel `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Method    string           `gorm:"size:32"`
+	Expiries  int64
+	Attempts  int
+
+	CodeHash     string `gorm:"size:64"`
+	CodeExpiries int64
+	CodeAttempts int
+	CodeSentAt   int64
+}
+
+func (model *GormMFAChallengeModel) read(db *gorm.DB, challenge authentication.MFAChallengeID) error {
+	err := db.Where("key = ?", string(challenge)).First(model).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return authentication.ErrMFAChallengeNotFound
+		}
+		return err
+	}
+	//время жизни вызова истекло
+	if model.Expiries < time.Now().Unix() {
+		return authentication.ErrMFAChallengeNotFound
+	}
+	return nil
+}
+
+// GormTOTPModel Secret хранится зашифрованным
+type GormTOTPModel struct {
+	ProfileID int64            `gorm:"primarykey;autoIncrement:false"`
+	Profile   GormProfileModel `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Secret    string           `gorm:"size:255"`
+	Confirmed bool
+	LastStep  int64
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type GormRecoveryCodeModel struct {
+	ID        int64            `gorm:"primarykey"`
+	ProfileID int64            `gorm:"index"`
+	Profile   GormProfileModel `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	CodeHash  string           `gorm:"size:64"`
+
+	CreatedAt time.Time
+}
+
+func (g *GormDriver) NewMFAChallenge(challenge authentication.MFAChallengeID, profileID authentication.ProfileID, method string, lifetime int64) error {
+	return g.db.Create(&GormMFAChallengeModel{
+		Key:       string(challenge),
+		ProfileID: int64(profileID),
+		Method:    method,
+		Expiries:  time.Now().Unix() + lifetime,
+	}).Error
+}
+
+func (g *GormDriver) ReadMFAChallenge(challenge authentication.MFAChallengeID) (*authentication.ResultMFAChallenge, error) {
+	model := &GormMFAChallengeModel{}
+	if err := model.read(g.db, challenge); err != nil {
+		return nil, err
+	}
+	return &authentication.ResultMFAChallenge{
+		ProfileID: authentication.ProfileID(model.ProfileID),
+		Method:    model.Method,
+		Attempts:  model.Attempts,
+
+		CodeHash:     model.CodeHash,
+		CodeExpiries: model.CodeExpiries,
+		CodeAttempts: model.CodeAttempts,
+	}, nil
+}
+
+func (g *GormDriver) IncMFAChallengeAttempts(challenge authentication.MFAChallengeID) (int, error) {
+	res := g.db.Model(&GormMFAChallengeModel{}).Where("key = ?", string(challenge)).Update("attempts", gorm.Expr("attempts + 1"))
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return 0, authentication.ErrMFAChallengeNotFound
+	}
+
+	model := &GormMFAChallengeModel{}
+	if err := g.db.Select("attempts").Where("key = ?", string(challenge)).First(model).Error; err != nil {
+		return 0, err
+	}
+	return model.Attempts, nil
+}
+
+func (g *GormDriver) ConsumeMFAChallenge(challenge authentication.MFAChallengeID) (authentication.ProfileID, error) {
+	model := &GormMFAChallengeModel{}
+	if err := model.read(g.db, challenge); err != nil {
+		return 0, err
+	}
+
+	res := g.db.Where("key = ?", string(challenge)).Delete(&GormMFAChallengeModel{})
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	if res.RowsAffected != 1 {
+		return 0, authentication.ErrMFAChallengeNotFound
+	}
+	return authentication.ProfileID(model.ProfileID), nil
+}
+
+func (g *GormDriver) DelMFAChallenge(challenge authentication.MFAChallengeID) error {
+	return g.db.Where("key = ?", string(challenge)).Delete(&GormMFAChallengeModel{}).Error
+}
+
+func (g *GormDriver) SetMFAChallengeCode(challenge authentication.MFAChallengeID, codeHash string, lifetime int64, resendInterval int64) (bool, error) {
+	now := time.Now().Unix()
+	res := g.db.Model(&GormMFAChallengeModel{}).
+		Where("key = ? AND expiries >= ? AND code_sent_at <= ?", string(challenge), now, now-resendInterval).
+		Updates(map[string]interface{}{
+			"code_hash":     codeHash,
+			"code_expiries": now + lifetime,
+			"code_attempts": 0,
+			"code_sent_at":  now,
+		})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected == 1 {
+		return true, nil
+	}
+
+	//вызов мог исчезнуть, а не быть ограничен по частоте
+	model := &GormMFAChallengeModel{}
+	if err := model.read(g.db, challenge); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+func (g *GormDriver) IncMFAChallengeCodeAttempts(challenge authentication.MFAChallengeID) (int, error) {
+	res := g.db.Model(&GormMFAChallengeModel{}).Where("key = ?", string(challenge)).Update("code_attempts", gorm.Expr("code_attempts + 1"))
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return 0, authentication.ErrMFAChallengeNotFound
+	}
+
+	model := &GormMFAChallengeModel{}
+	if err := g.db.Select("code_attempts").Where("key = ?", string(challenge)).First(model).Error; err != nil {
+		return 0, err
+	}
+	return model.CodeAttempts, nil
+}
+
+func (g *GormDriver) SetMFAEmail(profileID authentication.ProfileID, enabled bool) error {
+	return g.db.Model(&GormProfileModel{}).Where("id = ?", int64(profileID)).Update("mfa_email", enabled).Error
+}
+
+func (g *GormDriver) IsMFAEmailEnabled(profileID authentication.ProfileID) (bool, error) {
+	model := &GormProfileModel{}
+	err := g.db.Select("mfa_email").Where("id = ?", int64(profileID)).First(model).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = authentication.ErrProfileIdNotFound
+		}
+		return false, err
+	}
+	return model.MFAEmail, nil
+}
+
+func (g *GormDriver) SetTOTP(profileID authentication.ProfileID, secret string) error {
+	if err := g.DelTOTP(profileID); err != nil {
+		return err
+	}
+	return g.db.Create(&GormTOTPModel{
+		ProfileID: int64(profileID),
+		Secret:    secret,
+	}).Error
+}
+
+func (g *GormDriver) GetTOTP(profileID authentication.ProfileID) (*authentication.ResultTOTP, error) {
+	model := &GormTOTPModel{}
+	err := g.db.Where("profile_id = ?", int64(profileID)).First(model).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = authentication.ErrTOTPNotFound
+		}
+		return nil, err
+	}
+	return &authentication.ResultTOTP{
+		Secret:    model.Secret,
+		Confirmed: model.Confirmed,
+		LastStep:  model.LastStep,
+	}, nil
+}
+
+func (g *GormDriver) ConfirmTOTP(profileID authentication.ProfileID) error {
+	return g.db.Model(&GormTOTPModel{}).Where("profile_id = ?", int64(profileID)).Update("confirmed", true).Error
+}
+
+func (g *GormDriver) UseTOTPStep(profileID authentication.ProfileID, step int64) (bool, error) {
+	res := g.db.Model(&GormTOTPModel{}).Where("profile_id = ? AND last_step < ?", int64(profileID), step).Update("last_step", step)
+	return res.RowsAffected == 1, res.Error
+}
+
+func (g *GormDriver) DelTOTP(profileID authentication.ProfileID) error {
+	return g.db.Where("profile_id = ?", int64(profileID)).Delete(&GormTOTPModel{}).Error
+}
+
+func (g *GormDriver) SetRecoveryCodes(profileID authentication.ProfileID, hashes []string) error {
+	return g.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("profile_id = ?", int64(profileID)).Delete(&GormRecoveryCodeModel{}).Error
+		if err != nil {
+			return err
+		}
+
+		models := make([]GormRecoveryCodeModel, len(hashes))
+		for i, hash := range hashes {
+			models[i] = GormRecoveryCodeModel{ProfileID: int64(profileID), CodeHash: hash}
+		}
+		return tx.Create(&models).Error
+	})
+}
+
+func (g *GormDriver) UseRecoveryCode(profileID authentication.ProfileID, hash string) (bool, error) {
+	res := g.db.Where("profile_id = ? AND code_hash = ?", int64(profileID), hash).Delete(&GormRecoveryCodeModel{})
+	return res.RowsAffected == 1, res.Error
+}
+
+func (g *GormDriver) CountRecoveryCodes(profileID authentication.ProfileID) (int, error) {
+	var count int64
+	err := g.db.Model(&GormRecoveryCodeModel{}).Where("profile_id = ?", int64(profileID)).Count(&count).Error
+	return int(count), err
+}
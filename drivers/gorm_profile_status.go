@@ -0,0 +1,120 @@
+package drivers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/v-grabko1999/authentication"
+	"gorm.io/gorm"
+)
+
+func (g *GormDriver) GetProfileStatus(profileID authentication.ProfileID) (*authentication.ProfileStatus, error) {
+	model := &GormProfileModel{}
+	err := g.db.Select("status", "status_reason", "status_until", "status_changed_at").Where("id = ?", int64(profileID)).First(model).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, authentication.ErrProfileIdNotFound
+		}
+		return nil, err
+	}
+
+	status := &authentication.ProfileStatus{
+		Status: authentication.AccountStatus(model.Status),
+		Reason: model.StatusReason,
+	}
+	if status.Status == "" {
+		status.Status = authentication.StatusActive
+	}
+	if model.StatusUntil != 0 {
+		status.Until = time.Unix(model.StatusUntil, 0)
+	}
+	if model.StatusChangedAt != 0 {
+		status.ChangedAt = time.Unix(model.StatusChangedAt, 0)
+	}
+	return status, nil
+}
+
+func (g *GormDriver) SetProfileStatus(profileID authentication.ProfileID, status *authentication.ProfileStatus) error {
+	var until int64
+	if !status.Until.IsZero() {
+		until = status.Until.Unix()
+	}
+	res := g.db.Model(&GormProfileModel{}).Where("id = ?", int64(profileID)).Updates(map[string]interface{}{
+		"status":            string(status.Status),
+		"status_reason":     status.Reason,
+		"status_until":      until,
+		"status_changed_at": status.ChangedAt.Unix(),
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return authentication.ErrProfileIdNotFound
+	}
+	return nil
+}
+
+func (g *GormDriver) MarkProfileDeleted(profileID authentication.ProfileID, restoreKey authentication.EmailSecretKey, purgeAt int64) error {
+	res := g.db.Model(&GormProfileModel{}).Where("id = ?", int64(profileID)).Updates(map[string]interface{}{
+		"status":            string(authentication.StatusPendingDeletion),
+		"status_reason":     "",
+		"status_until":      purgeAt,
+		"status_changed_at": time.Now().Unix(),
+		"restore_key":       string(restoreKey),
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return authentication.ErrProfileIdNotFound
+	}
+	return nil
+}
+
+// RestoreProfile условие на статус и срок не дает восстановить профиль, который уже удаляется
+func (g *GormDriver) RestoreProfile(restoreKey authentication.EmailSecretKey) (authentication.ProfileID, error) {
+	if restoreKey == "" {
+		return 0, authentication.ErrRestoreKeyNotFound
+	}
+	model := &GormProfileModel{}
+	err := g.db.Select("id").Where("restore_key = ?", string(restoreKey)).First(model).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, authentication.ErrRestoreKeyNotFound
+		}
+		return 0, err
+	}
+
+	now := time.Now().Unix()
+	res := g.db.Model(&GormProfileModel{}).
+		Where("id = ? AND restore_key = ? AND status = ? AND status_until >= ?", model.ID, string(restoreKey), string(authentication.StatusPendingDeletion), now).
+		Updates(map[string]interface{}{
+			"status":            string(authentication.StatusActive),
+			"status_until":      0,
+			"status_changed_at": now,
+			"restore_key":       "",
+		})
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return 0, authentication.ErrRestoreKeyNotFound
+	}
+	return authentication.ProfileID(model.ID), nil
+}
+
+func (g *GormDriver) ListProfilesToPurge(before int64, limit int) ([]authentication.ProfileID, error) {
+	var ids []int64
+	err := g.db.Model(&GormProfileModel{}).
+		Where("status = ? AND status_until < ?", string(authentication.StatusPendingDeletion), before).
+		Order("id").Limit(limit).Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]authentication.ProfileID, len(ids))
+	for i, id := range ids {
+		res[i] = authentication.ProfileID(id)
+	}
+	return res, nil
+}
@@ -0,0 +1,33 @@
+package drivers
+
+import (
+	"time"
+
+	"github.com/v-grabko1999/authentication"
+	"gorm.io/gorm/clause"
+)
+
+// GormProofOfWorkNonceModel использованные задачи хранятся до истечения их срока
+type GormProofOfWorkNonceModel struct {
+	Nonce    string `gorm:"primarykey;size:36;autoIncrement:false"`
+	Expiries int64  `gorm:"index"`
+}
+
+func (g *GormDriver) UseProofOfWorkNonce(nonce string, expiries int64) error {
+	err := g.db.Where("expiries < ?", time.Now().Unix()).Delete(&GormProofOfWorkNonceModel{}).Error
+	if err != nil {
+		return err
+	}
+
+	res := g.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&GormProofOfWorkNonceModel{
+		Nonce:    nonce,
+		Expiries: expiries,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return authentication.ErrProofOfWorkUsed
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package drivers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/v-grabko1999/authentication"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type GormRoleModel struct {
+	Name      string `gorm:"primarykey;size:64;autoIncrement:false"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type GormRolePermissionModel struct {
+	Role       string `gorm:"primarykey;size:64;autoIncrement:false"`
+	Permission string `gorm:"primarykey;size:128;autoIncrement:false"`
+}
+
+// GormRoleParentModel роль Role наследует разрешения роли Parent
+type GormRoleParentModel struct {
+	Role   string `gorm:"primarykey;size:64;autoIncrement:false"`
+	Parent string `gorm:"primarykey;size:64;autoIncrement:false;index"`
+}
+
+type GormProfileRoleModel struct {
+	ProfileID int64            `gorm:"primarykey;autoIncrement:false"`
+	Profile   GormProfileModel `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Role      string           `gorm:"primarykey;size:64;autoIncrement:false;index"`
+	CreatedAt time.Time
+}
+
+func (g *GormDriver) SaveRole(role *authentication.RoleDef) error {
+	return g.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"updated_at"}),
+		}).Create(&GormRoleModel{Name: string(role.Name)}).Error
+		if err != nil {
+			return err
+		}
+
+		name := string(role.Name)
+		if err := tx.Where("role = ?", name).Delete(&GormRolePermissionModel{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role = ?", name).Delete(&GormRoleParentModel{}).Error; err != nil {
+			return err
+		}
+
+		if len(role.Permissions) > 0 {
+			perms := make([]GormRolePermissionModel, 0, len(role.Permissions))
+			for _, perm := range role.Permissions {
+				perms = append(perms, GormRolePermissionModel{Role: name, Permission: string(perm)})
+			}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&perms).Error; err != nil {
+				return err
+			}
+		}
+		if len(role.Parents) > 0 {
+			parents := make([]GormRoleParentModel, 0, len(role.Parents))
+			for _, parent := range role.Parents {
+				parents = append(parents, GormRoleParentModel{Role: name, Parent: string(parent)})
+			}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&parents).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (g *GormDriver) DelRole(name authentication.Role) error {
+	return g.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("name = ?", string(name)).Delete(&GormRoleModel{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return authentication.ErrRoleNotFound
+		}
+
+		if err := tx.Where("role = ?", string(name)).Delete(&GormRolePermissionModel{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role = ? OR parent = ?", string(name), string(name)).Delete(&GormRoleParentModel{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("role = ?", string(name)).Delete(&GormProfileRoleModel{}).Error
+	})
+}
+
+func (g *GormDriver) GetRole(name authentication.Role) (*authentication.RoleDef, error) {
+	err := g.db.Where("name = ?", string(name)).First(&GormRoleModel{}).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, authentication.ErrRoleNotFound
+		}
+		return nil, err
+	}
+
+	roles, err := g.loadRoles(g.db.Where("role = ?", string(name)))
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) == 0 {
+		return &authentication.RoleDef{Name: name}, nil
+	}
+	return roles[0], nil
+}
+
+func (g *GormDriver) ListRoles() ([]*authentication.RoleDef, error) {
+	var names []string
+	if err := g.db.Model(&GormRoleModel{}).Order("name").Pluck("name", &names).Error; err != nil {
+		return nil, err
+	}
+	loaded, err := g.loadRoles(g.db)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[authentication.Role]*authentication.RoleDef, len(loaded))
+	for _, role := range loaded {
+		byName[role.Name] = role
+	}
+	roles := make([]*authentication.RoleDef, len(names))
+	for i, name := range names {
+		role, ok := byName[authentication.Role(name)]
+		if !ok {
+			role = &authentication.RoleDef{Name: authentication.Role(name)}
+		}
+		roles[i] = role
+	}
+	return roles, nil
+}
+
+// loadRoles собирает разрешения и родителей ролей, отобранных scope. Роли без них не возвращаются
+func (g *GormDriver) loadRoles(scope *gorm.DB) ([]*authentication.RoleDef, error) {
+	var perms []GormRolePermissionModel
+	if err := scope.Session(&gorm.Session{}).Order("role, permission").Find(&perms).Error; err != nil {
+		return nil, err
+	}
+	var parents []GormRoleParentModel
+	if err := scope.Session(&gorm.Session{}).Order("role, parent").Find(&parents).Error; err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*authentication.RoleDef)
+	var roles []*authentication.RoleDef
+	get := func(name string) *authentication.RoleDef {
+		role, ok := byName[name]
+		if !ok {
+			role = &authentication.RoleDef{Name: authentication.Role(name)}
+			byName[name] = role
+			roles = append(roles, role)
+		}
+		return role
+	}
+	for _, perm := range perms {
+		role := get(perm.Role)
+		role.Permissions = append(role.Permissions, authentication.Permission(perm.Permission))
+	}
+	for _, parent := range parents {
+		role := get(parent.Role)
+		role.Parents = append(role.Parents, authentication.Role(parent.Parent))
+	}
+	return roles, nil
+}
+
+func (g *GormDriver) AssignRole(profileID authentication.ProfileID, role authentication.Role) error {
+	err := g.db.Where("name = ?", string(role)).First(&GormRoleModel{}).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return authentication.ErrRoleNotFound
+		}
+		return err
+	}
+	exists, err := g.ProfileExist(profileID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return authentication.ErrProfileIdNotFound
+	}
+
+	return g.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&GormProfileRoleModel{
+		ProfileID: int64(profileID),
+		Role:      string(role),
+	}).Error
+}
+
+func (g *GormDriver) RevokeRole(profileID authentication.ProfileID, role authentication.Role) error {
+	return g.db.Where("profile_id = ? AND role = ?", int64(profileID), string(role)).Delete(&GormProfileRoleModel{}).Error
+}
+
+func (g *GormDriver) ListProfileRoles(profileID authentication.ProfileID) ([]authentication.Role, error) {
+	var names []string
+	err := g.db.Model(&GormProfileRoleModel{}).Where("profile_id = ?", int64(profileID)).Order("role").Pluck("role", &names).Error
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make([]authentication.Role, len(names))
+	for i, name := range names {
+		roles[i] = authentication.Role(name)
+	}
+	return roles, nil
+}
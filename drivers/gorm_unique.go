@@ -0,0 +1,77 @@
+package drivers
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/v-grabko1999/authentication"
+	"gorm.io/gorm"
+)
+
+// uniqueViolation признаки нарушения уникального индекса в ошибках SQLite, MySQL и Postgres
+var uniqueViolation = []string{
+	"UNIQUE constraint failed",     //sqlite
+	"Error 1062",                   //mysql
+	"Duplicate entry",              //mysql
+	"SQLSTATE 23505",               //postgres
+	"violates unique constraint",   //postgres
+	"duplicate key value violates", //postgres
+}
+
+func isUniqueViolation(err error) bool {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return true
+	}
+	msg := err.Error()
+	for _, sign := range uniqueViolation {
+		if strings.Contains(msg, sign) {
+			return true
+		}
+	}
+	return false
+}
+
+// profileUniqueError переводит нарушение уникальности профиля в ErrLoginNotUnique или
+// ErrEmailNotUnique по имени колонки или индекса в сообщении. Если база данных его не
+// сообщает (gorm TranslateError), занятое значение проверяется запросом. Нарушение,
+// которое не удалось отнести ни к логину, ни к E-MAIL, возвращается как есть
+func profileUniqueError(db *gorm.DB, err error, login, email string) error {
+	if err == nil || !isUniqueViolation(err) {
+		return err
+	}
+
+	//значение из сообщения MySQL не учитывается, только имя индекса после него
+	msg := err.Error()
+	for _, prefix := range []string{"constraint failed:", "for key", "unique constraint"} {
+		if i := strings.LastIndex(msg, prefix); i >= 0 {
+			msg = msg[i+len(prefix):]
+			break
+		}
+	}
+	switch {
+	case strings.Contains(msg, "email"):
+		return authentication.ErrEmailNotUnique
+	case strings.Contains(msg, "login"):
+		return authentication.ErrLoginNotUnique
+	}
+
+	if login != "" {
+		taken, qerr := canonicalTaken(db, "login_canonical", authentication.CanonicalLogin(login))
+		if qerr != nil {
+			return err
+		}
+		if taken {
+			return authentication.ErrLoginNotUnique
+		}
+	}
+	if email != "" {
+		taken, qerr := canonicalTaken(db, "email_canonical", authentication.CanonicalEmail(email))
+		if qerr != nil {
+			return err
+		}
+		if taken {
+			return authentication.ErrEmailNotUnique
+		}
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package drivers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/v-grabko1999/authentication"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestProfileUniqueError(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:unique?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&GormProfileModel{}); err != nil {
+		t.Fatal(err)
+	}
+
+	unresolved := errors.New("Error 1062 (23000): Duplicate entry 'x' for key 'gorm_profile_models.idx_other'")
+	for _, tt := range []struct {
+		msg  string
+		want error
+	}{
+		{"UNIQUE constraint failed: gorm_profile_models.login_canonical", authentication.ErrLoginNotUnique},
+		{"UNIQUE constraint failed: gorm_profile_models.email", authentication.ErrEmailNotUnique},
+		//значение в MySQL может содержать имя другой колонки
+		{"Error 1062 (23000): Duplicate entry 'login@example.com' for key 'gorm_profile_models.idx_gorm_profile_models_email_canonical'", authentication.ErrEmailNotUnique},
+		{"Error 1062 (23000): Duplicate entry 'email' for key 'gorm_profile_models.idx_gorm_profile_models_login'", authentication.ErrLoginNotUnique},
+		{`ERROR: duplicate key value violates unique constraint "idx_gorm_profile_models_email_canonical" (SQLSTATE 23505)`, authentication.ErrEmailNotUnique},
+		{`ERROR: duplicate key value violates unique constraint "idx_gorm_profile_models_login_canonical" (SQLSTATE 23505)`, authentication.ErrLoginNotUnique},
+		{"record not found", nil},
+	} {
+		err := profileUniqueError(db, errors.New(tt.msg), "login", "login@example.com")
+		if tt.want == nil {
+			if err == nil || err.Error() != tt.msg {
+				t.Fatalf("profileUniqueError(%q) = %v, want original error", tt.msg, err)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.want) {
+			t.Fatalf("profileUniqueError(%q) = %v, want %v", tt.msg, err, tt.want)
+		}
+	}
+
+	//ни логин, ни E-MAIL не заняты: ошибка возвращается как есть
+	if err := profileUniqueError(db, unresolved, "login", "login@example.com"); err != unresolved {
+		t.Fatal("profileUniqueError unresolved violation: ", err)
+	}
+	if err := profileUniqueError(db, gorm.ErrDuplicatedKey, "login", "login@example.com"); err != gorm.ErrDuplicatedKey {
+		t.Fatal("profileUniqueError translated violation: ", err)
+	}
+	login, email := "login", "login@example.com"
+	if err := db.Create(&GormProfileModel{Login: login, Email: email, LoginCanonical: &login, EmailCanonical: &email}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := profileUniqueError(db, gorm.ErrDuplicatedKey, "", email); !errors.Is(err, authentication.ErrEmailNotUnique) {
+		t.Fatal("profileUniqueError translated email violation: ", err)
+	}
+	if err := profileUniqueError(db, gorm.ErrDuplicatedKey, login, "other@example.com"); !errors.Is(err, authentication.ErrLoginNotUnique) {
+		t.Fatal("profileUniqueError translated login violation: ", err)
+	}
+}
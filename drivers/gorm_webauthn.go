@@ -0,0 +1,140 @@
+package drivers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/v-grabko1999/authentication"
+	"gorm.io/gorm"
+)
+
+// GormWebAuthnSessionModel ProfileID 0 - сессия входа, профиль еще не известен
+type GormWebAuthnSessionModel struct {
+	Challenge string `gorm:"primarykey;size:64;autoIncrement:false"`
+	ProfileID int64  `gorm:"index"`
+	Expiries  int64
+}
+
+type GormWebAuthnCredentialModel struct {
+	ID         string           `gorm:"primarykey;size:255;autoIncrement:false"`
+	ProfileID  int64            `gorm:"index"`
+	Profile    GormProfileModel `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	PublicKey  []byte
+	SignCount  uint32
+	Name       string `gorm:"size:255"`
+	LastUsedAt time.Time
+
+	CreatedAt time.Time
+}
+
+func (model *GormWebAuthnCredentialModel) toCredential() *authentication.WebAuthnCredential {
+	return &authentication.WebAuthnCredential{
+		ID:         model.ID,
+		ProfileID:  authentication.ProfileID(model.ProfileID),
+		PublicKey:  model.PublicKey,
+		SignCount:  model.SignCount,
+		Name:       model.Name,
+		CreatedAt:  model.CreatedAt,
+		LastUsedAt: model.LastUsedAt,
+	}
+}
+
+func (g *GormDriver) NewWebAuthnSession(challenge string, profileID authentication.ProfileID, lifetime int64) error {
+	return g.db.Create(&GormWebAuthnSessionModel{
+		Challenge: challenge,
+		ProfileID: int64(profileID),
+		Expiries:  time.Now().Unix() + lifetime,
+	}).Error
+}
+
+func (g *GormDriver) ConsumeWebAuthnSession(challenge string) (authentication.ProfileID, error) {
+	model := &GormWebAuthnSessionModel{}
+	err := g.db.Where("challenge = ?", challenge).First(model).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, authentication.ErrWebAuthnSessionNotFound
+		}
+		return 0, err
+	}
+
+	res := g.db.Where("challenge = ?", challenge).Delete(&GormWebAuthnSessionModel{})
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	if res.RowsAffected != 1 {
+		return 0, authentication.ErrWebAuthnSessionNotFound
+	}
+
+	//время жизни сессии истекло
+	if model.Expiries < time.Now().Unix() {
+		return 0, authentication.ErrWebAuthnSessionNotFound
+	}
+	return authentication.ProfileID(model.ProfileID), nil
+}
+
+func (g *GormDriver) NewWebAuthnCredential(cred *authentication.WebAuthnCredential) error {
+	var count int64
+	if err := g.db.Model(&GormWebAuthnCredentialModel{}).Where("id = ?", cred.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return authentication.ErrWebAuthnCredentialExists
+	}
+
+	return g.db.Create(&GormWebAuthnCredentialModel{
+		ID:        cred.ID,
+		ProfileID: int64(cred.ProfileID),
+		PublicKey: cred.PublicKey,
+		SignCount: cred.SignCount,
+		Name:      cred.Name,
+	}).Error
+}
+
+func (g *GormDriver) GetWebAuthnCredential(id string) (*authentication.WebAuthnCredential, error) {
+	model := &GormWebAuthnCredentialModel{}
+	err := g.db.Where("id = ?", id).First(model).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = authentication.ErrWebAuthnCredentialNotFound
+		}
+		return nil, err
+	}
+	return model.toCredential(), nil
+}
+
+func (g *GormDriver) ListWebAuthnCredentials(profileID authentication.ProfileID) ([]*authentication.WebAuthnCredential, error) {
+	var models []GormWebAuthnCredentialModel
+	if err := g.db.Where("profile_id = ?", int64(profileID)).Order("created_at").Find(&models).Error; err != nil {
+		return nil, err
+	}
+
+	creds := make([]*authentication.WebAuthnCredential, len(models))
+	for i := range models {
+		creds[i] = models[i].toCredential()
+	}
+	return creds, nil
+}
+
+func (g *GormDriver) UpdateWebAuthnSignCount(id string, signCount uint32) error {
+	res := g.db.Model(&GormWebAuthnCredentialModel{}).
+		Where("id = ? AND (sign_count < ? OR (sign_count = 0 AND ? = 0))", id, signCount, signCount).
+		Updates(map[string]interface{}{"sign_count": signCount, "last_used_at": time.Now()})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		return authentication.ErrWebAuthnSignCount
+	}
+	return nil
+}
+
+func (g *GormDriver) DelWebAuthnCredential(id string, profileID authentication.ProfileID) error {
+	res := g.db.Where("id = ? AND profile_id = ?", id, int64(profileID)).Delete(&GormWebAuthnCredentialModel{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		return authentication.ErrWebAuthnCredentialNotFound
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package drivers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/v-grabko1999/authentication"
+)
+
+// JSONLinesSink подписчик событий, пишущий каждое событие отдельной строкой JSON
+type JSONLinesSink struct {
+	mu sync.Mutex
+	w  io.Writer
+	//path файл, открытый OpenJSONLinesFile, его переписывает ScrubProfile
+	path string
+}
+
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{w: w}
+}
+
+// OpenJSONLinesFile открывает файл на дозапись, Close закрывает его
+func OpenJSONLinesFile(path string) (*JSONLinesSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLinesSink{w: f, path: path}, nil
+}
+
+func (s *JSONLinesSink) HandleEvent(event *authentication.Event) error {
+	bs, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	bs = append(bs, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(bs)
+	return err
+}
+
+// ScrubProfile стирает Meta и Data в строках профиля и в строках с ProfileID 0 о его логине.
+// Файл переписывается целиком через временный, поэтому поддерживается только для OpenJSONLinesFile
+func (s *JSONLinesSink) ScrubProfile(profileID authentication.ProfileID, login, email string) error {
+	if s.path == "" {
+		return errors.New("jsonl sink is not backed by a file")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	src, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".scrub-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := scrubJSONLines(src, tmp, profileID, login, email); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	//прежний файл закрывается только после замены: если переименование не удалось,
+	//события продолжают дописываться в него
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	old := s.w
+	s.w = f
+	if c, ok := old.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func scrubJSONLines(r io.Reader, w io.Writer, profileID authentication.ProfileID, login, email string) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	bw := bufio.NewWriter(w)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		event := &authentication.Event{}
+		if err := json.Unmarshal(line, event); err != nil {
+			return err
+		}
+		if authentication.AuditEventOf(event, profileID, login, email) {
+			event.Meta = authentication.RequestMeta{}
+			event.Data = nil
+			bs, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			line = bs
+		}
+		if _, err := bw.Write(line); err != nil {
+			return err
+		}
+		if err := bw.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (s *JSONLinesSink) Close() error {
+	if c, ok := s.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
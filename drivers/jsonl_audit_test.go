@@ -0,0 +1,40 @@
+package drivers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/v-grabko1999/authentication"
+)
+
+func TestJSONLinesScrubKeepsWriting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := OpenJSONLinesFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	event := &authentication.Event{Type: authentication.EventLoginSucceeded, ProfileID: 7, Data: map[string]string{"login": "alice"}}
+	if err := sink.HandleEvent(event); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.ScrubProfile(7, "alice", "alice@example.com"); err != nil {
+		t.Fatal("ScrubProfile error: ", err)
+	}
+
+	//после замены файла события дописываются в новый файл
+	if err := sink.HandleEvent(&authentication.Event{Type: authentication.EventLoginSucceeded, ProfileID: 8}); err != nil {
+		t.Fatal(err)
+	}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(bs)), "\n")
+	if len(lines) != 2 || strings.Contains(lines[0], "alice") || !strings.Contains(lines[1], `"profile_id":8`) {
+		t.Fatal("jsonl file after scrub: ", string(bs))
+	}
+}
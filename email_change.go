@@ -0,0 +1,209 @@
+package authentication
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type EmailChangeState string
+
+const (
+	EmailChangePending      EmailChangeState = "pending"
+	EmailChangeOldConfirmed EmailChangeState = "old_confirmed"
+	EmailChangeNewConfirmed EmailChangeState = "new_confirmed"
+	EmailChangeApplied      EmailChangeState = "applied"
+	EmailChangeReverted     EmailChangeState = "reverted"
+)
+
+const defaultEmailChangeUndoLifeTime = 7 * 24 * 60 * 60
+
+// EmailChange смена E-MAIL с подтверждением старого и нового адреса.
+// После применения на старый адрес уходит UndoKey для отмены смены
+type EmailChange struct {
+	ID        int64
+	ProfileID ProfileID
+	OldEmail  string
+	NewEmail  string
+
+	OldKey  EmailSecretKey
+	NewKey  EmailSecretKey
+	UndoKey EmailSecretKey
+
+	State        EmailChangeState
+	Expiries     int64
+	UndoExpiries int64
+	CreatedAt    time.Time
+}
+
+// RequestEmailChange отправляет ключи подтверждения на старый и новый E-MAIL.
+// Смена применяется только после Auth.ConfirmEmailChange с обоими ключами.
+// Если настроен Mailer, ключи приходят только письмами и в ответе пусты
+func (t *Profile) RequestEmailChange(password, newEmail string) (*EmailChange, error) {
+	if err := t.checkPassword(password); err != nil {
+		return nil, err
+	}
+
+	unique, err := t.cfg.st.IsUniqueEmail(newEmail)
+	if err != nil {
+		return nil, err
+	}
+	if !unique {
+		return nil, ErrEmailNotUnique
+	}
+
+	email, err := t.GetEmail()
+	if err != nil {
+		return nil, err
+	}
+
+	change := &EmailChange{
+		ProfileID: t.ProfileID,
+		OldEmail:  email,
+		NewEmail:  newEmail,
+		OldKey:    EmailSecretKey(uuid.New().String()),
+		NewKey:    EmailSecretKey(uuid.New().String()),
+		State:     EmailChangePending,
+		Expiries:  time.Now().Unix() + t.cfg.emailLifeTime,
+	}
+	if err := t.cfg.st.NewEmailChange(change); err != nil {
+		return nil, err
+	}
+
+	if err := t.cfg.mail.sendKey(MailChangeEmail, change.OldEmail, change.OldKey); err != nil {
+		return nil, err
+	}
+	if err := t.cfg.mail.sendKey(MailChangeEmailNew, change.NewEmail, change.NewKey); err != nil {
+		return nil, err
+	}
+	//ключи подтверждают владение адресами и не должны попадать к вызывающему
+	if t.cfg.mail.enabled() {
+		change.OldKey, change.NewKey = "", ""
+	}
+	return change, nil
+}
+
+// ConfirmEmailChange принимает ключ старого или нового адреса.
+// Второе подтверждение применяет смену и выдает UndoKey
+func (a *Auth) ConfirmEmailChange(key EmailSecretKey) (*EmailChange, error) {
+	//повторяем при гонке с подтверждением второго адреса
+	for i := 0; i < 3; i++ {
+		change, err := a.confirmEmailChange(key)
+		if err != ErrEmailChangeConflict {
+			//владелец одного адреса не должен получить ключ другого
+			if change != nil {
+				change.OldKey, change.NewKey = "", ""
+			}
+			return change, err
+		}
+	}
+	return nil, ErrEmailChangeConflict
+}
+
+func (a *Auth) confirmEmailChange(key EmailSecretKey) (*EmailChange, error) {
+	change, err := a.st.GetEmailChangeByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if key != change.OldKey && key != change.NewKey {
+		return nil, ErrEmailChangeNotFound
+	}
+	if change.Expiries < time.Now().Unix() {
+		return nil, ErrEmailChangeNotFound
+	}
+
+	prev := change.State
+	switch {
+	case prev == EmailChangePending && key == change.OldKey:
+		change.State = EmailChangeOldConfirmed
+	case prev == EmailChangePending && key == change.NewKey:
+		change.State = EmailChangeNewConfirmed
+	case prev == EmailChangeNewConfirmed && key == change.OldKey,
+		prev == EmailChangeOldConfirmed && key == change.NewKey:
+		return change, a.applyEmailChange(change)
+	case prev == EmailChangeOldConfirmed || prev == EmailChangeNewConfirmed:
+		//этот адрес уже подтвержден
+		return change, nil
+	default:
+		return nil, ErrEmailChangeNotFound
+	}
+
+	return change, a.st.UpdateEmailChange(change, prev)
+}
+
+func (a *Auth) applyEmailChange(change *EmailChange) error {
+	email, err := a.st.GetEmail(change.ProfileID)
+	if err != nil {
+		return err
+	}
+	//E-MAIL профиля изменился после создания заявки
+	if email != change.OldEmail && email != change.NewEmail {
+		return ErrEmailChangeNotFound
+	}
+
+	//заявку применяет только тот, кто первым сменил ее состояние
+	prev := change.State
+	change.State = EmailChangeApplied
+	change.UndoKey = EmailSecretKey(uuid.New().String())
+	change.UndoExpiries = time.Now().Unix() + a.emailChangeUndoLifeTime
+	if err := a.st.UpdateEmailChange(change, prev); err != nil {
+		return err
+	}
+
+	if err := a.setChangedEmail(change); err != nil {
+		//возвращаем заявку, чтобы подтверждение можно было повторить
+		undo := *change
+		undo.State, undo.UndoKey, undo.UndoExpiries = prev, "", 0
+		if undoErr := a.st.UpdateEmailChange(&undo, EmailChangeApplied); undoErr != nil {
+			return undoErr
+		}
+		return err
+	}
+	a.emit(EventEmailChanged, change.ProfileID, map[string]string{"email": change.OldEmail, "new_email": change.NewEmail})
+
+	return a.mail.send(MailChangeEmailUndo, change.OldEmail, &MailData{
+		Key:      change.UndoKey,
+		Link:     a.mail.link(MailChangeEmailUndo, change.UndoKey),
+		NewEmail: change.NewEmail,
+	})
+}
+
+func (a *Auth) setChangedEmail(change *EmailChange) error {
+	if err := a.st.SetEmailByProfileID(change.ProfileID, change.NewEmail); err != nil {
+		return err
+	}
+	//новый адрес подтвержден владельцем
+	return a.st.SetEmailVerified(change.ProfileID, true)
+}
+
+// UndoEmailChange возвращает прежний E-MAIL и отзывает все токены профиля
+func (a *Auth) UndoEmailChange(undoKey EmailSecretKey) error {
+	change, err := a.st.GetEmailChangeByKey(undoKey)
+	if err != nil {
+		return err
+	}
+	if undoKey != change.UndoKey || change.State != EmailChangeApplied {
+		return ErrEmailChangeNotFound
+	}
+	if change.UndoExpiries < time.Now().Unix() {
+		return ErrEmailChangeNotFound
+	}
+
+	//сначала возвращается адрес: если его уже занял другой профиль,
+	//изменение остается примененным и ключ отмены можно использовать повторно
+	if err := a.st.SetEmailByProfileID(change.ProfileID, change.OldEmail); err != nil {
+		return err
+	}
+	if err := a.st.SetEmailVerified(change.ProfileID, true); err != nil {
+		return err
+	}
+	change.State = EmailChangeReverted
+	if err := a.st.UpdateEmailChange(change, EmailChangeApplied); err != nil {
+		return err
+	}
+	if err := a.st.DelTokensByProfileID(change.ProfileID); err != nil {
+		return err
+	}
+	a.emit(EventEmailChangeUndone, change.ProfileID, map[string]string{"email": change.OldEmail, "new_email": change.NewEmail})
+	return nil
+}
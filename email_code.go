@@ -0,0 +1,104 @@
+package authentication
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"math/big"
+)
+
+const (
+	emailCodeMinDigits          = 6
+	emailCodeMaxDigits          = 8
+	emailCodeDefaultMaxAttempts = 5
+)
+
+// emailCodes выдает и проверяет короткие цифровые коды вместо UUID ключей.
+// Код привязан к E-MAIL и назначению, в хранилище попадает только его хеш
+type emailCodes struct {
+	st          DriverStorage
+	secret      []byte
+	digits      int
+	maxAttempts int
+	lifeTime    int64
+}
+
+func newEmailCodes(cfg AuthConfig) *emailCodes {
+	c := &emailCodes{
+		st:          cfg.DriverStorage,
+		secret:      cfg.TokenSecretKey,
+		digits:      cfg.EmailCodeDigits,
+		maxAttempts: cfg.EmailCodeMaxAttempts,
+		lifeTime:    cfg.EmailLifeTimeSecond,
+	}
+	if c.digits > 0 && c.digits < emailCodeMinDigits {
+		c.digits = emailCodeMinDigits
+	}
+	if c.digits > emailCodeMaxDigits {
+		c.digits = emailCodeMaxDigits
+	}
+	if c.maxAttempts <= 0 {
+		c.maxAttempts = emailCodeDefaultMaxAttempts
+	}
+	return c
+}
+
+func (c *emailCodes) enabled() bool {
+	return c.digits > 0
+}
+
+// key одна запись на пару назначение + E-MAIL, новый код заменяет предыдущий.
+// E-MAIL приводится к канонической форме, иначе код не находится при другом регистре
+func (c *emailCodes) key(purpose EmailKeyPurpose, email string) EmailSecretKey {
+	return EmailSecretKey(signature(c.secret, []byte("email_code"), []byte(purpose), []byte(CanonicalEmail(email))))
+}
+
+func (c *emailCodes) hash(key EmailSecretKey, code string) string {
+	return signature(c.secret, []byte(key), []byte(code))
+}
+
+func (c *emailCodes) issue(purpose EmailKeyPurpose, email string) (EmailSecretKey, error) {
+	n, err := rand.Int(rand.Reader, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(c.digits)), nil))
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%0*d", c.digits, n)
+
+	key := c.key(purpose, email)
+	err = c.st.EmailNewSecretCode(key, purpose, email, c.hash(key, code), c.lifeTime)
+	return EmailSecretKey(code), err
+}
+
+// check сжигает код после maxAttempts неверных попыток. Попытка засчитывается до сравнения,
+// иначе параллельные запросы проходят проверку счетчика одновременно
+func (c *emailCodes) check(purpose EmailKeyPurpose, email, code string) error {
+	key := c.key(purpose, email)
+	res, err := c.st.EmailReadSecretCode(key)
+	if err != nil {
+		return err
+	}
+
+	attempts, err := c.st.EmailIncSecretCodeAttempts(key)
+	if err != nil {
+		return err
+	}
+	if attempts > c.maxAttempts {
+		if err := c.st.EmailDeleteSecretKey(key); err != nil {
+			return err
+		}
+		return ErrEmailCodeAttemptsExceeded
+	}
+
+	if subtle.ConstantTimeCompare([]byte(c.hash(key, code)), []byte(res.CodeHash)) != 1 {
+		if attempts >= c.maxAttempts {
+			if err := c.st.EmailDeleteSecretKey(key); err != nil {
+				return err
+			}
+			return ErrEmailCodeAttemptsExceeded
+		}
+		return ErrEmailCodeInvalid
+	}
+
+	_, err = c.st.EmailConsumeSecretKey(key, purpose)
+	return err
+}
@@ -16,4 +16,74 @@ var (
 	ErrLoginNotFound          = errors.New("login not found")
 	ErrEmailNotFound          = errors.New("login not found")
 	ErrProfileIdNotFound      = errors.New("profile id not found")
+
+	ErrEmailNotVerified     = errors.New("email is not verified")
+	ErrEmailAlreadyVerified = errors.New("email is already verified")
+
+	ErrMailTemplateNotFound = errors.New("mail template not found")
+	ErrMailNotDelivered     = errors.New("mail was not delivered")
+
+	ErrEmailCodeInvalid          = errors.New("email code is invalid")
+	ErrEmailCodeAttemptsExceeded = errors.New("email code attempts exceeded")
+
+	ErrEmailChangeNotFound = errors.New("email change not found")
+	ErrEmailChangeConflict = errors.New("email change was modified concurrently")
+
+	ErrMFARequired           = errors.New("second factor is required")
+	ErrMFAChallengeNotFound  = errors.New("mfa challenge not found")
+	ErrMFACodeInvalid        = errors.New("mfa code is invalid")
+	ErrMFAAttemptsExceeded   = errors.New("mfa attempts exceeded")
+	ErrMFAMethodNotAvailable = errors.New("mfa method is not available")
+	ErrMFACodeExpired        = errors.New("mfa code is expired or was not sent")
+	ErrMFAResendThrottled    = errors.New("mfa code was sent recently")
+	ErrTOTPNotFound          = errors.New("totp not found")
+	ErrTOTPAlreadyEnrolled   = errors.New("totp is already enrolled")
+	ErrSecretDecrypt         = errors.New("secret decryption failed")
+
+	ErrWebAuthnDisabled           = errors.New("webauthn is not configured")
+	ErrWebAuthnSessionNotFound    = errors.New("webauthn session not found")
+	ErrWebAuthnCredentialNotFound = errors.New("webauthn credential not found")
+	ErrWebAuthnCredentialExists   = errors.New("webauthn credential already registered")
+	ErrWebAuthnInvalid            = errors.New("webauthn response is invalid")
+	ErrWebAuthnInvalidSignature   = errors.New("webauthn signature is invalid")
+	ErrWebAuthnUnsupportedKey     = errors.New("webauthn key algorithm is not supported")
+	ErrWebAuthnSignCount          = errors.New("webauthn sign counter did not increase")
+
+	ErrReauthenticationRequired = errors.New("reauthentication is required")
+
+	ErrLoginThrottled = errors.New("too many failed login attempts, retry later")
+	ErrAccountLocked  = errors.New("account is temporarily locked")
+
+	ErrAuditChainBroken = errors.New("audit chain is broken")
+
+	ErrDeviceConfirmationRequired = errors.New("sign-in from a new device must be confirmed")
+	ErrKnownDeviceNotFound        = errors.New("known device not found")
+
+	ErrChallengeRequired = errors.New("human verification is required")
+	ErrChallengeFailed   = errors.New("human verification failed")
+
+	ErrProofOfWorkRequired = errors.New("proof of work is required")
+	ErrProofOfWorkInvalid  = errors.New("proof of work is invalid")
+	ErrProofOfWorkExpired  = errors.New("proof of work challenge is expired")
+	ErrProofOfWorkUsed     = errors.New("proof of work challenge is already used")
+
+	ErrAccountDisabled      = errors.New("account is disabled")
+	ErrAccountSuspended     = errors.New("account is suspended")
+	ErrAccountBanned        = errors.New("account is banned")
+	ErrAccountStatusInvalid = errors.New("account status is invalid")
+
+	ErrAccountPendingDeletion = errors.New("account is scheduled for deletion")
+	ErrRestoreKeyNotFound     = errors.New("restore key not found or expired")
+	ErrAccountAnonymized      = errors.New("account is anonymized")
+
+	ErrAttrUnknown       = errors.New("attribute is not registered")
+	ErrAttrType          = errors.New("attribute value has wrong type")
+	ErrAttrTooLong       = errors.New("attribute value is too long")
+	ErrAttrNotIndexed    = errors.New("attribute is not indexed")
+	ErrAttrSchemaInvalid = errors.New("attribute definition is invalid")
+
+	ErrRoleNotFound     = errors.New("role not found")
+	ErrRoleInvalid      = errors.New("role name or permission is invalid")
+	ErrRoleCycle        = errors.New("role inheritance cycle")
+	ErrPermissionDenied = errors.New("permission denied")
 )
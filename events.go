@@ -0,0 +1,141 @@
+package authentication
+
+import (
+	"fmt"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventRegistered        EventType = "registered"
+	EventLoginSucceeded    EventType = "login_succeeded"
+	EventLoginFailed       EventType = "login_failed"
+	EventLoginBlocked      EventType = "login_blocked"
+	EventMFARequired       EventType = "mfa_required"
+	EventMFAFailed         EventType = "mfa_failed"
+	EventMFAEnabled        EventType = "mfa_enabled"
+	EventMFADisabled       EventType = "mfa_disabled"
+	EventReauthenticated   EventType = "reauthenticated"
+	EventTokenIssued       EventType = "token_issued"
+	EventTokenRevoked      EventType = "token_revoked"
+	EventPasswordChanged   EventType = "password_changed"
+	EventPasswordRecovered EventType = "password_recovered"
+	EventEmailVerified     EventType = "email_verified"
+	EventEmailChanged      EventType = "email_changed"
+	EventEmailChangeUndone EventType = "email_change_undone"
+	EventPasskeyAdded      EventType = "passkey_added"
+	EventPasskeyRemoved    EventType = "passkey_removed"
+	EventProfileDeleted    EventType = "profile_deleted"
+	EventNewDevice         EventType = "new_device"
+	EventChallengeFailed   EventType = "challenge_failed"
+	EventStatusChanged     EventType = "status_changed"
+
+	EventProfileDeletionScheduled EventType = "profile_deletion_scheduled"
+	EventProfileRestored          EventType = "profile_restored"
+	EventProfileExported          EventType = "profile_exported"
+	EventProfileAnonymized        EventType = "profile_anonymized"
+	EventRoleAssigned             EventType = "role_assigned"
+	EventRoleRevoked              EventType = "role_revoked"
+	EventAuditScrubbed            EventType = "audit_scrubbed"
+	EventRecoveryCodesGenerated   EventType = "recovery_codes_generated"
+)
+
+// Event ProfileID равен 0, если профиль не известен, например при неверном логине
+type Event struct {
+	Type      EventType   `json:"type"`
+	Time      time.Time   `json:"time"`
+	ProfileID ProfileID   `json:"profile_id"`
+	Meta      RequestMeta `json:"meta"`
+	//Data подробности события: login, method, email и т.п.
+	Data map[string]string `json:"data,omitempty"`
+}
+
+// EventSubscriber получает события синхронно, в горутине вызова Auth или Profile
+type EventSubscriber interface {
+	HandleEvent(event *Event) error
+}
+
+type EventSubscriberFunc func(event *Event) error
+
+func (f EventSubscriberFunc) HandleEvent(event *Event) error {
+	return f(event)
+}
+
+// events ошибка подписчика не прерывает операцию, она передается в onError
+type events struct {
+	sub     EventSubscriber
+	onError func(event *Event, err error)
+}
+
+func newEvents(cfg AuthConfig) *events {
+	return &events{sub: cfg.EventSubscriber, onError: cfg.EventErrorHandler}
+}
+
+func (e *events) enabled() bool {
+	return e.sub != nil
+}
+
+func (e *events) emit(typ EventType, profileID ProfileID, meta RequestMeta, data map[string]string) {
+	if !e.enabled() {
+		return
+	}
+	event := &Event{
+		Type:      typ,
+		Time:      time.Now(),
+		ProfileID: profileID,
+		Meta:      meta,
+		Data:      data,
+	}
+	if err := e.sub.HandleEvent(event); err != nil && e.onError != nil {
+		e.onError(event, err)
+	}
+}
+
+func (a *Auth) emit(typ EventType, profileID ProfileID, data map[string]string) {
+	a.events.emit(typ, profileID, a.meta, data)
+}
+
+func (t *Profile) emit(typ EventType, data map[string]string) {
+	t.cfg.events.emit(typ, t.ProfileID, t.meta, data)
+}
+
+// emitByEmail профиль ищется только при наличии подписчика. Если поиск не удался,
+// событие уходит с ProfileID 0, а ошибка поиска передается в onError
+func (a *Auth) emitByEmail(typ EventType, email string, data map[string]string) {
+	if !a.events.enabled() {
+		return
+	}
+	pid, err := a.st.GetProfileIDByEmail(email)
+	if err != nil && a.events.onError != nil {
+		a.events.onError(&Event{Type: typ, Time: time.Now(), Meta: a.meta, Data: data}, err)
+	}
+	a.emit(typ, pid, data)
+}
+
+// MultiSubscriber рассылает событие всем подписчикам и возвращает первую ошибку
+func MultiSubscriber(subs ...EventSubscriber) EventSubscriber {
+	return EventSubscriberFunc(func(event *Event) error {
+		var first error
+		for _, sub := range subs {
+			if err := sub.HandleEvent(event); err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	})
+}
+
+// AuditChainError первая поврежденная запись цепочки аудита
+type AuditChainError struct {
+	EventID int64
+	Reason  string
+}
+
+func (e *AuditChainError) Error() string {
+	return fmt.Sprintf("%s: event %d: %s", ErrAuditChainBroken, e.EventID, e.Reason)
+}
+
+func (e *AuditChainError) Unwrap() error {
+	return ErrAuditChainBroken
+}
@@ -0,0 +1,240 @@
+package authentication
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ExportHook возвращает раздел выгрузки приложения, он сериализуется в JSON
+type ExportHook func(profileID ProfileID) (interface{}, error)
+
+// EventReader источник событий аудита профиля для выгрузки, например drivers.GormAuditSink.
+// limit <= 0 - все события
+type EventReader interface {
+	Events(profileID ProfileID, limit int) ([]*Event, error)
+}
+
+// ProfileExport выгрузка данных профиля. Секреты (пароль, TOTP, ключи из писем,
+// идентификаторы токенов) в нее не попадают
+type ProfileExport struct {
+	ExportedAt time.Time     `json:"exported_at"`
+	Profile    ExportProfile `json:"profile"`
+	//Attributes атрибуты вне AttrSchema выгружаются строками
+	Attributes   map[string]interface{} `json:"attributes"`
+	Sessions     []ExportSession        `json:"sessions"`
+	MFA          ExportMFA              `json:"mfa"`
+	KnownDevices []ExportKnownDevice    `json:"known_devices"`
+	EmailChanges []ExportEmailChange    `json:"email_changes"`
+	Events       []*Event               `json:"events,omitempty"`
+	//Sections разделы ExportHooks по их именам
+	Sections map[string]interface{} `json:"sections,omitempty"`
+}
+
+type ExportProfile struct {
+	ID            ProfileID     `json:"id"`
+	Login         string        `json:"login"`
+	Email         string        `json:"email"`
+	EmailVerified bool          `json:"email_verified"`
+	Status        AccountStatus `json:"status"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+}
+
+type ExportSession struct {
+	ExpiresAt       time.Time `json:"expires_at"`
+	AuthenticatedAt time.Time `json:"authenticated_at"`
+}
+
+type ExportMFA struct {
+	TOTP               bool            `json:"totp"`
+	EmailCode          bool            `json:"email_code"`
+	RecoveryCodesCount int             `json:"recovery_codes_count"`
+	Passkeys           []ExportPasskey `json:"passkeys"`
+}
+
+type ExportPasskey struct {
+	Name       string    `json:"name"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+type ExportKnownDevice struct {
+	IP         string    `json:"ip"`
+	IPRange    string    `json:"ip_range"`
+	UserAgent  string    `json:"user_agent"`
+	Confirmed  bool      `json:"confirmed"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+type ExportEmailChange struct {
+	OldEmail  string           `json:"old_email"`
+	NewEmail  string           `json:"new_email"`
+	State     EmailChangeState `json:"state"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// Export выгружает данные профиля в JSON
+func (t *Profile) Export() ([]byte, error) {
+	data, err := t.ExportData()
+	if err != nil {
+		return nil, err
+	}
+	bs, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	t.emit(EventProfileExported, nil)
+	return bs, nil
+}
+
+// ExportData собирает выгрузку без сериализации
+func (t *Profile) ExportData() (*ProfileExport, error) {
+	st := t.cfg.st
+	rec, err := st.GetProfileRecord(t.ProfileID)
+	if err != nil {
+		return nil, err
+	}
+	status, err := st.GetProfileStatus(t.ProfileID)
+	if err != nil {
+		return nil, err
+	}
+
+	exp := &ProfileExport{
+		ExportedAt: time.Now().UTC(),
+		Profile: ExportProfile{
+			ID:            t.ProfileID,
+			Login:         rec.Login,
+			Email:         rec.Email,
+			EmailVerified: rec.EmailVerified,
+			Status:        status.Status,
+			CreatedAt:     rec.CreatedAt,
+			UpdatedAt:     rec.UpdatedAt,
+		},
+		Sessions:     []ExportSession{},
+		KnownDevices: []ExportKnownDevice{},
+		EmailChanges: []ExportEmailChange{},
+	}
+
+	attrs, err := st.GetAttrs(t.ProfileID)
+	if err != nil {
+		return nil, err
+	}
+	exp.Attributes = make(map[string]interface{}, len(attrs))
+	for name, raw := range attrs {
+		exp.Attributes[name] = raw
+		if def, ok := t.cfg.attrs.Lookup(name); ok {
+			if value, err := def.decode(raw); err == nil {
+				exp.Attributes[name] = value
+			}
+		}
+	}
+
+	tokens, err := st.ListTokens(t.ProfileID)
+	if err != nil {
+		return nil, err
+	}
+	for _, tok := range tokens {
+		exp.Sessions = append(exp.Sessions, ExportSession{
+			ExpiresAt:       time.Unix(tok.Expiries, 0).UTC(),
+			AuthenticatedAt: time.Unix(tok.AuthAt, 0).UTC(),
+		})
+	}
+
+	if exp.MFA, err = t.exportMFA(); err != nil {
+		return nil, err
+	}
+
+	devices, err := st.ListKnownDevices(t.ProfileID)
+	if err != nil {
+		return nil, err
+	}
+	for _, dev := range devices {
+		exp.KnownDevices = append(exp.KnownDevices, ExportKnownDevice{
+			IP:         dev.IP,
+			IPRange:    dev.IPRange,
+			UserAgent:  dev.UserAgent,
+			Confirmed:  dev.Confirmed,
+			CreatedAt:  dev.CreatedAt,
+			LastSeenAt: dev.LastSeenAt,
+		})
+	}
+
+	changes, err := st.ListEmailChanges(t.ProfileID)
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		exp.EmailChanges = append(exp.EmailChanges, ExportEmailChange{
+			OldEmail:  change.OldEmail,
+			NewEmail:  change.NewEmail,
+			State:     change.State,
+			CreatedAt: change.CreatedAt,
+		})
+	}
+
+	if t.cfg.auditEvents != nil {
+		events, err := t.cfg.auditEvents.Events(t.ProfileID, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range events {
+			exp.Events = append(exp.Events, exportEvent(event))
+		}
+	}
+
+	for name, hook := range t.cfg.exportHooks {
+		section, err := hook(t.ProfileID)
+		if err != nil {
+			return nil, err
+		}
+		if exp.Sections == nil {
+			exp.Sections = make(map[string]interface{})
+		}
+		exp.Sections[name] = section
+	}
+	return exp, nil
+}
+
+// exportEvent копия события без идентификатора токена, он есть в данных token_issued и token_revoked
+func exportEvent(event *Event) *Event {
+	if _, ok := event.Data["token"]; !ok {
+		return event
+	}
+	res := *event
+	res.Data = make(map[string]string, len(event.Data))
+	for k, v := range event.Data {
+		if k != "token" {
+			res.Data[k] = v
+		}
+	}
+	return &res
+}
+
+func (t *Profile) exportMFA() (ExportMFA, error) {
+	st := t.cfg.st
+	res := ExportMFA{Passkeys: []ExportPasskey{}}
+
+	totp, err := st.GetTOTP(t.ProfileID)
+	if err != nil && !errors.Is(err, ErrTOTPNotFound) {
+		return res, err
+	}
+	res.TOTP = err == nil && totp.Confirmed
+
+	if res.EmailCode, err = st.IsMFAEmailEnabled(t.ProfileID); err != nil {
+		return res, err
+	}
+	if res.RecoveryCodesCount, err = st.CountRecoveryCodes(t.ProfileID); err != nil {
+		return res, err
+	}
+
+	creds, err := st.ListWebAuthnCredentials(t.ProfileID)
+	if err != nil {
+		return res, err
+	}
+	for _, cred := range creds {
+		res.Passkeys = append(res.Passkeys, ExportPasskey{Name: cred.Name, CreatedAt: cred.CreatedAt, LastUsedAt: cred.LastUsedAt})
+	}
+	return res, nil
+}
@@ -8,6 +8,7 @@ require (
 	github.com/coocood/freecache v1.2.3
 	github.com/v-grabko1999/cache v0.0.0-20230825163101-ac8af95d4026
 	golang.org/x/sync v0.3.0
+	golang.org/x/text v0.12.0
 	gorm.io/driver/mysql v1.5.1
 	gorm.io/driver/postgres v1.5.2
 	gorm.io/driver/sqlite v1.5.3
@@ -23,7 +24,5 @@ require (
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
 	github.com/mattn/go-sqlite3 v1.14.17 // indirect
-	github.com/v-grabko1999/cache v0.0.0-20230825163101-ac8af95d4026 // indirect
 	golang.org/x/crypto v0.12.0 // indirect
-	golang.org/x/text v0.12.0 // indirect
 )
@@ -1,6 +1,9 @@
 package authentication
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
@@ -83,3 +86,47 @@ func (p *passwordHasher) Hash(login, password string) string {
 	defer p.s.RUnlock()
 	return signature(p.bs, []byte(login), p.bs, []byte(password))
 }
+
+// secretBox шифрует секреты, которые хранятся в базе данных (AES-256-GCM)
+type secretBox struct {
+	aead cipher.AEAD
+}
+
+func newSecretBox(key []byte, purpose string) *secretBox {
+	h := sha256.New()
+	h.Write([]byte(purpose))
+	h.Write(key)
+
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return &secretBox{aead: aead}
+}
+
+func (b *secretBox) Seal(plain []byte) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b.aead.Seal(nonce, nonce, plain, nil)), nil
+}
+
+func (b *secretBox) Open(sealed string) ([]byte, error) {
+	bs, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if len(bs) < b.aead.NonceSize() {
+		return nil, ErrSecretDecrypt
+	}
+	plain, err := b.aead.Open(nil, bs[:b.aead.NonceSize()], bs[b.aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrSecretDecrypt
+	}
+	return plain, nil
+}
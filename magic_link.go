@@ -0,0 +1,77 @@
+package authentication
+
+import (
+	"github.com/google/uuid"
+)
+
+const defaultMagicLinkLifeTime = 15 * 60
+
+// RequestMagicLink выдает одноразовый ключ входа без пароля.
+// Без MagicLinkAutoRegistration для неизвестного E-MAIL возвращает ErrEmailNotFound
+func (a *Auth) RequestMagicLink(email string) (EmailSecretKey, error) {
+	if !a.magicLinkAutoRegistration {
+		if _, err := a.st.GetProfileIDByEmail(email); err != nil {
+			return "", err
+		}
+	}
+
+	secret := EmailSecretKey(uuid.New().String())
+	err := a.st.EmailNewSecretKey(secret, EmailKeyMagicLink, email, a.magicLinkLifeTime)
+	if err != nil {
+		return "", err
+	}
+	return secret, a.mail.sendKey(MailMagicLink, email, secret)
+}
+
+// RedeemMagicLink гасит ключ и выдает токен. Переход по ссылке подтверждает E-MAIL.
+// Вход проходит те же проверки, что и Authentication: при включенном втором факторе
+// возвращается MFARequiredError, токен после CompleteMFA выдает вызывающая сторона.
+// Профиль, созданный автоматически, не имеет пароля, задать его можно через ForgotPassword
+func (a *Auth) RedeemMagicLink(key EmailSecretKey, tokenLifeTimeSecond TokenLifeTime) (*Profile, string, error) {
+	email, err := a.st.EmailConsumeSecretKey(key, EmailKeyMagicLink)
+	if err != nil {
+		return nil, "", err
+	}
+
+	pid, err := a.st.GetProfileIDByEmail(email)
+	if err == ErrEmailNotFound && a.magicLinkAutoRegistration {
+		pid, err = a.magicLinkRegistration(email)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := a.checkStatus(pid); err != nil {
+		return nil, "", err
+	}
+	if err := a.st.SetEmailVerified(pid, true); err != nil {
+		return nil, "", err
+	}
+
+	prof, err := a.completeLogin(pid, "magic_link")
+	if err != nil {
+		return nil, "", err
+	}
+	tok, err := a.NewToken(prof, tokenLifeTimeSecond)
+	if err != nil {
+		return nil, "", err
+	}
+	return prof, tok, nil
+}
+
+// magicLinkRegistration пустой пароль не совпадает ни с одним хешем, вход по паролю невозможен
+func (a *Auth) magicLinkRegistration(email string) (ProfileID, error) {
+	unique, err := a.st.IsUniqueLogin(email)
+	if err != nil {
+		return 0, err
+	}
+	if !unique {
+		return 0, ErrLoginNotUnique
+	}
+	pid, err := a.st.NewProfile(email, email, "")
+	if err != nil {
+		return 0, err
+	}
+	a.emit(EventRegistered, pid, map[string]string{"login": email, "email": email, "method": "magic_link"})
+	return pid, nil
+}
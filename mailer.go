@@ -0,0 +1,246 @@
+package authentication
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"net/url"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+type MailKind string
+
+const (
+	MailRecovery       MailKind = "recovery"
+	MailChangeEmail    MailKind = "change_email"
+	MailVerification   MailKind = "verification"
+	MailSecurityNotice MailKind = "security_notice"
+
+	MailChangeEmailNew  MailKind = "change_email_new"
+	MailChangeEmailUndo MailKind = "change_email_undo"
+	MailMagicLink       MailKind = "magic_link"
+	MailMFACode         MailKind = "mfa_code"
+	MailNewSignIn       MailKind = "new_sign_in"
+	MailConfirmDevice   MailKind = "confirm_device"
+	MailRestoreProfile  MailKind = "restore_profile"
+)
+
+// события для MailSecurityNotice
+const (
+	NoticePasswordChanged   = "password_changed"
+	NoticePasswordRecovered = "password_recovered"
+	NoticeEmailChanged      = "email_changed"
+)
+
+// MailDeliveryError письмо не отправлено. Изменения, сделанные до отправки, уже сохранены:
+// операцию повторять не нужно, достаточно повторить письмо (например ResendVerification).
+// errors.Is(err, ErrMailNotDelivered) отличает его от ошибок самой операции
+type MailDeliveryError struct {
+	Kind MailKind
+	To   string
+	Err  error
+}
+
+func (e *MailDeliveryError) Error() string {
+	return ErrMailNotDelivered.Error() + ": " + string(e.Kind) + ": " + e.Err.Error()
+}
+
+func (e *MailDeliveryError) Unwrap() error {
+	return e.Err
+}
+
+func (e *MailDeliveryError) Is(target error) bool {
+	return target == ErrMailNotDelivered
+}
+
+type MailMessage struct {
+	Kind    MailKind
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer доставляет письма, сформированные модулем
+type Mailer interface {
+	SendMail(msg *MailMessage) error
+}
+
+// LinkBuilder строит ссылку, которую пользователь получит в письме
+type LinkBuilder func(kind MailKind, key EmailSecretKey) string
+
+// URLLinkBuilder строит ссылки вида baseURL/<kind>?key=<key>
+func URLLinkBuilder(baseURL string) LinkBuilder {
+	baseURL = strings.TrimRight(baseURL, "/")
+	return func(kind MailKind, key EmailSecretKey) string {
+		return baseURL + "/" + string(kind) + "?key=" + url.QueryEscape(string(key))
+	}
+}
+
+// MailData передается в шаблоны писем
+type MailData struct {
+	Email    string
+	NewEmail string
+	Key      EmailSecretKey
+	Link     string
+	Event    string
+	Time     time.Time
+	//IP и UserAgent клиента для писем о входе с нового устройства
+	IP        string
+	UserAgent string
+}
+
+type MailTemplate struct {
+	Subject *texttemplate.Template
+	Text    *texttemplate.Template
+	HTML    *htmltemplate.Template
+}
+
+func NewMailTemplate(subject, text, html string) (*MailTemplate, error) {
+	var err error
+	tpl := new(MailTemplate)
+	if tpl.Subject, err = texttemplate.New("subject").Parse(subject); err != nil {
+		return nil, err
+	}
+	if tpl.Text, err = texttemplate.New("text").Parse(text); err != nil {
+		return nil, err
+	}
+	if html != "" {
+		if tpl.HTML, err = htmltemplate.New("html").Parse(html); err != nil {
+			return nil, err
+		}
+	}
+	return tpl, nil
+}
+
+func MustMailTemplate(subject, text, html string) *MailTemplate {
+	tpl, err := NewMailTemplate(subject, text, html)
+	if err != nil {
+		panic(err)
+	}
+	return tpl
+}
+
+func (tpl *MailTemplate) render(data *MailData) (subject, text, html string, err error) {
+	buf := new(bytes.Buffer)
+	if err = tpl.Subject.Execute(buf, data); err != nil {
+		return
+	}
+	subject = buf.String()
+
+	buf.Reset()
+	if err = tpl.Text.Execute(buf, data); err != nil {
+		return
+	}
+	text = buf.String()
+
+	if tpl.HTML != nil {
+		buf.Reset()
+		if err = tpl.HTML.Execute(buf, data); err != nil {
+			return
+		}
+		html = buf.String()
+	}
+	return
+}
+
+// MailTemplates шаблоны писем по локали и типу письма
+type MailTemplates map[string]map[MailKind]*MailTemplate
+
+func (m MailTemplates) lookup(locale, defaultLocale string, kind MailKind) (*MailTemplate, error) {
+	if tpl, ok := m[locale][kind]; ok {
+		return tpl, nil
+	}
+	if tpl, ok := m[defaultLocale][kind]; ok {
+		return tpl, nil
+	}
+	return nil, ErrMailTemplateNotFound
+}
+
+type mailSender struct {
+	mailer        Mailer
+	links         LinkBuilder
+	templates     MailTemplates
+	defaultLocale string
+	locale        func(email string) string
+}
+
+func newMailSender(cfg AuthConfig) *mailSender {
+	m := &mailSender{
+		mailer:        cfg.Mailer,
+		links:         cfg.MailLinks,
+		templates:     cfg.MailTemplates,
+		defaultLocale: cfg.MailDefaultLocale,
+		locale:        cfg.MailLocale,
+	}
+	if m.templates == nil {
+		m.templates = DefaultMailTemplates()
+	}
+	if m.defaultLocale == "" {
+		m.defaultLocale = "en"
+	}
+	return m
+}
+
+func (m *mailSender) enabled() bool {
+	return m.mailer != nil
+}
+
+// send ничего не делает, если Mailer не задан. Любая ошибка отправки возвращается как MailDeliveryError
+func (m *mailSender) send(kind MailKind, to string, data *MailData) error {
+	if !m.enabled() {
+		return nil
+	}
+	if err := m.deliver(kind, to, data); err != nil {
+		return &MailDeliveryError{Kind: kind, To: to, Err: err}
+	}
+	return nil
+}
+
+func (m *mailSender) deliver(kind MailKind, to string, data *MailData) error {
+	locale := m.defaultLocale
+	if m.locale != nil {
+		if l := m.locale(to); l != "" {
+			locale = l
+		}
+	}
+
+	tpl, err := m.templates.lookup(locale, m.defaultLocale, kind)
+	if err != nil {
+		return err
+	}
+
+	data.Email = to
+	//Time может задать отправитель, например дату окончательного удаления
+	if data.Time.IsZero() {
+		data.Time = time.Now()
+	}
+
+	msg := &MailMessage{Kind: kind, To: to}
+	msg.Subject, msg.Text, msg.HTML, err = tpl.render(data)
+	if err != nil {
+		return err
+	}
+	return m.mailer.SendMail(msg)
+}
+
+func (m *mailSender) link(kind MailKind, key EmailSecretKey) string {
+	if m.links == nil {
+		return ""
+	}
+	return m.links(kind, key)
+}
+
+func (m *mailSender) sendKey(kind MailKind, to string, key EmailSecretKey) error {
+	return m.send(kind, to, &MailData{Key: key, Link: m.link(kind, key)})
+}
+
+// sendCode не строит ссылку: код без E-MAIL бесполезен
+func (m *mailSender) sendCode(kind MailKind, to string, code EmailSecretKey) error {
+	return m.send(kind, to, &MailData{Key: code})
+}
+
+func (m *mailSender) notice(to string, event string, newEmail string) error {
+	return m.send(MailSecurityNotice, to, &MailData{Event: event, NewEmail: newEmail})
+}
@@ -0,0 +1,139 @@
+package authentication
+
+import (
+	"fmt"
+	"html"
+)
+
+const mailHTMLLayout = `<!DOCTYPE html>
+<html><body style="font-family:sans-serif">
+<p>%s</p>
+{{if .Link}}<p><a href="{{.Link}}">%s</a></p>{{end}}
+{{if .Key}}<p><b>{{.Key}}</b></p>{{end}}
+<p style="color:#888">%s</p>
+</body></html>`
+
+// DefaultMailTemplates возвращает встроенные шаблоны писем для локалей en и ru
+func DefaultMailTemplates() MailTemplates {
+	return MailTemplates{
+		"en": {
+			MailRecovery: MustMailTemplate(
+				"Password recovery",
+				"You requested a password reset.\n{{if .Link}}Open the link to set a new password: {{.Link}}\n{{end}}Recovery key: {{.Key}}\n\nIf you did not request this, ignore this email.\n",
+				htmlBody("You requested a password reset.", "Set a new password", "If you did not request this, ignore this email."),
+			),
+			MailChangeEmail: MustMailTemplate(
+				"Email change confirmation",
+				"You requested to change the email of your account.\n{{if .Link}}Open the link to continue: {{.Link}}\n{{end}}Confirmation key: {{.Key}}\n\nIf you did not request this, change your password.\n",
+				htmlBody("You requested to change the email of your account.", "Change email", "If you did not request this, change your password."),
+			),
+			MailVerification: MustMailTemplate(
+				"Confirm your email",
+				"Please confirm your email address.\n{{if .Link}}Open the link to confirm: {{.Link}}\n{{end}}Confirmation key: {{.Key}}\n",
+				htmlBody("Please confirm your email address.", "Confirm email", "If you did not create an account, ignore this email."),
+			),
+			MailChangeEmailNew: MustMailTemplate(
+				"Confirm your new email",
+				"This address was set as the new email of an account.\n{{if .Link}}Open the link to confirm: {{.Link}}\n{{end}}Confirmation key: {{.Key}}\n\nIf you did not request this, ignore this email.\n",
+				htmlBody("This address was set as the new email of an account.", "Confirm new email", "If you did not request this, ignore this email."),
+			),
+			MailChangeEmailUndo: MustMailTemplate(
+				"Your email was changed",
+				"The email of your account was changed to {{.NewEmail}}.\nIf it was not you, undo the change{{if .Link}}: {{.Link}}{{end}}\nUndo key: {{.Key}}\n\nAll sessions will be signed out.\n",
+				htmlBody("The email of your account was changed. If it was not you, undo the change.", "This wasn't me", "All sessions will be signed out."),
+			),
+			MailMagicLink: MustMailTemplate(
+				"Your sign-in link",
+				"Use this one-time link to sign in{{if .Link}}: {{.Link}}{{end}}\nSign-in key: {{.Key}}\n\nIf you did not request this, ignore this email.\n",
+				htmlBody("Use this one-time link to sign in.", "Sign in", "If you did not request this, ignore this email."),
+			),
+			MailMFACode: MustMailTemplate(
+				"Your sign-in code",
+				"Your sign-in code: {{.Key}}\n\nIf you did not try to sign in, change your password.\n",
+				htmlBody("Your sign-in code:", "", "If you did not try to sign in, change your password."),
+			),
+			MailNewSignIn: MustMailTemplate(
+				"New sign-in to your account",
+				"Your account was signed in from a new device.\nIP: {{.IP}}\nDevice: {{.UserAgent}}\n\nIf it was not you, sign out this session{{if .Link}}: {{.Link}}{{end}}\nRevoke key: {{.Key}}\nThen change your password.\n",
+				htmlBody("Your account was signed in from a new device. If it was not you, sign out this session and change your password.", "This wasn't me", "The session will be signed out."),
+			),
+			MailConfirmDevice: MustMailTemplate(
+				"Confirm sign-in from a new device",
+				"Someone is signing in to your account from a new device.\nIP: {{.IP}}\nDevice: {{.UserAgent}}\n\nIf it is you, confirm the sign-in{{if .Link}}: {{.Link}}{{end}}\nConfirmation key: {{.Key}}\nIf it is not you, change your password.\n",
+				htmlBody("Someone is signing in to your account from a new device.", "Confirm sign-in", "If it is not you, change your password."),
+			),
+			MailRestoreProfile: MustMailTemplate(
+				"Your account was deleted",
+				"Your account was deleted and will be removed permanently on {{.Time.Format \"2006-01-02 15:04 MST\"}}.\nTo restore it before then{{if .Link}}, open the link: {{.Link}}{{end}}\nRestore key: {{.Key}}\n",
+				htmlBody("Your account was deleted and will be removed permanently after the grace period.", "Restore account", "If you deleted it on purpose, ignore this email."),
+			),
+			MailSecurityNotice: MustMailTemplate(
+				"Security notification",
+				"{{if eq .Event \"password_changed\"}}The password of your account was changed.{{else if eq .Event \"password_recovered\"}}The password of your account was reset.{{else if eq .Event \"email_changed\"}}The email of your account was changed to {{.NewEmail}}.{{else}}Security event: {{.Event}}.{{end}}\nTime: {{.Time.Format \"2006-01-02 15:04:05 MST\"}}\n\nIf it was not you, contact support immediately.\n",
+				"",
+			),
+		},
+		"ru": {
+			MailRecovery: MustMailTemplate(
+				"Восстановление пароля",
+				"Вы запросили сброс пароля.\n{{if .Link}}Перейдите по ссылке, чтобы задать новый пароль: {{.Link}}\n{{end}}Ключ восстановления: {{.Key}}\n\nЕсли вы этого не делали, проигнорируйте письмо.\n",
+				htmlBody("Вы запросили сброс пароля.", "Задать новый пароль", "Если вы этого не делали, проигнорируйте письмо."),
+			),
+			MailChangeEmail: MustMailTemplate(
+				"Подтверждение смены E-MAIL",
+				"Вы запросили смену E-MAIL вашей учетной записи.\n{{if .Link}}Перейдите по ссылке, чтобы продолжить: {{.Link}}\n{{end}}Ключ подтверждения: {{.Key}}\n\nЕсли вы этого не делали, смените пароль.\n",
+				htmlBody("Вы запросили смену E-MAIL вашей учетной записи.", "Сменить E-MAIL", "Если вы этого не делали, смените пароль."),
+			),
+			MailVerification: MustMailTemplate(
+				"Подтвердите E-MAIL",
+				"Пожалуйста, подтвердите ваш E-MAIL.\n{{if .Link}}Перейдите по ссылке для подтверждения: {{.Link}}\n{{end}}Ключ подтверждения: {{.Key}}\n",
+				htmlBody("Пожалуйста, подтвердите ваш E-MAIL.", "Подтвердить E-MAIL", "Если вы не регистрировались, проигнорируйте письмо."),
+			),
+			MailChangeEmailNew: MustMailTemplate(
+				"Подтвердите новый E-MAIL",
+				"Этот адрес указан как новый E-MAIL учетной записи.\n{{if .Link}}Перейдите по ссылке для подтверждения: {{.Link}}\n{{end}}Ключ подтверждения: {{.Key}}\n\nЕсли вы этого не делали, проигнорируйте письмо.\n",
+				htmlBody("Этот адрес указан как новый E-MAIL учетной записи.", "Подтвердить новый E-MAIL", "Если вы этого не делали, проигнорируйте письмо."),
+			),
+			MailChangeEmailUndo: MustMailTemplate(
+				"Ваш E-MAIL изменен",
+				"E-MAIL вашей учетной записи изменен на {{.NewEmail}}.\nЕсли это были не вы, отмените смену{{if .Link}}: {{.Link}}{{end}}\nКлюч отмены: {{.Key}}\n\nВсе сеансы будут завершены.\n",
+				htmlBody("E-MAIL вашей учетной записи изменен. Если это были не вы, отмените смену.", "Это был не я", "Все сеансы будут завершены."),
+			),
+			MailMagicLink: MustMailTemplate(
+				"Ссылка для входа",
+				"Используйте одноразовую ссылку для входа{{if .Link}}: {{.Link}}{{end}}\nКлюч входа: {{.Key}}\n\nЕсли вы этого не делали, проигнорируйте письмо.\n",
+				htmlBody("Используйте одноразовую ссылку для входа.", "Войти", "Если вы этого не делали, проигнорируйте письмо."),
+			),
+			MailMFACode: MustMailTemplate(
+				"Код для входа",
+				"Ваш код для входа: {{.Key}}\n\nЕсли вы не пытались войти, смените пароль.\n",
+				htmlBody("Ваш код для входа:", "", "Если вы не пытались войти, смените пароль."),
+			),
+			MailNewSignIn: MustMailTemplate(
+				"Новый вход в учетную запись",
+				"В вашу учетную запись выполнен вход с нового устройства.\nIP: {{.IP}}\nУстройство: {{.UserAgent}}\n\nЕсли это были не вы, завершите этот сеанс{{if .Link}}: {{.Link}}{{end}}\nКлюч отзыва: {{.Key}}\nЗатем смените пароль.\n",
+				htmlBody("В вашу учетную запись выполнен вход с нового устройства. Если это были не вы, завершите сеанс и смените пароль.", "Это был не я", "Сеанс будет завершен."),
+			),
+			MailConfirmDevice: MustMailTemplate(
+				"Подтвердите вход с нового устройства",
+				"Кто-то входит в вашу учетную запись с нового устройства.\nIP: {{.IP}}\nУстройство: {{.UserAgent}}\n\nЕсли это вы, подтвердите вход{{if .Link}}: {{.Link}}{{end}}\nКлюч подтверждения: {{.Key}}\nЕсли это не вы, смените пароль.\n",
+				htmlBody("Кто-то входит в вашу учетную запись с нового устройства.", "Подтвердить вход", "Если это не вы, смените пароль."),
+			),
+			MailRestoreProfile: MustMailTemplate(
+				"Ваша учетная запись удалена",
+				"Ваша учетная запись удалена и будет стерта окончательно {{.Time.Format \"2006-01-02 15:04 MST\"}}.\nЧтобы восстановить ее до этого срока{{if .Link}}, откройте ссылку: {{.Link}}{{end}}\nКлюч восстановления: {{.Key}}\n",
+				htmlBody("Ваша учетная запись удалена и будет стерта окончательно по истечении срока восстановления.", "Восстановить учетную запись", "Если вы удалили ее намеренно, проигнорируйте это письмо."),
+			),
+			MailSecurityNotice: MustMailTemplate(
+				"Уведомление безопасности",
+				"{{if eq .Event \"password_changed\"}}Пароль вашей учетной записи был изменен.{{else if eq .Event \"password_recovered\"}}Пароль вашей учетной записи был сброшен.{{else if eq .Event \"email_changed\"}}E-MAIL вашей учетной записи изменен на {{.NewEmail}}.{{else}}Событие безопасности: {{.Event}}.{{end}}\nВремя: {{.Time.Format \"2006-01-02 15:04:05 MST\"}}\n\nЕсли это были не вы, немедленно обратитесь в поддержку.\n",
+				"",
+			),
+		},
+	}
+}
+
+// htmlBody подставляет статические тексты в общий html макет
+func htmlBody(intro, action, outro string) string {
+	return fmt.Sprintf(mailHTMLLayout, html.EscapeString(intro), html.EscapeString(action), html.EscapeString(outro))
+}
@@ -0,0 +1,61 @@
+package mailers
+
+import (
+	"sync"
+
+	"github.com/v-grabko1999/authentication"
+)
+
+// NewMemory возвращает Mailer, который сохраняет письма в памяти. Предназначен для тестов
+func NewMemory() *MemoryMailer {
+	return new(MemoryMailer)
+}
+
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []authentication.MailMessage
+	err      error
+}
+
+func (m *MemoryMailer) SendMail(msg *authentication.MailMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.messages = append(m.messages, *msg)
+	return nil
+}
+
+// Fail заставляет SendMail возвращать err вместо отправки, nil отменяет сбой
+func (m *MemoryMailer) Fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+}
+
+func (m *MemoryMailer) Messages() []authentication.MailMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make([]authentication.MailMessage, len(m.messages))
+	copy(res, m.messages)
+	return res
+}
+
+// Last возвращает последнее письмо, отправленное на адрес to
+func (m *MemoryMailer) Last(to string) (authentication.MailMessage, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To == to {
+			return m.messages[i], true
+		}
+	}
+	return authentication.MailMessage{}, false
+}
+
+func (m *MemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = nil
+}
@@ -0,0 +1,120 @@
+package mailers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/v-grabko1999/authentication"
+)
+
+type SMTPConfig struct {
+	//Addr адрес SMTP сервера в формате host:port
+	Addr string
+	//Auth может быть nil, если сервер не требует авторизации
+	Auth smtp.Auth
+	From string
+}
+
+func NewSMTP(cfg SMTPConfig) (authentication.Mailer, error) {
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, err
+	}
+	m := new(SMTPMailer)
+	m.cfg = cfg
+	m.from = from
+	return m, nil
+}
+
+type SMTPMailer struct {
+	cfg  SMTPConfig
+	from *mail.Address
+}
+
+func (m *SMTPMailer) SendMail(msg *authentication.MailMessage) error {
+	body, err := m.build(msg)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.cfg.Addr, m.cfg.Auth, m.from.Address, []string{msg.To}, body)
+}
+
+func (m *SMTPMailer) build(msg *authentication.MailMessage) ([]byte, error) {
+	id, err := messageID()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "From: %s\r\n", m.from.String())
+	fmt.Fprintf(buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "Message-ID: <%s@%s>\r\n", id, hostOf(m.from.Address))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if msg.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQP(buf, msg.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(buf)
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	for _, part := range []struct{ ct, body string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.ct},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQP(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQP(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func messageID() (string, error) {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bs), nil
+}
+
+func hostOf(address string) string {
+	if i := strings.LastIndexByte(address, '@'); i >= 0 {
+		return address[i+1:]
+	}
+	return "localhost"
+}
@@ -0,0 +1,101 @@
+package mailers_test
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/v-grabko1999/authentication"
+	"github.com/v-grabko1999/authentication/mailers"
+)
+
+// smtpStandIn минимальный SMTP сервер, принимающий одно письмо
+func smtpStandIn(t *testing.T) (addr string, data chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("smtp listen error: ", err)
+	}
+	data = make(chan string, 1)
+
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		write := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		write("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				write("250 localhost")
+			case strings.HasPrefix(cmd, "DATA"):
+				write("354 go ahead")
+				body := new(strings.Builder)
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					body.WriteString(l)
+				}
+				data <- body.String()
+				write("250 ok")
+			case strings.HasPrefix(cmd, "QUIT"):
+				write("221 bye")
+				return
+			default:
+				write("250 ok")
+			}
+		}
+	}()
+	return ln.Addr().String(), data
+}
+
+func TestSMTPMailer(t *testing.T) {
+	addr, data := smtpStandIn(t)
+
+	m, err := mailers.NewSMTP(mailers.SMTPConfig{
+		Addr: addr,
+		From: "Auth <noreply@example.com>",
+	})
+	if err != nil {
+		t.Fatal("NewSMTP error: ", err)
+	}
+
+	err = m.SendMail(&authentication.MailMessage{
+		Kind:    authentication.MailRecovery,
+		To:      "user@example.com",
+		Subject: "Восстановление пароля",
+		Text:    "recovery key: 123",
+		HTML:    "<p>recovery key: 123</p>",
+	})
+	if err != nil {
+		t.Fatal("SendMail error: ", err)
+	}
+
+	body := <-data
+	for _, want := range []string{
+		"To: user@example.com",
+		"Subject: =?utf-8?q?",
+		"multipart/alternative",
+		"text/plain",
+		"<p>recovery key: 123</p>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("smtp message does not contain %q:\n%s", want, body)
+		}
+	}
+}
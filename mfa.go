@@ -0,0 +1,170 @@
+package authentication
+
+import (
+	"github.com/google/uuid"
+)
+
+type MFAChallengeID string
+type MFAMethod string
+
+const (
+	MFATOTP MFAMethod = "totp"
+)
+
+const (
+	defaultMFAChallengeLifeTime = 5 * 60
+	defaultMFAMaxAttempts       = 5
+)
+
+// MFARequiredError возвращают Authentication, FinishPasskeyLogin и RedeemMagicLink,
+// когда у профиля включен второй фактор.
+// Вход завершается вызовом Auth.CompleteMFA
+type MFARequiredError struct {
+	Challenge MFAChallengeID
+	Methods   []MFAMethod
+}
+
+func (e *MFARequiredError) Error() string {
+	return ErrMFARequired.Error()
+}
+
+func (e *MFARequiredError) Unwrap() error {
+	return ErrMFARequired
+}
+
+// mfaMethods второй фактор, включенный у профиля. first способ первого фактора
+func (a *Auth) mfaMethods(profileID ProfileID, first string) ([]MFAMethod, error) {
+	var methods []MFAMethod
+
+	ok, err := a.totp.enabled(profileID)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		methods = append(methods, MFATOTP)
+	}
+
+	ok, err = a.mfaEmail.enabled(profileID)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		methods = append(methods, MFAEmail)
+	}
+
+	creds, err := a.passkeysForMFA(profileID, first)
+	if err != nil {
+		return nil, err
+	}
+	if len(creds) > 0 {
+		methods = append(methods, MFAPasskey)
+	}
+
+	if len(methods) == 0 {
+		return nil, nil
+	}
+
+	//коды восстановления заменяют второй фактор, но не включают его
+	count, err := a.st.CountRecoveryCodes(profileID)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		methods = append(methods, MFARecoveryCode)
+	}
+	return methods, nil
+}
+
+// mfaChallenge возвращает nil, если второй фактор не требуется
+func (a *Auth) mfaChallenge(profileID ProfileID, method string) error {
+	methods, err := a.mfaMethods(profileID, method)
+	if err != nil {
+		return err
+	}
+	if len(methods) == 0 {
+		return nil
+	}
+
+	challenge := MFAChallengeID(uuid.New().String())
+	if err := a.st.NewMFAChallenge(challenge, profileID, method, a.mfaChallengeLifeTime); err != nil {
+		return err
+	}
+	//письмо отправляется сразу, только если нет приложения-аутентификатора,
+	//иначе код запрашивается через ResendMFACode
+	if methods[0] == MFAEmail {
+		if err := a.mfaEmail.send(challenge, profileID); err != nil {
+			return err
+		}
+	}
+	a.emit(EventMFARequired, profileID, nil)
+	return &MFARequiredError{Challenge: challenge, Methods: methods}
+}
+
+// CompleteMFA завершает вход, начатый Authentication. После MFAMaxAttempts
+// неверных кодов вызов сгорает и вход нужно начинать заново.
+// Ключ доступа проверяет CompleteMFAPasskey
+func (a *Auth) CompleteMFA(challenge MFAChallengeID, method MFAMethod, code string) (*Profile, error) {
+	profileID, err := a.completeMFA(challenge, method, 0, a.verifyMFACode(challenge, method, code))
+	if err != nil {
+		return nil, err
+	}
+	return a.loginSucceeded(profileID, string(method))
+}
+
+// verifyMFACode проверка кода второго фактора для completeMFA
+func (a *Auth) verifyMFACode(challenge MFAChallengeID, method MFAMethod, code string) func(res *ResultMFAChallenge) (bool, error) {
+	return func(res *ResultMFAChallenge) (bool, error) {
+		switch method {
+		case MFATOTP:
+			return a.totp.verify(res.ProfileID, code, true)
+		case MFAEmail:
+			return a.mfaEmail.verify(challenge, res, code)
+		case MFARecoveryCode:
+			return a.recoveryCodes.use(res.ProfileID, code)
+		}
+		return false, ErrMFAMethodNotAvailable
+	}
+}
+
+// completeMFA общий учет попыток второго фактора, verify проверяет сам фактор.
+// owner 0 - вызов входа, иначе вызов Reauthenticate этого профиля
+func (a *Auth) completeMFA(challenge MFAChallengeID, method MFAMethod, owner ProfileID, verify func(res *ResultMFAChallenge) (bool, error)) (ProfileID, error) {
+	res, err := a.st.ReadMFAChallenge(challenge)
+	if err != nil {
+		return 0, err
+	}
+	//вызов повторной аутентификации не завершает вход, и наоборот
+	if (res.Method == mfaReauthenticate) != (owner != 0) || (owner != 0 && res.ProfileID != owner) {
+		return 0, ErrMFAChallengeNotFound
+	}
+	//попытка засчитывается до проверки кода, иначе параллельные запросы
+	//прочитают один счетчик и проверят больше кодов, чем MFAMaxAttempts
+	attempts, err := a.st.IncMFAChallengeAttempts(challenge)
+	if err != nil {
+		return 0, err
+	}
+	if attempts > a.mfaMaxAttempts {
+		if err := a.st.DelMFAChallenge(challenge); err != nil {
+			return 0, err
+		}
+		return 0, ErrMFAAttemptsExceeded
+	}
+
+	ok, err := verify(res)
+	if err != nil {
+		return 0, err
+	}
+
+	if !ok {
+		a.emit(EventMFAFailed, res.ProfileID, map[string]string{"method": string(method)})
+		if attempts >= a.mfaMaxAttempts {
+			if err := a.st.DelMFAChallenge(challenge); err != nil {
+				return 0, err
+			}
+			return 0, ErrMFAAttemptsExceeded
+		}
+		return 0, ErrMFACodeInvalid
+	}
+
+	return a.st.ConsumeMFAChallenge(challenge)
+}
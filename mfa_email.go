@@ -0,0 +1,153 @@
+package authentication
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+const (
+	MFAEmail MFAMethod = "email"
+
+	mfaEmailCodeDigits             = 6
+	defaultMFAEmailCodeLifeTime    = 10 * 60
+	defaultMFAEmailResendInterval  = 60
+	defaultMFAEmailCodeMaxAttempts = 3
+)
+
+// mfaEmail второй фактор кодом из письма. Код хранится хешем в вызове MFA,
+// новый код заменяет прежний, но не чаще resendInterval
+type mfaEmail struct {
+	st             DriverStorage
+	secret         []byte
+	mail           *mailSender
+	lifeTime       int64
+	resendInterval int64
+	maxAttempts    int
+}
+
+func newMFAEmail(cfg AuthConfig, mail *mailSender) *mfaEmail {
+	m := &mfaEmail{
+		st:             cfg.DriverStorage,
+		secret:         cfg.TokenSecretKey,
+		mail:           mail,
+		lifeTime:       cfg.MFAEmailCodeLifeTimeSecond,
+		resendInterval: cfg.MFAEmailResendIntervalSecond,
+		maxAttempts:    cfg.MFAEmailCodeMaxAttempts,
+	}
+	if m.lifeTime <= 0 {
+		m.lifeTime = defaultMFAEmailCodeLifeTime
+	}
+	if m.resendInterval <= 0 {
+		m.resendInterval = defaultMFAEmailResendInterval
+	}
+	if m.maxAttempts <= 0 {
+		m.maxAttempts = defaultMFAEmailCodeMaxAttempts
+	}
+	return m
+}
+
+func (m *mfaEmail) hash(challenge MFAChallengeID, code string) string {
+	return signature(m.secret, []byte("mfa_email"), []byte(challenge), []byte(code))
+}
+
+// enabled без Mailer код доставить некуда, метод недоступен
+func (m *mfaEmail) enabled(profileID ProfileID) (bool, error) {
+	if !m.mail.enabled() {
+		return false, nil
+	}
+	return m.st.IsMFAEmailEnabled(profileID)
+}
+
+func (m *mfaEmail) send(challenge MFAChallengeID, profileID ProfileID) error {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return err
+	}
+	code := fmt.Sprintf("%0*d", mfaEmailCodeDigits, n)
+
+	ok, err := m.st.SetMFAChallengeCode(challenge, m.hash(challenge, code), m.lifeTime, m.resendInterval)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrMFAResendThrottled
+	}
+
+	email, err := m.st.GetEmail(profileID)
+	if err != nil {
+		return err
+	}
+	return m.mail.sendCode(MailMFACode, email, EmailSecretKey(code))
+}
+
+// verify после maxAttempts неверных попыток код сгорает, нужен новый
+func (m *mfaEmail) verify(challenge MFAChallengeID, res *ResultMFAChallenge, code string) (bool, error) {
+	if res.CodeHash == "" || res.CodeExpiries < time.Now().Unix() {
+		return false, ErrMFACodeExpired
+	}
+	attempts, err := m.st.IncMFAChallengeCodeAttempts(challenge)
+	if err != nil {
+		return false, err
+	}
+	if attempts > m.maxAttempts {
+		return false, ErrMFACodeExpired
+	}
+	return subtle.ConstantTimeCompare([]byte(m.hash(challenge, code)), []byte(res.CodeHash)) == 1, nil
+}
+
+// ResendMFACode отправляет новый код второго фактора на E-MAIL профиля
+func (a *Auth) ResendMFACode(challenge MFAChallengeID) error {
+	res, err := a.st.ReadMFAChallenge(challenge)
+	if err != nil {
+		return err
+	}
+	ok, err := a.mfaEmail.enabled(res.ProfileID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrMFAMethodNotAvailable
+	}
+	return a.mfaEmail.send(challenge, res.ProfileID)
+}
+
+// EnableEmailMFA требует подтвержденный E-MAIL и настроенный Mailer
+func (t *Profile) EnableEmailMFA(password string) error {
+	if err := t.checkPassword(password); err != nil {
+		return err
+	}
+	if !t.cfg.mail.enabled() {
+		return ErrMFAMethodNotAvailable
+	}
+
+	verified, err := t.IsEmailVerified()
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return ErrEmailNotVerified
+	}
+	if err := t.cfg.st.SetMFAEmail(t.ProfileID, true); err != nil {
+		return err
+	}
+	t.emit(EventMFAEnabled, map[string]string{"method": string(MFAEmail)})
+	return nil
+}
+
+func (t *Profile) DisableEmailMFA(password string) error {
+	if err := t.checkPassword(password); err != nil {
+		return err
+	}
+	if err := t.cfg.st.SetMFAEmail(t.ProfileID, false); err != nil {
+		return err
+	}
+	t.emit(EventMFADisabled, map[string]string{"method": string(MFAEmail)})
+	return nil
+}
+
+func (t *Profile) EmailMFAEnabled() (bool, error) {
+	return t.cfg.mfaEmail.enabled(t.ProfileID)
+}
@@ -0,0 +1,204 @@
+package authentication
+
+import (
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// KnownDevice устройство и диапазон IP, с которых профиль уже входил.
+// Неподтвержденная запись ждет подтверждения по ConfirmKey и не считается известной
+type KnownDevice struct {
+	ID         int64
+	ProfileID  ProfileID
+	DeviceHash string
+	IPRange    string
+	IP         string
+	UserAgent  string
+	//TokenID токен, выданный при первом входе с устройства, его отзывает RevokeKey
+	TokenID    TokenID
+	RevokeKey  EmailSecretKey
+	ConfirmKey EmailSecretKey
+	Confirmed  bool
+	//Expiries срок подтверждения входа
+	Expiries   int64
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+}
+
+// DeviceConfirmationRequiredError возвращает Authentication при входе с нового устройства,
+// если включен NewDeviceConfirmation. Вход завершает Auth.ConfirmDevice по ключу из письма
+type DeviceConfirmationRequiredError struct {
+	ProfileID ProfileID
+}
+
+func (e *DeviceConfirmationRequiredError) Error() string {
+	return ErrDeviceConfirmationRequired.Error()
+}
+
+func (e *DeviceConfirmationRequiredError) Unwrap() error {
+	return ErrDeviceConfirmationRequired
+}
+
+// ipRange адреса одной /24 для IPv4 и /48 для IPv6 считаются одной сетью
+func ipRange(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
+
+// deviceHash DeviceID клиента точнее, без него устройство узнается по User-Agent
+func (a *Auth) deviceHash(meta RequestMeta) string {
+	fingerprint := meta.DeviceID
+	if fingerprint == "" {
+		fingerprint = meta.UserAgent
+	}
+	if fingerprint == "" {
+		return ""
+	}
+	return signature(a.tokenSecretKey, []byte("device"), []byte(fingerprint))
+}
+
+// checkDevice возвращает запись нового устройства, к которой привязывается токен
+func (a *Auth) checkDevice(profileID ProfileID) (*KnownDevice, error) {
+	if !a.newDeviceDetection || (a.meta.IP == "" && a.meta.UserAgent == "" && a.meta.DeviceID == "") {
+		return nil, nil
+	}
+
+	devices, err := a.st.ListKnownDevices(profileID)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, rng := a.deviceHash(a.meta), ipRange(a.meta.IP)
+	confirmed := 0
+	for _, dev := range devices {
+		if !dev.Confirmed {
+			continue
+		}
+		confirmed++
+		if dev.DeviceHash == hash && dev.IPRange == rng {
+			return nil, a.st.SeenKnownDevice(dev.ID)
+		}
+	}
+
+	dev := &KnownDevice{
+		ProfileID:  profileID,
+		DeviceHash: hash,
+		IPRange:    rng,
+		IP:         a.meta.IP,
+		UserAgent:  a.meta.UserAgent,
+		RevokeKey:  EmailSecretKey(uuid.New().String()),
+		Confirmed:  true,
+	}
+
+	//первый вход профиля ни с чем не сравнить, устройство запоминается молча
+	if confirmed == 0 {
+		return nil, a.st.NewKnownDevice(dev)
+	}
+
+	email, err := a.st.GetEmail(profileID)
+	if err != nil {
+		return nil, err
+	}
+	data := &MailData{
+		Key:       dev.RevokeKey,
+		Link:      a.mail.link(MailNewSignIn, dev.RevokeKey),
+		IP:        dev.IP,
+		UserAgent: dev.UserAgent,
+	}
+
+	if a.newDeviceConfirmation {
+		dev.Confirmed = false
+		dev.ConfirmKey = EmailSecretKey(uuid.New().String())
+		dev.Expiries = time.Now().Unix() + a.emailLifeTimeSecond
+		if err := a.st.NewKnownDevice(dev); err != nil {
+			return nil, err
+		}
+		a.emit(EventNewDevice, profileID, map[string]string{"ip_range": rng, "confirmation": "required"})
+
+		data.Key = dev.ConfirmKey
+		data.Link = a.mail.link(MailConfirmDevice, dev.ConfirmKey)
+		if err := a.sendDeviceMail(MailConfirmDevice, email, data, dev); err != nil {
+			return nil, err
+		}
+		return nil, &DeviceConfirmationRequiredError{ProfileID: profileID}
+	}
+
+	if err := a.st.NewKnownDevice(dev); err != nil {
+		return nil, err
+	}
+	a.emit(EventNewDevice, profileID, map[string]string{"ip_range": rng})
+	if err := a.sendDeviceMail(MailNewSignIn, email, data, dev); err != nil {
+		return nil, err
+	}
+	return dev, nil
+}
+
+// sendDeviceMail если письмо не ушло, запись устройства удаляется: иначе следующий
+// вход с него прошел бы как с известного без уведомления владельца
+func (a *Auth) sendDeviceMail(kind MailKind, email string, data *MailData, dev *KnownDevice) error {
+	err := a.mail.send(kind, email, data)
+	if err == nil {
+		return nil
+	}
+	if delErr := a.st.DelKnownDevice(dev.ID, dev.ProfileID); delErr != nil {
+		return delErr
+	}
+	return err
+}
+
+// ConfirmDevice подтверждает вход с нового устройства и выдает токен. Ключ выдается
+// только после всех факторов входа, поэтому второй фактор повторно не запрашивается
+func (a *Auth) ConfirmDevice(key EmailSecretKey, tokenLifeTimeSecond TokenLifeTime) (*Profile, string, error) {
+	dev, err := a.st.ConfirmKnownDevice(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := a.checkStatus(dev.ProfileID); err != nil {
+		return nil, "", err
+	}
+	prof, err := a.finishLogin(dev.ProfileID, "device_confirmation", dev)
+	if err != nil {
+		return nil, "", err
+	}
+	tok, err := a.NewToken(prof, tokenLifeTimeSecond)
+	if err != nil {
+		return nil, "", err
+	}
+	return prof, tok, nil
+}
+
+// RevokeSignIn по ключу из письма о новом входе отзывает выданный токен и забывает устройство
+func (a *Auth) RevokeSignIn(key EmailSecretKey) error {
+	dev, err := a.st.GetKnownDeviceByRevokeKey(key)
+	if err != nil {
+		return err
+	}
+	if dev.TokenID != "" {
+		if err := a.st.DelToken(dev.TokenID, dev.ProfileID); err != nil {
+			return err
+		}
+	}
+	if err := a.st.DelKnownDevice(dev.ID, dev.ProfileID); err != nil {
+		return err
+	}
+	a.emit(EventTokenRevoked, dev.ProfileID, map[string]string{"token": string(dev.TokenID), "reason": "sign_in_revoked"})
+	return nil
+}
+
+func (t *Profile) KnownDevices() ([]*KnownDevice, error) {
+	return t.cfg.st.ListKnownDevices(t.ProfileID)
+}
+
+// ForgetDevice следующий вход с устройства снова будет считаться новым
+func (t *Profile) ForgetDevice(id int64) error {
+	return t.cfg.st.DelKnownDevice(id, t.ProfileID)
+}
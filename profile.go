@@ -8,6 +8,19 @@ type profileConfig struct {
 	st             DriverStorage
 	passwordHasher *passwordHasher
 	emailLifeTime  int64
+	mail           *mailSender
+	codes          *emailCodes
+	totp           *totp
+	recoveryCodes  *recoveryCodes
+	events         *events
+	webAuthn       *webAuthn
+	mfaEmail       *mfaEmail
+
+	deletionGracePeriod int64
+	auditEvents         EventReader
+	exportHooks         map[string]ExportHook
+	attrs               *AttrSchema
+	rbac                *rbac
 }
 
 func newProfile(tokCfg *profileConfig, id ProfileID) *Profile {
@@ -20,6 +33,11 @@ func newProfile(tokCfg *profileConfig, id ProfileID) *Profile {
 type Profile struct {
 	cfg       *profileConfig
 	ProfileID ProfileID
+	meta      RequestMeta
+	//device новое устройство, к которому привязывается следующий токен
+	device int64
+	//freshAuth профиль получен из RequireFreshAuth или Reauthenticate
+	freshAuth bool
 }
 
 func (t *Profile) GetEmail() (string, error) {
@@ -39,56 +57,87 @@ func (t *Profile) GetLogin() (string, error) {
 	return login, nil
 }
 
+func (t *Profile) IsEmailVerified() (bool, error) {
+	return t.cfg.st.IsEmailVerified(t.ProfileID)
+}
+
 func (t *Profile) ChangePassword(OldPassword, NewPassword string) error {
-	ok, err := t.isPassword(OldPassword)
-	if err != nil {
+	if err := t.checkPassword(OldPassword); err != nil {
 		return err
 	}
 
-	if !ok {
-		return ErrWrongPassword
+	login, err := t.GetLogin()
+	if err != nil {
+		return err
 	}
 
-	login, err := t.GetLogin()
+	err = t.cfg.st.SetPasswordProfileByProfileID(t.ProfileID, t.cfg.passwordHasher.Hash(login, NewPassword))
 	if err != nil {
 		return err
 	}
+	t.emit(EventPasswordChanged, nil)
+	if !t.cfg.mail.enabled() {
+		return nil
+	}
 
-	t.cfg.st.SetPasswordProfileByProfileID(t.ProfileID, t.cfg.passwordHasher.Hash(login, NewPassword))
-	return nil
+	email, err := t.GetEmail()
+	if err != nil {
+		return err
+	}
+	return t.cfg.mail.notice(email, NoticePasswordChanged, "")
 }
 
+// ChangeEmail при включенных EmailCodeDigits возвращает цифровой код для Auth.AllowedChangeEmailByCode.
+// Профилю из Auth.RequireFreshAuth пароль не нужен.
+//
+// Deprecated: используйте Profile.RequestEmailChange
 func (t *Profile) ChangeEmail(password string) (EmailSecretKey, error) {
-	ok, err := t.isPassword(password)
-	if err != nil {
+	if err := t.checkPassword(password); err != nil {
 		return "", err
 	}
 
-	if !ok {
-		return "", ErrWrongPassword
-	}
-
 	email, err := t.GetEmail()
 	if err != nil {
 		return "", err
 	}
 
+	if t.cfg.codes.enabled() {
+		code, err := t.cfg.codes.issue(EmailKeyChangeEmail, email)
+		if err != nil {
+			return "", err
+		}
+		return code, t.cfg.mail.sendCode(MailChangeEmail, email, code)
+	}
+
 	secret := EmailSecretKey(uuid.New().String())
-	err = t.cfg.st.EmailNewSecretKey(secret, email, t.cfg.emailLifeTime)
-	return secret, err
+	err = t.cfg.st.EmailNewSecretKey(secret, EmailKeyChangeEmail, email, t.cfg.emailLifeTime)
+	if err != nil {
+		return "", err
+	}
+	return secret, t.cfg.mail.sendKey(MailChangeEmail, email, secret)
 }
 
+// DeleteProfile при заданном DeletionGracePeriodSecond профиль можно восстановить по ключу из письма.
+// Профилю из Auth.RequireFreshAuth пароль не нужен
 func (t *Profile) DeleteProfile(Password string) error {
-	ok, err := t.isPassword(Password)
+	_, err := t.DeleteProfileWithRestore(Password)
+	return err
+}
+
+// checkPassword подтверждение паролем для всех операций профиля. Недавняя
+// аутентификация (RequireFreshAuth, Reauthenticate) заменяет ввод пароля
+func (t *Profile) checkPassword(password string) error {
+	if t.freshAuth {
+		return nil
+	}
+	ok, err := t.isPassword(password)
 	if err != nil {
 		return err
 	}
-
 	if !ok {
 		return ErrWrongPassword
 	}
-
-	return t.cfg.st.DelProfile(t.ProfileID)
+	return nil
 }
 
 func (t *Profile) isPassword(password string) (bool, error) {
@@ -0,0 +1,221 @@
+package authentication
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultProofOfWorkLifeTime      = 5 * 60
+	defaultProofOfWorkRatePerMinute = 60
+	//defaultProofOfWorkExtraBits на сколько бит сложность может вырасти под нагрузкой
+	defaultProofOfWorkExtraBits = 8
+	proofOfWorkMaxBits          = 32
+	//powRateWindow частота принятых решений считается за скользящую минуту
+	powRateWindow = 60
+)
+
+// ProofOfWork задача в стиле hashcash. Клиент подбирает решение, при котором SHA-256
+// от "Challenge:решение" начинается с Difficulty нулевых бит, и передает штамп
+// из SolveProofOfWork в RequestMeta.ProofOfWork
+type ProofOfWork struct {
+	Challenge  string
+	Difficulty int
+	Expiries   time.Time
+}
+
+// powChallenge подписанная задача, сервер не хранит ее до использования
+type powChallenge struct {
+	Action     ChallengeAction `json:"action"`
+	Nonce      string          `json:"nonce"`
+	Difficulty int             `json:"difficulty"`
+	Expiries   int64           `json:"expiries"`
+	Hash       string          `json:"hash"`
+}
+
+func (c *powChallenge) sign(secretKey []byte) string {
+	return signature(secretKey, []byte("pow"), []byte(fmt.Sprintf("%s|%s|%d|%d", c.Action, c.Nonce, c.Difficulty, c.Expiries)))
+}
+
+type proofOfWork struct {
+	difficulty     int
+	maxDifficulty  int
+	lifeTime       int64
+	ratePerMinute  int
+	rate           AttemptStore
+	tokenSecretKey []byte
+	now            func() time.Time
+}
+
+func newProofOfWork(cfg AuthConfig) *proofOfWork {
+	if cfg.ProofOfWorkDifficulty <= 0 {
+		return nil
+	}
+	p := &proofOfWork{
+		difficulty:     cfg.ProofOfWorkDifficulty,
+		maxDifficulty:  cfg.ProofOfWorkMaxDifficulty,
+		lifeTime:       cfg.ProofOfWorkLifeTimeSecond,
+		ratePerMinute:  cfg.ProofOfWorkRatePerMinute,
+		rate:           cfg.AttemptStore,
+		tokenSecretKey: cfg.TokenSecretKey,
+		now:            time.Now,
+	}
+	if p.maxDifficulty < p.difficulty {
+		p.maxDifficulty = p.difficulty + defaultProofOfWorkExtraBits
+	}
+	if p.maxDifficulty > proofOfWorkMaxBits {
+		p.maxDifficulty = proofOfWorkMaxBits
+	}
+	if p.lifeTime <= 0 {
+		p.lifeTime = defaultProofOfWorkLifeTime
+	}
+	if p.ratePerMinute <= 0 {
+		p.ratePerMinute = defaultProofOfWorkRatePerMinute
+	}
+	//без общего AttemptStore частота запросов считается в пределах процесса
+	if p.rate == nil {
+		p.rate = NewMemoryAttemptStore()
+	}
+	return p
+}
+
+// powRateKey счетчик решений за окно bucket, у каждой минуты свой ключ:
+// счетчик одного ключа не сбрасывался бы при постоянной нагрузке
+func powRateKey(action ChallengeAction, bucket int64) string {
+	return "pow:" + string(action) + ":" + strconv.FormatInt(bucket, 10)
+}
+
+// recentRate оценка числа решений за последнюю минуту: текущее окно и доля
+// предыдущего, которая еще попадает в скользящую минуту
+func (p *proofOfWork) recentRate(action ChallengeAction) (int, error) {
+	now := p.now().Unix()
+	bucket := now / powRateWindow
+	cur, err := p.rate.Get(powRateKey(action, bucket), 2*powRateWindow)
+	if err != nil {
+		return 0, err
+	}
+	prev, err := p.rate.Get(powRateKey(action, bucket-1), 2*powRateWindow)
+	if err != nil {
+		return 0, err
+	}
+	return cur.Failures + prev.Failures*int(powRateWindow-now%powRateWindow)/powRateWindow, nil
+}
+
+// currentDifficulty каждое удвоение частоты принятых решений сверх ratePerMinute добавляет бит.
+// Выдача задачи бесплатна, поэтому она сложность не повышает
+func (p *proofOfWork) currentDifficulty(action ChallengeAction) (int, error) {
+	rate, err := p.recentRate(action)
+	if err != nil {
+		return 0, err
+	}
+	difficulty := p.difficulty
+	for n := rate; n > p.ratePerMinute && difficulty < p.maxDifficulty; n /= 2 {
+		difficulty++
+	}
+	return difficulty, nil
+}
+
+// NewProofOfWork выдает задачу, которую клиент решает перед Registration или ForgotPassword.
+// Без ProofOfWorkDifficulty возвращает nil
+func (a *Auth) NewProofOfWork(action ChallengeAction) (*ProofOfWork, error) {
+	p := a.proofOfWork
+	if p == nil {
+		return nil, nil
+	}
+	difficulty, err := p.currentDifficulty(action)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &powChallenge{
+		Action:     action,
+		Nonce:      uuid.New().String(),
+		Difficulty: difficulty,
+		Expiries:   time.Now().Unix() + p.lifeTime,
+	}
+	c.Hash = c.sign(p.tokenSecretKey)
+	bs, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return &ProofOfWork{
+		Challenge:  base64.RawURLEncoding.EncodeToString(bs),
+		Difficulty: difficulty,
+		Expiries:   time.Unix(c.Expiries, 0),
+	}, nil
+}
+
+// SolveProofOfWork перебирает решения и возвращает штамп "Challenge:решение"
+func SolveProofOfWork(pow *ProofOfWork) string {
+	for i := uint64(0); ; i++ {
+		stamp := pow.Challenge + ":" + strconv.FormatUint(i, 36)
+		if leadingZeroBits(stamp) >= pow.Difficulty {
+			return stamp
+		}
+	}
+}
+
+func leadingZeroBits(stamp string) int {
+	sum := sha256.Sum256([]byte(stamp))
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// requireProofOfWork проверяет штамп из RequestMeta и погашает задачу
+func (a *Auth) requireProofOfWork(action ChallengeAction) error {
+	p := a.proofOfWork
+	if p == nil {
+		return nil
+	}
+	stamp := a.meta.ProofOfWork
+	if stamp == "" {
+		return ErrProofOfWorkRequired
+	}
+
+	encoded, _, ok := strings.Cut(stamp, ":")
+	if !ok {
+		return ErrProofOfWorkInvalid
+	}
+	bs, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrProofOfWorkInvalid
+	}
+	c := new(powChallenge)
+	if err := json.Unmarshal(bs, c); err != nil {
+		return ErrProofOfWorkInvalid
+	}
+	if !hmac.Equal([]byte(c.sign(p.tokenSecretKey)), []byte(c.Hash)) || c.Action != action {
+		return ErrProofOfWorkInvalid
+	}
+	if c.Expiries < time.Now().Unix() {
+		return ErrProofOfWorkExpired
+	}
+	if leadingZeroBits(stamp) < c.Difficulty {
+		return ErrProofOfWorkInvalid
+	}
+	if err := a.st.UseProofOfWorkNonce(c.Nonce, c.Expiries); err != nil {
+		return err
+	}
+	return p.accepted(action)
+}
+
+// accepted учитывает принятое решение в окне текущей минуты
+func (p *proofOfWork) accepted(action ChallengeAction) error {
+	_, err := p.rate.Fail(powRateKey(action, p.now().Unix()/powRateWindow), 2*powRateWindow)
+	return err
+}
@@ -0,0 +1,46 @@
+package authentication
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProofOfWorkDifficultyDecay(t *testing.T) {
+	p := newProofOfWork(AuthConfig{
+		TokenSecretKey:           []byte("token secret keu"),
+		ProofOfWorkDifficulty:    8,
+		ProofOfWorkRatePerMinute: 1,
+	})
+	clock := time.Unix(1000*powRateWindow, 0)
+	p.now = func() time.Time { return clock }
+
+	difficulty := func() int {
+		d, err := p.currentDifficulty(ChallengeRegistration)
+		if err != nil {
+			t.Fatal("currentDifficulty error: ", err)
+		}
+		return d
+	}
+
+	for i := 0; i < 4; i++ {
+		if err := p.accepted(ChallengeRegistration); err != nil {
+			t.Fatal("accepted error: ", err)
+		}
+	}
+	for _, step := range []struct {
+		after time.Duration
+		want  int
+	}{
+		{0, 10},
+		{30 * time.Second, 10},
+		//из прошлой минуты в скользящее окно попадает половина решений
+		{90 * time.Second, 9},
+		//окно прошло, сложность вернулась к базовой
+		{2 * time.Minute, 8},
+	} {
+		clock = time.Unix(1000*powRateWindow, 0).Add(step.after)
+		if got := difficulty(); got != step.want {
+			t.Fatalf("difficulty after %s = %d, want %d", step.after, got, step.want)
+		}
+	}
+}
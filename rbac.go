@@ -0,0 +1,291 @@
+package authentication
+
+import (
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type Role string
+type Permission string
+
+const (
+	roleMaxLength       = 64
+	permissionMaxLength = 128
+	//defaultRBACCacheSecond роли, измененные в другом процессе, применяются с этой задержкой
+	defaultRBACCacheSecond = 60
+)
+
+// RoleDef роль получает разрешения всех родительских ролей, в том числе косвенных
+type RoleDef struct {
+	Name        Role
+	Permissions []Permission
+	Parents     []Role
+}
+
+// rbac хранит разрешения ролей с учетом наследования. Граф ролей загружается целиком
+// и перечитывается не реже раза в cacheSecond, так что проверка разрешения стоит
+// одного запроса ролей профиля
+type rbac struct {
+	//st без singleflight: общий с другими вызовами ListRoles мог начаться до изменения роли
+	st          DriverStorage
+	cacheSecond int64
+
+	mu       sync.RWMutex
+	loadedAt time.Time
+	graph    *roleGraph
+	//gen растет при каждом изменении ролей, граф, прочитанный до изменения, не сохраняется
+	gen uint64
+}
+
+// roleGraph для каждой роли хранит ее разрешения и саму роль с предками
+type roleGraph struct {
+	perms map[Role]map[Permission]struct{}
+	roles map[Role]map[Role]struct{}
+}
+
+func newRBAC(cfg AuthConfig, st DriverStorage) *rbac {
+	cacheSecond := cfg.RBACCacheSecond
+	if cacheSecond <= 0 {
+		cacheSecond = defaultRBACCacheSecond
+	}
+	return &rbac{st: st, cacheSecond: cacheSecond}
+}
+
+func (r *rbac) invalidate() {
+	r.mu.Lock()
+	r.graph = nil
+	r.gen++
+	r.mu.Unlock()
+}
+
+func (r *rbac) load() (*roleGraph, error) {
+	r.mu.RLock()
+	graph, loadedAt, gen := r.graph, r.loadedAt, r.gen
+	r.mu.RUnlock()
+	if graph != nil && time.Since(loadedAt) < time.Duration(r.cacheSecond)*time.Second {
+		return graph, nil
+	}
+
+	roles, err := r.st.ListRoles()
+	if err != nil {
+		return nil, err
+	}
+	defs := make(map[Role]*RoleDef, len(roles))
+	for _, role := range roles {
+		defs[role.Name] = role
+	}
+
+	graph = &roleGraph{
+		perms: make(map[Role]map[Permission]struct{}, len(defs)),
+		roles: make(map[Role]map[Role]struct{}, len(defs)),
+	}
+	for name := range defs {
+		perms, roles := make(map[Permission]struct{}), make(map[Role]struct{})
+		walkRoles(defs, name, func(def *RoleDef) {
+			roles[def.Name] = struct{}{}
+			for _, perm := range def.Permissions {
+				perms[perm] = struct{}{}
+			}
+		})
+		graph.perms[name], graph.roles[name] = perms, roles
+	}
+
+	r.mu.Lock()
+	if r.gen == gen {
+		r.graph, r.loadedAt = graph, time.Now()
+	}
+	r.mu.Unlock()
+	return graph, nil
+}
+
+// walkRoles обходит роль и ее предков, каждую один раз
+func walkRoles(defs map[Role]*RoleDef, name Role, fn func(def *RoleDef)) {
+	seen := map[Role]bool{}
+	stack := []Role{name}
+	for len(stack) > 0 {
+		name, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		def, ok := defs[name]
+		if !ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		fn(def)
+		stack = append(stack, def.Parents...)
+	}
+}
+
+func validRoleName(name Role) bool {
+	return name != "" && utf8.RuneCountInString(string(name)) <= roleMaxLength
+}
+
+// SaveRole создает роль или заменяет ее разрешения и родителей.
+// Родители должны существовать, цикл наследования дает ErrRoleCycle
+func (a *Auth) SaveRole(def RoleDef) error {
+	if !validRoleName(def.Name) {
+		return ErrRoleInvalid
+	}
+	for _, perm := range def.Permissions {
+		if perm == "" || utf8.RuneCountInString(string(perm)) > permissionMaxLength {
+			return ErrRoleInvalid
+		}
+	}
+
+	roles, err := a.st.ListRoles()
+	if err != nil {
+		return err
+	}
+	defs := make(map[Role]*RoleDef, len(roles)+1)
+	for _, role := range roles {
+		defs[role.Name] = role
+	}
+	for _, parent := range def.Parents {
+		if parent == def.Name {
+			return ErrRoleCycle
+		}
+		if _, ok := defs[parent]; !ok {
+			return ErrRoleNotFound
+		}
+	}
+
+	defs[def.Name] = &def
+	cycle := false
+	for _, parent := range def.Parents {
+		walkRoles(defs, parent, func(anc *RoleDef) {
+			if anc.Name == def.Name {
+				cycle = true
+			}
+		})
+	}
+	if cycle {
+		return ErrRoleCycle
+	}
+
+	if err := a.st.SaveRole(&def); err != nil {
+		return err
+	}
+	a.tokenConfig.rbac.invalidate()
+	return nil
+}
+
+// DeleteRole удаляет роль, ее назначения профилям и наследование от нее
+func (a *Auth) DeleteRole(name Role) error {
+	if err := a.st.DelRole(name); err != nil {
+		return err
+	}
+	a.tokenConfig.rbac.invalidate()
+	return nil
+}
+
+func (a *Auth) Role(name Role) (*RoleDef, error) {
+	return a.st.GetRole(name)
+}
+
+func (a *Auth) Roles() ([]*RoleDef, error) {
+	return a.st.ListRoles()
+}
+
+func (a *Auth) AssignRole(profileID ProfileID, role Role) error {
+	if err := a.st.AssignRole(profileID, role); err != nil {
+		return err
+	}
+	a.emit(EventRoleAssigned, profileID, map[string]string{"role": string(role)})
+	return nil
+}
+
+func (a *Auth) RevokeRole(profileID ProfileID, role Role) error {
+	if err := a.st.RevokeRole(profileID, role); err != nil {
+		return err
+	}
+	a.emit(EventRoleRevoked, profileID, map[string]string{"role": string(role)})
+	return nil
+}
+
+// Roles роли, назначенные профилю напрямую
+func (t *Profile) Roles() ([]Role, error) {
+	return t.cfg.st.ListProfileRoles(t.ProfileID)
+}
+
+// HasRole учитывает наследование: профиль с ролью admin, унаследованной от editor, имеет и editor
+func (t *Profile) HasRole(role Role) (bool, error) {
+	roles, err := t.cfg.st.ListProfileRoles(t.ProfileID)
+	if err != nil || len(roles) == 0 {
+		return false, err
+	}
+	graph, err := t.cfg.rbac.load()
+	if err != nil {
+		return false, err
+	}
+	for _, name := range roles {
+		if _, ok := graph.roles[name][role]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Permissions эффективные разрешения профиля по всем его ролям, по алфавиту
+func (t *Profile) Permissions() ([]Permission, error) {
+	set, err := t.permissions()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Permission, 0, len(set))
+	for perm := range set {
+		res = append(res, perm)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res, nil
+}
+
+// HasPermission проверяет разрешение по кешу графа ролей
+func (t *Profile) HasPermission(perm Permission) (bool, error) {
+	roles, err := t.cfg.st.ListProfileRoles(t.ProfileID)
+	if err != nil {
+		return false, err
+	}
+	if len(roles) == 0 {
+		return false, nil
+	}
+	graph, err := t.cfg.rbac.load()
+	if err != nil {
+		return false, err
+	}
+	for _, role := range roles {
+		if _, ok := graph.perms[role][perm]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RequirePermission возвращает ErrPermissionDenied, если разрешения нет
+func (t *Profile) RequirePermission(perm Permission) error {
+	ok, err := t.HasPermission(perm)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+func (t *Profile) permissions() (map[Permission]struct{}, error) {
+	roles, err := t.cfg.st.ListProfileRoles(t.ProfileID)
+	if err != nil {
+		return nil, err
+	}
+	graph, err := t.cfg.rbac.load()
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[Permission]struct{})
+	for _, role := range roles {
+		for perm := range graph.perms[role] {
+			set[perm] = struct{}{}
+		}
+	}
+	return set, nil
+}
@@ -0,0 +1,129 @@
+package authentication
+
+import "time"
+
+// mfaReauthenticate первый фактор вызова, открытого Reauthenticate.
+// Такой вызов завершает только CompleteReauthentication
+const mfaReauthenticate = "reauthenticate"
+
+// tokenOwner проверяет подпись токена и статус его владельца: заблокированный
+// профиль с неотозванным токеном не проходит повторную аутентификацию
+func (a *Auth) tokenOwner(publicToken string) (TokenID, ProfileID, error) {
+	mTok, err := readToken(a.tokenSecretKey, publicToken)
+	if err != nil {
+		return "", 0, err
+	}
+	profileID, err := a.st.ReadToken(mTok.ID)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := a.checkStatus(profileID); err != nil {
+		return "", 0, err
+	}
+	return mTok.ID, profileID, nil
+}
+
+// Reauthenticate повторно проверяет пароль владельца токена и обновляет время
+// аутентификации, после чего RequireFreshAuth пропускает чувствительные операции.
+// Неверные пароли учитываются AttemptStore, как при входе. Если у профиля включен
+// второй фактор, возвращается MFARequiredError и проверку завершает CompleteReauthentication
+func (a *Auth) Reauthenticate(publicToken string, password string) (*Profile, error) {
+	tokenID, profileID, err := a.tokenOwner(publicToken)
+	if err != nil {
+		return nil, err
+	}
+	login, err := a.st.GetLogin(profileID)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.bruteForce.check(login, a.meta); err != nil {
+		a.emit(EventLoginBlocked, profileID, map[string]string{"method": "reauthenticate"})
+		return nil, err
+	}
+
+	prof := a.profile(profileID)
+	ok, err := prof.isPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		a.emit(EventLoginFailed, profileID, map[string]string{"method": "reauthenticate"})
+		if err := a.bruteForce.fail(login, a.meta); err != nil {
+			return nil, err
+		}
+		return nil, ErrWrongPassword
+	}
+
+	if err := a.mfaChallenge(profileID, mfaReauthenticate); err != nil {
+		return nil, err
+	}
+	return a.reauthenticated(tokenID, profileID)
+}
+
+// CompleteReauthentication завершает Reauthenticate кодом второго фактора
+func (a *Auth) CompleteReauthentication(publicToken string, challenge MFAChallengeID, method MFAMethod, code string) (*Profile, error) {
+	tokenID, profileID, err := a.tokenOwner(publicToken)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := a.completeMFA(challenge, method, profileID, a.verifyMFACode(challenge, method, code)); err != nil {
+		return nil, err
+	}
+	return a.reauthenticated(tokenID, profileID)
+}
+
+// CompleteReauthenticationPasskey завершает Reauthenticate ключом доступа,
+// параметры церемонии возвращает BeginPasskeyMFA
+func (a *Auth) CompleteReauthenticationPasskey(publicToken string, challenge MFAChallengeID, resp *PasskeyAssertion) (*Profile, error) {
+	if err := a.webAuthnEnabled(); err != nil {
+		return nil, err
+	}
+	tokenID, profileID, err := a.tokenOwner(publicToken)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := a.completeMFA(challenge, MFAPasskey, profileID, a.verifyMFAPasskey(resp)); err != nil {
+		return nil, err
+	}
+	return a.reauthenticated(tokenID, profileID)
+}
+
+func (a *Auth) reauthenticated(tokenID TokenID, profileID ProfileID) (*Profile, error) {
+	if err := a.st.SetTokenAuthTime(tokenID, time.Now().Unix()); err != nil {
+		return nil, err
+	}
+	if a.bruteForce != nil {
+		login, err := a.st.GetLogin(profileID)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.bruteForce.reset(login); err != nil {
+			return nil, err
+		}
+	}
+	a.emit(EventReauthenticated, profileID, nil)
+	prof := a.profile(profileID)
+	prof.freshAuth = true
+	return prof, nil
+}
+
+// RequireFreshAuth возвращает ErrReauthenticationRequired, если учетные данные
+// владельца токена проверялись раньше, чем maxAgeSecond секунд назад.
+// Операции возвращенного профиля, подтверждаемые паролем, его не запрашивают
+func (a *Auth) RequireFreshAuth(publicToken string, maxAgeSecond int64) (*Profile, error) {
+	tokenID, profileID, err := a.tokenOwner(publicToken)
+	if err != nil {
+		return nil, err
+	}
+
+	authAt, err := a.st.ReadTokenAuthTime(tokenID)
+	if err != nil {
+		return nil, err
+	}
+	if authAt+maxAgeSecond < time.Now().Unix() {
+		return nil, ErrReauthenticationRequired
+	}
+	prof := a.profile(profileID)
+	prof.freshAuth = true
+	return prof, nil
+}
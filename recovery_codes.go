@@ -0,0 +1,81 @@
+package authentication
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+)
+
+const (
+	MFARecoveryCode MFAMethod = "recovery_code"
+
+	recoveryCodeCount = 10
+	recoveryCodeSize  = 10
+)
+
+// recoveryCodes одноразовые коды на случай потери второго фактора, в хранилище только хеши
+type recoveryCodes struct {
+	st     DriverStorage
+	secret []byte
+}
+
+func newRecoveryCodes(cfg AuthConfig) *recoveryCodes {
+	return &recoveryCodes{
+		st:     cfg.DriverStorage,
+		secret: cfg.TokenSecretKey,
+	}
+}
+
+func (r *recoveryCodes) hash(profileID ProfileID, code string) string {
+	return signature(r.secret, []byte("recovery_code"), poolInt64.Conv(int64(profileID)), []byte(normalizeRecoveryCode(code)))
+}
+
+// normalizeRecoveryCode пользователь может ввести код с пробелами и в другом регистре
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
+
+func (r *recoveryCodes) generate(profileID ProfileID) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	buf := make([]byte, recoveryCodeSize)
+	for i := range codes {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.EncodeToString(buf))[:recoveryCodeSize]
+		codes[i] = raw[:recoveryCodeSize/2] + "-" + raw[recoveryCodeSize/2:]
+		hashes[i] = r.hash(profileID, codes[i])
+	}
+
+	if err := r.st.SetRecoveryCodes(profileID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (r *recoveryCodes) use(profileID ProfileID, code string) (bool, error) {
+	return r.st.UseRecoveryCode(profileID, r.hash(profileID, code))
+}
+
+// GenerateRecoveryCodes заменяет прежний набор кодов новым. Коды показываются один раз
+func (t *Profile) GenerateRecoveryCodes(password string) ([]string, error) {
+	if err := t.checkPassword(password); err != nil {
+		return nil, err
+	}
+	codes, err := t.cfg.recoveryCodes.generate(t.ProfileID)
+	if err != nil {
+		return nil, err
+	}
+	t.emit(EventRecoveryCodesGenerated, nil)
+	return codes, nil
+}
+
+func (t *Profile) RecoveryCodesRemaining() (int, error) {
+	return t.cfg.st.CountRecoveryCodes(t.ProfileID)
+}
@@ -17,8 +17,8 @@ type SingleflightDriverStorage struct {
 	st  DriverStorage
 }
 
-func (sing *SingleflightDriverStorage) EmailNewSecretKey(key EmailSecretKey, email string, lifetime int64) error {
-	return sing.st.EmailNewSecretKey(key, email, lifetime)
+func (sing *SingleflightDriverStorage) EmailNewSecretKey(key EmailSecretKey, purpose EmailKeyPurpose, email string, lifetime int64) error {
+	return sing.st.EmailNewSecretKey(key, purpose, email, lifetime)
 
 }
 func (sing *SingleflightDriverStorage) EmailReadSecretKey(key EmailSecretKey) (email string, err error) {
@@ -33,6 +33,23 @@ func (sing *SingleflightDriverStorage) EmailDeleteSecretKey(key EmailSecretKey)
 	return sing.st.EmailDeleteSecretKey(key)
 }
 
+// EmailConsumeSecretKey нельзя объединять: каждый вызов должен гасить ключ самостоятельно
+func (sing *SingleflightDriverStorage) EmailConsumeSecretKey(key EmailSecretKey, purpose EmailKeyPurpose) (email string, err error) {
+	return sing.st.EmailConsumeSecretKey(key, purpose)
+}
+
+func (sing *SingleflightDriverStorage) EmailNewSecretCode(key EmailSecretKey, purpose EmailKeyPurpose, email string, codeHash string, lifetime int64) error {
+	return sing.st.EmailNewSecretCode(key, purpose, email, codeHash, lifetime)
+}
+
+func (sing *SingleflightDriverStorage) EmailReadSecretCode(key EmailSecretKey) (res *ResultSecretCode, err error) {
+	return sing.st.EmailReadSecretCode(key)
+}
+
+func (sing *SingleflightDriverStorage) EmailIncSecretCodeAttempts(key EmailSecretKey) (attempts int, err error) {
+	return sing.st.EmailIncSecretCodeAttempts(key)
+}
+
 func (sing *SingleflightDriverStorage) NewToken(tokenID TokenID, profileID ProfileID, lifeTime TokenLifeTime) error {
 	return sing.st.NewToken(tokenID, profileID, lifeTime)
 }
@@ -48,6 +65,10 @@ func (sing *SingleflightDriverStorage) DelToken(tokenID TokenID, profileID Profi
 	return sing.st.DelToken(tokenID, profileID)
 }
 
+func (sing *SingleflightDriverStorage) DelTokensByProfileID(profileID ProfileID) error {
+	return sing.st.DelTokensByProfileID(profileID)
+}
+
 func (sing *SingleflightDriverStorage) IsUniqueLogin(login string) (bool, error) {
 	v, err, _ := sing.req.Do("1"+string(login), func() (interface{}, error) {
 		return sing.st.IsUniqueLogin(login)
@@ -61,6 +82,10 @@ func (sing *SingleflightDriverStorage) IsUniqueEmail(email string) (bool, error)
 	return v.(bool), err
 }
 
+func (sing *SingleflightDriverStorage) IsUniqueLoginSkeleton(skeleton string) (bool, error) {
+	return sing.st.IsUniqueLoginSkeleton(skeleton)
+}
+
 func (sing *SingleflightDriverStorage) NewProfile(login, email, password string) (ProfileID, error) {
 	return sing.st.NewProfile(login, email, password)
 }
@@ -85,6 +110,17 @@ func (sing *SingleflightDriverStorage) SetEmailByProfileID(profileID ProfileID,
 	return sing.st.SetEmailByProfileID(profileID, email)
 }
 
+func (sing *SingleflightDriverStorage) IsEmailVerified(profileID ProfileID) (verified bool, err error) {
+	v, err, _ := sing.req.Do(fmt.Sprint("a", profileID), func() (interface{}, error) {
+		return sing.st.IsEmailVerified(profileID)
+	})
+	return v.(bool), err
+}
+
+func (sing *SingleflightDriverStorage) SetEmailVerified(profileID ProfileID, verified bool) error {
+	return sing.st.SetEmailVerified(profileID, verified)
+}
+
 func (sing *SingleflightDriverStorage) GetProfileIDByEmail(email string) (profileID ProfileID, err error) {
 	v, err, _ := sing.req.Do("3"+string(email), func() (interface{}, error) {
 		return sing.st.GetProfileIDByEmail(email)
@@ -124,3 +160,236 @@ func (sing *SingleflightDriverStorage) GetLogin(profileID ProfileID) (login stri
 	})
 	return v.(string), err
 }
+
+func (sing *SingleflightDriverStorage) NewEmailChange(change *EmailChange) error {
+	return sing.st.NewEmailChange(change)
+}
+
+func (sing *SingleflightDriverStorage) GetEmailChangeByKey(key EmailSecretKey) (*EmailChange, error) {
+	return sing.st.GetEmailChangeByKey(key)
+}
+
+func (sing *SingleflightDriverStorage) UpdateEmailChange(change *EmailChange, prevState EmailChangeState) error {
+	return sing.st.UpdateEmailChange(change, prevState)
+}
+
+func (sing *SingleflightDriverStorage) NewMFAChallenge(challenge MFAChallengeID, profileID ProfileID, method string, lifetime int64) error {
+	return sing.st.NewMFAChallenge(challenge, profileID, method, lifetime)
+}
+
+func (sing *SingleflightDriverStorage) ReadMFAChallenge(challenge MFAChallengeID) (*ResultMFAChallenge, error) {
+	return sing.st.ReadMFAChallenge(challenge)
+}
+
+func (sing *SingleflightDriverStorage) IncMFAChallengeAttempts(challenge MFAChallengeID) (int, error) {
+	return sing.st.IncMFAChallengeAttempts(challenge)
+}
+
+func (sing *SingleflightDriverStorage) ConsumeMFAChallenge(challenge MFAChallengeID) (ProfileID, error) {
+	return sing.st.ConsumeMFAChallenge(challenge)
+}
+
+func (sing *SingleflightDriverStorage) DelMFAChallenge(challenge MFAChallengeID) error {
+	return sing.st.DelMFAChallenge(challenge)
+}
+
+func (sing *SingleflightDriverStorage) ReadTokenAuthTime(tokenID TokenID) (int64, error) {
+	return sing.st.ReadTokenAuthTime(tokenID)
+}
+
+func (sing *SingleflightDriverStorage) SetTokenAuthTime(tokenID TokenID, authAt int64) error {
+	return sing.st.SetTokenAuthTime(tokenID, authAt)
+}
+
+func (sing *SingleflightDriverStorage) SetMFAChallengeCode(challenge MFAChallengeID, codeHash string, lifetime int64, resendInterval int64) (bool, error) {
+	return sing.st.SetMFAChallengeCode(challenge, codeHash, lifetime, resendInterval)
+}
+
+func (sing *SingleflightDriverStorage) IncMFAChallengeCodeAttempts(challenge MFAChallengeID) (int, error) {
+	return sing.st.IncMFAChallengeCodeAttempts(challenge)
+}
+
+func (sing *SingleflightDriverStorage) NewKnownDevice(device *KnownDevice) error {
+	return sing.st.NewKnownDevice(device)
+}
+
+func (sing *SingleflightDriverStorage) ListKnownDevices(profileID ProfileID) ([]*KnownDevice, error) {
+	return sing.st.ListKnownDevices(profileID)
+}
+
+func (sing *SingleflightDriverStorage) SeenKnownDevice(id int64) error {
+	return sing.st.SeenKnownDevice(id)
+}
+
+func (sing *SingleflightDriverStorage) SetKnownDeviceToken(id int64, tokenID TokenID) error {
+	return sing.st.SetKnownDeviceToken(id, tokenID)
+}
+
+func (sing *SingleflightDriverStorage) ConfirmKnownDevice(confirmKey EmailSecretKey) (*KnownDevice, error) {
+	return sing.st.ConfirmKnownDevice(confirmKey)
+}
+
+func (sing *SingleflightDriverStorage) GetKnownDeviceByRevokeKey(revokeKey EmailSecretKey) (*KnownDevice, error) {
+	return sing.st.GetKnownDeviceByRevokeKey(revokeKey)
+}
+
+func (sing *SingleflightDriverStorage) DelKnownDevice(id int64, profileID ProfileID) error {
+	return sing.st.DelKnownDevice(id, profileID)
+}
+
+func (sing *SingleflightDriverStorage) SetMFAEmail(profileID ProfileID, enabled bool) error {
+	return sing.st.SetMFAEmail(profileID, enabled)
+}
+
+func (sing *SingleflightDriverStorage) IsMFAEmailEnabled(profileID ProfileID) (bool, error) {
+	return sing.st.IsMFAEmailEnabled(profileID)
+}
+
+func (sing *SingleflightDriverStorage) SetTOTP(profileID ProfileID, secret string) error {
+	return sing.st.SetTOTP(profileID, secret)
+}
+
+func (sing *SingleflightDriverStorage) GetTOTP(profileID ProfileID) (*ResultTOTP, error) {
+	return sing.st.GetTOTP(profileID)
+}
+
+func (sing *SingleflightDriverStorage) ConfirmTOTP(profileID ProfileID) error {
+	return sing.st.ConfirmTOTP(profileID)
+}
+
+func (sing *SingleflightDriverStorage) UseTOTPStep(profileID ProfileID, step int64) (bool, error) {
+	return sing.st.UseTOTPStep(profileID, step)
+}
+
+func (sing *SingleflightDriverStorage) DelTOTP(profileID ProfileID) error {
+	return sing.st.DelTOTP(profileID)
+}
+
+func (sing *SingleflightDriverStorage) SetRecoveryCodes(profileID ProfileID, hashes []string) error {
+	return sing.st.SetRecoveryCodes(profileID, hashes)
+}
+
+func (sing *SingleflightDriverStorage) UseRecoveryCode(profileID ProfileID, hash string) (bool, error) {
+	return sing.st.UseRecoveryCode(profileID, hash)
+}
+
+func (sing *SingleflightDriverStorage) CountRecoveryCodes(profileID ProfileID) (int, error) {
+	return sing.st.CountRecoveryCodes(profileID)
+}
+
+func (sing *SingleflightDriverStorage) NewWebAuthnSession(challenge string, profileID ProfileID, lifetime int64) error {
+	return sing.st.NewWebAuthnSession(challenge, profileID, lifetime)
+}
+
+func (sing *SingleflightDriverStorage) ConsumeWebAuthnSession(challenge string) (ProfileID, error) {
+	return sing.st.ConsumeWebAuthnSession(challenge)
+}
+
+func (sing *SingleflightDriverStorage) NewWebAuthnCredential(cred *WebAuthnCredential) error {
+	return sing.st.NewWebAuthnCredential(cred)
+}
+
+func (sing *SingleflightDriverStorage) GetWebAuthnCredential(id string) (*WebAuthnCredential, error) {
+	return sing.st.GetWebAuthnCredential(id)
+}
+
+func (sing *SingleflightDriverStorage) ListWebAuthnCredentials(profileID ProfileID) ([]*WebAuthnCredential, error) {
+	return sing.st.ListWebAuthnCredentials(profileID)
+}
+
+func (sing *SingleflightDriverStorage) UpdateWebAuthnSignCount(id string, signCount uint32) error {
+	return sing.st.UpdateWebAuthnSignCount(id, signCount)
+}
+
+func (sing *SingleflightDriverStorage) DelWebAuthnCredential(id string, profileID ProfileID) error {
+	return sing.st.DelWebAuthnCredential(id, profileID)
+}
+
+func (sing *SingleflightDriverStorage) UseProofOfWorkNonce(nonce string, expiries int64) error {
+	return sing.st.UseProofOfWorkNonce(nonce, expiries)
+}
+
+func (sing *SingleflightDriverStorage) GetProfileStatus(profileID ProfileID) (*ProfileStatus, error) {
+	return sing.st.GetProfileStatus(profileID)
+}
+
+func (sing *SingleflightDriverStorage) SetProfileStatus(profileID ProfileID, status *ProfileStatus) error {
+	return sing.st.SetProfileStatus(profileID, status)
+}
+
+func (sing *SingleflightDriverStorage) MarkProfileDeleted(profileID ProfileID, restoreKey EmailSecretKey, purgeAt int64) error {
+	return sing.st.MarkProfileDeleted(profileID, restoreKey, purgeAt)
+}
+
+func (sing *SingleflightDriverStorage) RestoreProfile(restoreKey EmailSecretKey) (ProfileID, error) {
+	return sing.st.RestoreProfile(restoreKey)
+}
+
+func (sing *SingleflightDriverStorage) ListProfilesToPurge(before int64, limit int) ([]ProfileID, error) {
+	return sing.st.ListProfilesToPurge(before, limit)
+}
+
+func (sing *SingleflightDriverStorage) GetProfileRecord(profileID ProfileID) (*ResultProfile, error) {
+	return sing.st.GetProfileRecord(profileID)
+}
+
+func (sing *SingleflightDriverStorage) ListTokens(profileID ProfileID) ([]*ResultToken, error) {
+	return sing.st.ListTokens(profileID)
+}
+
+func (sing *SingleflightDriverStorage) ListEmailChanges(profileID ProfileID) ([]*EmailChange, error) {
+	return sing.st.ListEmailChanges(profileID)
+}
+
+func (sing *SingleflightDriverStorage) AnonymizeProfile(profileID ProfileID, login, email string) error {
+	return sing.st.AnonymizeProfile(profileID, login, email)
+}
+
+func (sing *SingleflightDriverStorage) GetAttrs(profileID ProfileID) (map[string]string, error) {
+	v, err, _ := sing.req.Do(fmt.Sprint("b", profileID), func() (interface{}, error) {
+		return sing.st.GetAttrs(profileID)
+	})
+	return v.(map[string]string), err
+}
+
+func (sing *SingleflightDriverStorage) SetAttrs(profileID ProfileID, attrs map[string]*ResultAttr) error {
+	return sing.st.SetAttrs(profileID, attrs)
+}
+
+func (sing *SingleflightDriverStorage) FindProfilesByAttr(name, value string, limit int) ([]ProfileID, error) {
+	return sing.st.FindProfilesByAttr(name, value, limit)
+}
+
+func (sing *SingleflightDriverStorage) SaveRole(role *RoleDef) error {
+	return sing.st.SaveRole(role)
+}
+
+func (sing *SingleflightDriverStorage) DelRole(name Role) error {
+	return sing.st.DelRole(name)
+}
+
+func (sing *SingleflightDriverStorage) GetRole(name Role) (*RoleDef, error) {
+	return sing.st.GetRole(name)
+}
+
+func (sing *SingleflightDriverStorage) ListRoles() ([]*RoleDef, error) {
+	v, err, _ := sing.req.Do("c", func() (interface{}, error) {
+		return sing.st.ListRoles()
+	})
+	return v.([]*RoleDef), err
+}
+
+func (sing *SingleflightDriverStorage) AssignRole(profileID ProfileID, role Role) error {
+	return sing.st.AssignRole(profileID, role)
+}
+
+func (sing *SingleflightDriverStorage) RevokeRole(profileID ProfileID, role Role) error {
+	return sing.st.RevokeRole(profileID, role)
+}
+
+func (sing *SingleflightDriverStorage) ListProfileRoles(profileID ProfileID) ([]Role, error) {
+	v, err, _ := sing.req.Do(fmt.Sprint("d", profileID), func() (interface{}, error) {
+		return sing.st.ListProfileRoles(profileID)
+	})
+	return v.([]Role), err
+}
@@ -0,0 +1,82 @@
+package authentication
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// purgeBatch профили удаляются окончательно пачками
+const purgeBatch = 100
+
+// DeleteProfileWithRestore при заданном DeletionGracePeriodSecond не удаляет профиль,
+// а переводит его в StatusPendingDeletion: логин и E-MAIL остаются занятыми, вход и токены
+// отклоняются. Ключ восстановления отправляется письмом и действует до окончательного удаления.
+// Без DeletionGracePeriodSecond профиль удаляется сразу, ключ пустой
+func (t *Profile) DeleteProfileWithRestore(password string) (EmailSecretKey, error) {
+	if err := t.checkPassword(password); err != nil {
+		return "", err
+	}
+
+	if t.cfg.deletionGracePeriod <= 0 {
+		if err := t.cfg.st.DelProfile(t.ProfileID); err != nil {
+			return "", err
+		}
+		t.emit(EventProfileDeleted, nil)
+		return "", nil
+	}
+
+	email, err := t.cfg.st.GetEmail(t.ProfileID)
+	if err != nil {
+		return "", err
+	}
+
+	key := EmailSecretKey(uuid.New().String())
+	purgeAt := time.Now().Unix() + t.cfg.deletionGracePeriod
+	if err := t.cfg.st.MarkProfileDeleted(t.ProfileID, key, purgeAt); err != nil {
+		return "", err
+	}
+	if err := t.cfg.st.DelTokensByProfileID(t.ProfileID); err != nil {
+		return "", err
+	}
+	t.emit(EventProfileDeletionScheduled, map[string]string{"purge_at": time.Unix(purgeAt, 0).UTC().Format(time.RFC3339)})
+
+	return key, t.cfg.mail.send(MailRestoreProfile, email, &MailData{
+		Key:  key,
+		Link: t.cfg.mail.link(MailRestoreProfile, key),
+		Time: time.Unix(purgeAt, 0),
+	})
+}
+
+// RestoreProfile отменяет удаление профиля по ключу из письма
+func (a *Auth) RestoreProfile(key EmailSecretKey) (*Profile, error) {
+	pid, err := a.st.RestoreProfile(key)
+	if err != nil {
+		return nil, err
+	}
+	a.emit(EventProfileRestored, pid, nil)
+	return a.profile(pid), nil
+}
+
+// PurgeDeletedProfiles окончательно удаляет профили, срок восстановления которых истек.
+// Предназначен для периодического вызова, возвращает число удаленных профилей
+func (a *Auth) PurgeDeletedProfiles() (int, error) {
+	purged := 0
+	for {
+		ids, err := a.st.ListProfilesToPurge(time.Now().Unix(), purgeBatch)
+		if err != nil {
+			return purged, err
+		}
+		if len(ids) == 0 {
+			return purged, nil
+		}
+
+		for _, pid := range ids {
+			if err := a.st.DelProfile(pid); err != nil {
+				return purged, err
+			}
+			a.emit(EventProfileDeleted, pid, map[string]string{"reason": "grace_period_expired"})
+			purged++
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package authentication
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// параметры RFC 6238, которые понимают все приложения-аутентификаторы
+const (
+	totpPeriod     = 30
+	totpDigits     = 6
+	totpSecretSize = 20
+	totpSkew       = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type TOTPEnrollment struct {
+	//Secret в base32 для ручного ввода
+	Secret string
+	//URI otpauth:// для QR кода
+	URI string
+}
+
+// TOTPCode вычисляет код для момента t. Используется клиентами и тестами
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, t.Unix()/totpPeriod), nil
+}
+
+func hotp(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, code%1000000)
+}
+
+// totp хранит секреты зашифрованными и не принимает код повторно в пределах его шага
+type totp struct {
+	st     DriverStorage
+	box    *secretBox
+	issuer string
+}
+
+func newTOTP(cfg AuthConfig) *totp {
+	key := cfg.TOTPEncryptionKey
+	if len(key) == 0 {
+		key = cfg.TokenSecretKey
+	}
+	issuer := cfg.TOTPIssuer
+	if issuer == "" {
+		issuer = "authentication"
+	}
+	return &totp{
+		st:     cfg.DriverStorage,
+		box:    newSecretBox(key, "totp"),
+		issuer: issuer,
+	}
+}
+
+func (t *totp) enroll(profileID ProfileID, login string) (*TOTPEnrollment, error) {
+	res, err := t.st.GetTOTP(profileID)
+	if err == nil && res.Confirmed {
+		return nil, ErrTOTPAlreadyEnrolled
+	}
+	if err != nil && err != ErrTOTPNotFound {
+		return nil, err
+	}
+
+	key := make([]byte, totpSecretSize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	sealed, err := t.box.Seal(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.st.SetTOTP(profileID, sealed); err != nil {
+		return nil, err
+	}
+
+	secret := totpEncoding.EncodeToString(key)
+	label := url.PathEscape(t.issuer + ":" + login)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", t.issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    "otpauth://totp/" + label + "?" + q.Encode(),
+	}, nil
+}
+
+// verify проверяет код с допуском в один шаг и помечает шаг использованным
+func (t *totp) verify(profileID ProfileID, code string, confirmed bool) (bool, error) {
+	res, err := t.st.GetTOTP(profileID)
+	if err != nil {
+		return false, err
+	}
+	if res.Confirmed != confirmed {
+		return false, ErrTOTPNotFound
+	}
+
+	key, err := t.box.Open(res.Secret)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now().Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= res.LastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step)), []byte(code)) == 1 {
+			return t.st.UseTOTPStep(profileID, step)
+		}
+	}
+	return false, nil
+}
+
+func (t *totp) enabled(profileID ProfileID) (bool, error) {
+	res, err := t.st.GetTOTP(profileID)
+	if err == ErrTOTPNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return res.Confirmed, nil
+}
+
+// EnrollTOTP создает новый секрет. Второй фактор включается после ConfirmTOTP
+func (t *Profile) EnrollTOTP() (*TOTPEnrollment, error) {
+	login, err := t.GetLogin()
+	if err != nil {
+		return nil, err
+	}
+	return t.cfg.totp.enroll(t.ProfileID, login)
+}
+
+func (t *Profile) ConfirmTOTP(code string) error {
+	ok, err := t.cfg.totp.verify(t.ProfileID, code, false)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrMFACodeInvalid
+	}
+	if err := t.cfg.st.ConfirmTOTP(t.ProfileID); err != nil {
+		return err
+	}
+	t.emit(EventMFAEnabled, map[string]string{"method": string(MFATOTP)})
+	return nil
+}
+
+func (t *Profile) DisableTOTP(password string) error {
+	if err := t.checkPassword(password); err != nil {
+		return err
+	}
+	if err := t.cfg.st.DelTOTP(t.ProfileID); err != nil {
+		return err
+	}
+	t.emit(EventMFADisabled, map[string]string{"method": string(MFATOTP)})
+	return nil
+}
+
+func (t *Profile) TOTPEnabled() (bool, error) {
+	return t.cfg.totp.enabled(t.ProfileID)
+}
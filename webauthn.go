@@ -0,0 +1,623 @@
+package authentication
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	defaultWebAuthnTimeout = 5 * 60
+	//webAuthnMaxCredentialID длина ID ключа в base64url, которую принимает хранилище
+	webAuthnMaxCredentialID = 255
+)
+
+// MFAPasskey ключ доступа вторым фактором, включается WebAuthnConfig.SecondFactor
+const MFAPasskey MFAMethod = "passkey"
+
+// COSE алгоритмы, которые поддерживает проверяющая сторона
+const (
+	coseES256 = -7
+	coseEdDSA = -8
+	coseRS256 = -257
+)
+
+// флаги authenticatorData
+const (
+	authDataUserPresent  = 0x01
+	authDataUserVerified = 0x04
+	authDataAttested     = 0x40
+)
+
+type WebAuthnConfig struct {
+	//RPID домен проверяющей стороны, например example.com
+	RPID   string
+	RPName string
+	//Origins допустимые origin страниц, например https://example.com
+	Origins []string
+	//UserVerification требует проверку пользователя (PIN, биометрия) на аутентификаторе
+	UserVerification bool
+	//TimeoutSecond время на прохождение церемонии, по умолчанию 5 минут
+	TimeoutSecond int64
+	//SecondFactor ключи доступа профиля принимаются вторым фактором после пароля или ссылки
+	SecondFactor bool
+}
+
+type WebAuthnCredential struct {
+	//ID идентификатор ключа в base64url
+	ID        string
+	ProfileID ProfileID
+	//PublicKey открытый ключ в формате COSE
+	PublicKey  []byte
+	SignCount  uint32
+	Name       string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
+
+type PasskeyDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type PasskeyCreationOptions struct {
+	Challenge string `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	PubKeyCredParams []struct {
+		Type string `json:"type"`
+		Alg  int    `json:"alg"`
+	} `json:"pubKeyCredParams"`
+	Timeout                int64               `json:"timeout"`
+	Attestation            string              `json:"attestation"`
+	ExcludeCredentials     []PasskeyDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+}
+
+type PasskeyRequestOptions struct {
+	Challenge        string              `json:"challenge"`
+	RPID             string              `json:"rpId"`
+	Timeout          int64               `json:"timeout"`
+	UserVerification string              `json:"userVerification"`
+	AllowCredentials []PasskeyDescriptor `json:"allowCredentials"`
+}
+
+// PasskeyAttestation ответ navigator.credentials.create, бинарные поля в base64url
+type PasskeyAttestation struct {
+	ID                string `json:"id"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject"`
+}
+
+// PasskeyAssertion ответ navigator.credentials.get, бинарные поля в base64url
+type PasskeyAssertion struct {
+	ID                string `json:"id"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle"`
+}
+
+type webAuthn struct {
+	st      DriverStorage
+	cfg     *WebAuthnConfig
+	rpHash  [32]byte
+	timeout int64
+}
+
+func newWebAuthn(cfg AuthConfig) *webAuthn {
+	if cfg.WebAuthn == nil {
+		return nil
+	}
+	w := &webAuthn{
+		st:      cfg.DriverStorage,
+		cfg:     cfg.WebAuthn,
+		rpHash:  sha256.Sum256([]byte(cfg.WebAuthn.RPID)),
+		timeout: cfg.WebAuthn.TimeoutSecond,
+	}
+	if w.timeout <= 0 {
+		w.timeout = defaultWebAuthnTimeout
+	}
+	return w
+}
+
+func (w *webAuthn) userVerification() string {
+	if w.cfg.UserVerification {
+		return "required"
+	}
+	return "preferred"
+}
+
+func webAuthnUserID(profileID ProfileID) string {
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, uint64(profileID))
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// newSession сессия церемонии хранится по challenge. profileID 0 - вход без логина
+func (w *webAuthn) newSession(profileID ProfileID) (string, error) {
+	bs := make([]byte, 32)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	challenge := base64.RawURLEncoding.EncodeToString(bs)
+	return challenge, w.st.NewWebAuthnSession(challenge, profileID, w.timeout)
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// verifyClientData проверяет тип церемонии и origin, затем гасит сессию
+func (w *webAuthn) verifyClientData(raw []byte, ceremony string) (ProfileID, error) {
+	cd := new(clientData)
+	if err := json.Unmarshal(raw, cd); err != nil {
+		return 0, ErrWebAuthnInvalid
+	}
+	if cd.Type != ceremony {
+		return 0, ErrWebAuthnInvalid
+	}
+
+	originOK := false
+	for _, origin := range w.cfg.Origins {
+		if cd.Origin == origin {
+			originOK = true
+		}
+	}
+	if !originOK {
+		return 0, ErrWebAuthnInvalid
+	}
+
+	return w.st.ConsumeWebAuthnSession(strings.TrimRight(cd.Challenge, "="))
+}
+
+type authenticatorData struct {
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+func (w *webAuthn) parseAuthData(bs []byte) (*authenticatorData, error) {
+	if len(bs) < 37 {
+		return nil, ErrWebAuthnInvalid
+	}
+	if subtle.ConstantTimeCompare(bs[:32], w.rpHash[:]) != 1 {
+		return nil, ErrWebAuthnInvalid
+	}
+
+	ad := &authenticatorData{
+		flags:     bs[32],
+		signCount: binary.BigEndian.Uint32(bs[33:37]),
+	}
+	if ad.flags&authDataUserPresent == 0 {
+		return nil, ErrWebAuthnInvalid
+	}
+	if w.cfg.UserVerification && ad.flags&authDataUserVerified == 0 {
+		return nil, ErrWebAuthnInvalid
+	}
+
+	if ad.flags&authDataAttested != 0 {
+		//aaguid (16) + длина идентификатора (2)
+		rest := bs[37:]
+		if len(rest) < 18 {
+			return nil, ErrWebAuthnInvalid
+		}
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLen {
+			return nil, ErrWebAuthnInvalid
+		}
+		ad.credentialID = rest[:idLen]
+
+		_, n, err := cborDecode(rest[idLen:])
+		if err != nil {
+			return nil, ErrWebAuthnInvalid
+		}
+		ad.publicKey = rest[idLen : idLen+n]
+	}
+	return ad, nil
+}
+
+// coseVerify проверяет подпись data ключом в формате COSE
+func coseVerify(coseKey []byte, data, sig []byte) error {
+	raw, _, err := cborDecode(coseKey)
+	if err != nil {
+		return ErrWebAuthnInvalid
+	}
+	key, ok := raw.(map[interface{}]interface{})
+	if !ok {
+		return ErrWebAuthnInvalid
+	}
+	alg, _ := key[int64(3)].(int64)
+	digest := sha256.Sum256(data)
+
+	switch alg {
+	case coseES256:
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if len(x) != 32 || len(y) != 32 {
+			return ErrWebAuthnInvalid
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return ErrWebAuthnInvalid
+		}
+		if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+			return ErrWebAuthnInvalidSignature
+		}
+	case coseEdDSA:
+		x, _ := key[int64(-2)].([]byte)
+		if len(x) != ed25519.PublicKeySize {
+			return ErrWebAuthnInvalid
+		}
+		if !ed25519.Verify(ed25519.PublicKey(x), data, sig) {
+			return ErrWebAuthnInvalidSignature
+		}
+	case coseRS256:
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return ErrWebAuthnInvalid
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return ErrWebAuthnInvalidSignature
+		}
+	default:
+		return ErrWebAuthnUnsupportedKey
+	}
+	return nil
+}
+
+func coseSupported(coseKey []byte) bool {
+	raw, _, err := cborDecode(coseKey)
+	if err != nil {
+		return false
+	}
+	key, ok := raw.(map[interface{}]interface{})
+	if !ok {
+		return false
+	}
+	switch key[int64(3)] {
+	case int64(coseES256), int64(coseEdDSA), int64(coseRS256):
+		return true
+	}
+	return false
+}
+
+func (a *Auth) webAuthnEnabled() error {
+	if a.webAuthn == nil {
+		return ErrWebAuthnDisabled
+	}
+	return nil
+}
+
+// BeginPasskeyRegistration возвращает параметры для navigator.credentials.create.
+// Аттестация не запрашивается и не проверяется
+func (t *Profile) BeginPasskeyRegistration() (*PasskeyCreationOptions, error) {
+	w := t.cfg.webAuthn
+	if w == nil {
+		return nil, ErrWebAuthnDisabled
+	}
+
+	login, err := t.GetLogin()
+	if err != nil {
+		return nil, err
+	}
+	creds, err := t.cfg.st.ListWebAuthnCredentials(t.ProfileID)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := w.newSession(t.ProfileID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &PasskeyCreationOptions{
+		Challenge:   challenge,
+		Timeout:     w.timeout * 1000,
+		Attestation: "none",
+	}
+	opts.RP.ID = w.cfg.RPID
+	opts.RP.Name = w.cfg.RPName
+	opts.User.ID = webAuthnUserID(t.ProfileID)
+	opts.User.Name = login
+	opts.User.DisplayName = login
+	for _, alg := range []int{coseES256, coseEdDSA, coseRS256} {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, struct {
+			Type string `json:"type"`
+			Alg  int    `json:"alg"`
+		}{"public-key", alg})
+	}
+	opts.ExcludeCredentials = []PasskeyDescriptor{}
+	for _, cred := range creds {
+		opts.ExcludeCredentials = append(opts.ExcludeCredentials, PasskeyDescriptor{Type: "public-key", ID: cred.ID})
+	}
+	opts.AuthenticatorSelection.ResidentKey = "required"
+	opts.AuthenticatorSelection.UserVerification = w.userVerification()
+	return opts, nil
+}
+
+func (t *Profile) FinishPasskeyRegistration(name string, resp *PasskeyAttestation) (*WebAuthnCredential, error) {
+	w := t.cfg.webAuthn
+	if w == nil {
+		return nil, ErrWebAuthnDisabled
+	}
+
+	rawClientData, err := decodeBase64URL(resp.ClientDataJSON)
+	if err != nil {
+		return nil, ErrWebAuthnInvalid
+	}
+	rawAttestation, err := decodeBase64URL(resp.AttestationObject)
+	if err != nil {
+		return nil, ErrWebAuthnInvalid
+	}
+
+	profileID, err := w.verifyClientData(rawClientData, "webauthn.create")
+	if err != nil {
+		return nil, err
+	}
+	if profileID != t.ProfileID {
+		return nil, ErrWebAuthnSessionNotFound
+	}
+
+	raw, _, err := cborDecode(rawAttestation)
+	if err != nil {
+		return nil, ErrWebAuthnInvalid
+	}
+	att, ok := raw.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrWebAuthnInvalid
+	}
+	authData, _ := att["authData"].([]byte)
+
+	ad, err := w.parseAuthData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if ad.credentialID == nil {
+		return nil, ErrWebAuthnInvalid
+	}
+	credID := base64.RawURLEncoding.EncodeToString(ad.credentialID)
+	if len(credID) > webAuthnMaxCredentialID || credID != strings.TrimRight(resp.ID, "=") {
+		return nil, ErrWebAuthnInvalid
+	}
+	if !coseSupported(ad.publicKey) {
+		return nil, ErrWebAuthnUnsupportedKey
+	}
+
+	cred := &WebAuthnCredential{
+		ID:        credID,
+		ProfileID: t.ProfileID,
+		PublicKey: ad.publicKey,
+		SignCount: ad.signCount,
+		Name:      name,
+		CreatedAt: time.Now(),
+	}
+	if err := t.cfg.st.NewWebAuthnCredential(cred); err != nil {
+		return nil, err
+	}
+	t.emit(EventPasskeyAdded, map[string]string{"credential": cred.ID, "name": name})
+	return cred, nil
+}
+
+func (t *Profile) Passkeys() ([]*WebAuthnCredential, error) {
+	return t.cfg.st.ListWebAuthnCredentials(t.ProfileID)
+}
+
+// DeletePasskey удаляет ключ доступа, подтверждается паролем
+func (t *Profile) DeletePasskey(password, credentialID string) error {
+	if err := t.checkPassword(password); err != nil {
+		return err
+	}
+	if err := t.cfg.st.DelWebAuthnCredential(credentialID, t.ProfileID); err != nil {
+		return err
+	}
+	t.emit(EventPasskeyRemoved, map[string]string{"credential": credentialID})
+	return nil
+}
+
+// BeginPasskeyLogin возвращает параметры для navigator.credentials.get.
+// Ключ выбирает аутентификатор, логин не нужен
+func (a *Auth) BeginPasskeyLogin() (*PasskeyRequestOptions, error) {
+	if err := a.webAuthnEnabled(); err != nil {
+		return nil, err
+	}
+	challenge, err := a.webAuthn.newSession(0)
+	if err != nil {
+		return nil, err
+	}
+	return &PasskeyRequestOptions{
+		Challenge:        challenge,
+		RPID:             a.webAuthn.cfg.RPID,
+		Timeout:          a.webAuthn.timeout * 1000,
+		UserVerification: a.webAuthn.userVerification(),
+		AllowCredentials: []PasskeyDescriptor{},
+	}, nil
+}
+
+// FinishPasskeyLogin проверяет подпись и счетчик подписей ключа и выдает токен.
+// Если у профиля включен второй фактор, возвращается MFARequiredError,
+// токен выдается после Auth.CompleteMFA
+func (a *Auth) FinishPasskeyLogin(resp *PasskeyAssertion, tokenLifeTimeSecond TokenLifeTime) (*Profile, string, error) {
+	if err := a.webAuthnEnabled(); err != nil {
+		return nil, "", err
+	}
+	cred, err := a.verifyAssertion(resp, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	prof, err := a.completeLogin(cred.ProfileID, "passkey")
+	if err != nil {
+		return nil, "", err
+	}
+	tok, err := a.NewToken(prof, tokenLifeTimeSecond)
+	if err != nil {
+		return nil, "", err
+	}
+	return prof, tok, nil
+}
+
+// verifyAssertion проверяет ответ navigator.credentials.get по сессии профиля profileID
+// (0 - вход без логина), подпись и счетчик подписей ключа
+func (a *Auth) verifyAssertion(resp *PasskeyAssertion, profileID ProfileID) (*WebAuthnCredential, error) {
+	w := a.webAuthn
+
+	rawClientData, err := decodeBase64URL(resp.ClientDataJSON)
+	if err != nil {
+		return nil, ErrWebAuthnInvalid
+	}
+	authData, err := decodeBase64URL(resp.AuthenticatorData)
+	if err != nil {
+		return nil, ErrWebAuthnInvalid
+	}
+	sig, err := decodeBase64URL(resp.Signature)
+	if err != nil {
+		return nil, ErrWebAuthnInvalid
+	}
+
+	sessionProfileID, err := w.verifyClientData(rawClientData, "webauthn.get")
+	if err != nil {
+		return nil, err
+	}
+	if sessionProfileID != profileID {
+		return nil, ErrWebAuthnSessionNotFound
+	}
+
+	cred, err := a.st.GetWebAuthnCredential(strings.TrimRight(resp.ID, "="))
+	if err != nil {
+		return nil, err
+	}
+	if profileID != 0 && cred.ProfileID != profileID {
+		return nil, ErrWebAuthnCredentialNotFound
+	}
+	if resp.UserHandle != "" && strings.TrimRight(resp.UserHandle, "=") != webAuthnUserID(cred.ProfileID) {
+		return nil, ErrWebAuthnInvalid
+	}
+
+	ad, err := w.parseAuthData(authData)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(rawClientData)
+	if err := coseVerify(cred.PublicKey, append(append([]byte(nil), authData...), clientDataHash[:]...), sig); err != nil {
+		return nil, err
+	}
+
+	//счетчик не растет - возможно, ключ скопирован
+	if (ad.signCount != 0 || cred.SignCount != 0) && ad.signCount <= cred.SignCount {
+		return nil, ErrWebAuthnSignCount
+	}
+	if err := a.st.UpdateWebAuthnSignCount(cred.ID, ad.signCount); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
+
+// passkeysForMFA ключи профиля, которые принимаются вторым фактором. Если первым
+// фактором был ключ доступа, он вторым фактором не считается
+func (a *Auth) passkeysForMFA(profileID ProfileID, first string) ([]*WebAuthnCredential, error) {
+	if a.webAuthn == nil || !a.webAuthn.cfg.SecondFactor || first == "passkey" {
+		return nil, nil
+	}
+	return a.st.ListWebAuthnCredentials(profileID)
+}
+
+// BeginPasskeyMFA возвращает параметры navigator.credentials.get для второго фактора,
+// подходят только ключи профиля, начавшего вход
+func (a *Auth) BeginPasskeyMFA(challenge MFAChallengeID) (*PasskeyRequestOptions, error) {
+	if err := a.webAuthnEnabled(); err != nil {
+		return nil, err
+	}
+	res, err := a.st.ReadMFAChallenge(challenge)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := a.passkeysForMFA(res.ProfileID, res.Method)
+	if err != nil {
+		return nil, err
+	}
+	if len(creds) == 0 {
+		return nil, ErrMFAMethodNotAvailable
+	}
+
+	session, err := a.webAuthn.newSession(res.ProfileID)
+	if err != nil {
+		return nil, err
+	}
+	opts := &PasskeyRequestOptions{
+		Challenge:        session,
+		RPID:             a.webAuthn.cfg.RPID,
+		Timeout:          a.webAuthn.timeout * 1000,
+		UserVerification: a.webAuthn.userVerification(),
+		AllowCredentials: []PasskeyDescriptor{},
+	}
+	for _, cred := range creds {
+		opts.AllowCredentials = append(opts.AllowCredentials, PasskeyDescriptor{Type: "public-key", ID: cred.ID})
+	}
+	return opts, nil
+}
+
+// CompleteMFAPasskey завершает вход ключом доступа вторым фактором. Неверная подпись
+// расходует попытку вызова, как неверный код в CompleteMFA
+func (a *Auth) CompleteMFAPasskey(challenge MFAChallengeID, resp *PasskeyAssertion) (*Profile, error) {
+	if err := a.webAuthnEnabled(); err != nil {
+		return nil, err
+	}
+	profileID, err := a.completeMFA(challenge, MFAPasskey, 0, a.verifyMFAPasskey(resp))
+	if err != nil {
+		return nil, err
+	}
+	return a.loginSucceeded(profileID, string(MFAPasskey))
+}
+
+// verifyMFAPasskey проверка ключа доступа вторым фактором для completeMFA
+func (a *Auth) verifyMFAPasskey(resp *PasskeyAssertion) func(res *ResultMFAChallenge) (bool, error) {
+	return func(res *ResultMFAChallenge) (bool, error) {
+		creds, err := a.passkeysForMFA(res.ProfileID, res.Method)
+		if err != nil {
+			return false, err
+		}
+		if len(creds) == 0 {
+			return false, ErrMFAMethodNotAvailable
+		}
+		if _, err := a.verifyAssertion(resp, res.ProfileID); err != nil {
+			if errors.Is(err, ErrWebAuthnInvalidSignature) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+}
@@ -0,0 +1,262 @@
+package authentication_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/v-grabko1999/authentication"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+// softAuthenticator программный аутентификатор с ключом ES256 и аттестацией none
+type softAuthenticator struct {
+	id         []byte
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+	userHandle string
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{id: id, key: key}
+}
+
+// cborHead кодирует заголовок элемента CBOR
+func cborHead(major byte, n int) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n < 256:
+		return []byte{major<<5 | 24, byte(n)}
+	}
+	return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+}
+
+func cborInt(n int) []byte {
+	if n < 0 {
+		return cborHead(1, -1-n)
+	}
+	return cborHead(0, n)
+}
+
+func cborBytes(bs []byte) []byte {
+	return append(cborHead(2, len(bs)), bs...)
+}
+
+func cborText(s string) []byte {
+	return append(cborHead(3, len(s)), s...)
+}
+
+func (s *softAuthenticator) coseKey() []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	s.key.X.FillBytes(x)
+	s.key.Y.FillBytes(y)
+
+	out := cborHead(5, 5)
+	out = append(append(out, cborInt(1)...), cborInt(2)...)  //kty: EC2
+	out = append(append(out, cborInt(3)...), cborInt(-7)...) //alg: ES256
+	out = append(append(out, cborInt(-1)...), cborInt(1)...) //crv: P-256
+	out = append(append(out, cborInt(-2)...), cborBytes(x)...)
+	out = append(append(out, cborInt(-3)...), cborBytes(y)...)
+	return out
+}
+
+func (s *softAuthenticator) authData(attested bool) []byte {
+	rpHash := sha256.Sum256([]byte(testRPID))
+	out := append([]byte(nil), rpHash[:]...)
+	flags := byte(0x01 | 0x04)
+	if attested {
+		flags |= 0x40
+	}
+	out = append(out, flags)
+	out = binary.BigEndian.AppendUint32(out, s.signCount)
+	if attested {
+		out = append(out, make([]byte, 16)...)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(s.id)))
+		out = append(out, s.id...)
+		out = append(out, s.coseKey()...)
+	}
+	return out
+}
+
+func clientDataJSON(ceremony, challenge, origin string) []byte {
+	bs, _ := json.Marshal(map[string]string{"type": ceremony, "challenge": challenge, "origin": origin})
+	return bs
+}
+
+func (s *softAuthenticator) create(opts *authentication.PasskeyCreationOptions) *authentication.PasskeyAttestation {
+	s.userHandle = opts.User.ID
+
+	att := cborHead(5, 3)
+	att = append(append(att, cborText("fmt")...), cborText("none")...)
+	att = append(append(att, cborText("attStmt")...), cborHead(5, 0)...)
+	att = append(append(att, cborText("authData")...), cborBytes(s.authData(true))...)
+
+	return &authentication.PasskeyAttestation{
+		ID:                base64.RawURLEncoding.EncodeToString(s.id),
+		ClientDataJSON:    base64.RawURLEncoding.EncodeToString(clientDataJSON("webauthn.create", opts.Challenge, testOrigin)),
+		AttestationObject: base64.RawURLEncoding.EncodeToString(att),
+	}
+}
+
+func (s *softAuthenticator) get(opts *authentication.PasskeyRequestOptions, origin string) *authentication.PasskeyAssertion {
+	s.signCount++
+	authData := s.authData(false)
+	cd := clientDataJSON("webauthn.get", opts.Challenge, origin)
+	cdHash := sha256.Sum256(cd)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), cdHash[:]...))
+	sig, _ := ecdsa.SignASN1(rand.Reader, s.key, digest[:])
+
+	return &authentication.PasskeyAssertion{
+		ID:                base64.RawURLEncoding.EncodeToString(s.id),
+		ClientDataJSON:    base64.RawURLEncoding.EncodeToString(cd),
+		AuthenticatorData: base64.RawURLEncoding.EncodeToString(authData),
+		Signature:         base64.RawURLEncoding.EncodeToString(sig),
+		UserHandle:        s.userHandle,
+	}
+}
+
+func testPasskey(dr authentication.DriverStorage, t *testing.T) {
+	auth := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:       dr,
+		EmailLifeTimeSecond: 60 * 60 * 24,
+		ProfilePasswordSalt: []byte("test password salt"),
+		TokenSecretKey:      []byte("token secret keu"),
+		WebAuthn: &authentication.WebAuthnConfig{
+			RPID:             testRPID,
+			RPName:           "Example",
+			Origins:          []string{testOrigin},
+			UserVerification: true,
+		},
+	})
+
+	profile, err := auth.Registration(regLogin, regEmail, regPass)
+	if err != nil {
+		t.Fatal("error profile registration", err)
+	}
+
+	authr := newSoftAuthenticator(t)
+	opts, err := profile.BeginPasskeyRegistration()
+	if err != nil {
+		t.Fatal("BeginPasskeyRegistration error: ", err)
+	}
+	resp := authr.create(opts)
+	if _, err := profile.FinishPasskeyRegistration("laptop", resp); err != nil {
+		t.Fatal("FinishPasskeyRegistration error: ", err)
+	}
+	//challenge одноразовый
+	if _, err := profile.FinishPasskeyRegistration("laptop", resp); !errors.Is(err, authentication.ErrWebAuthnSessionNotFound) {
+		t.Fatal("FinishPasskeyRegistration replay error: ", err)
+	}
+	if keys, err := profile.Passkeys(); err != nil || len(keys) != 1 || keys[0].Name != "laptop" {
+		t.Fatal("Passkeys error: ", err, keys)
+	}
+
+	reqOpts, err := auth.BeginPasskeyLogin()
+	if err != nil {
+		t.Fatal("BeginPasskeyLogin error: ", err)
+	}
+	prof, tok, err := auth.FinishPasskeyLogin(authr.get(reqOpts, testOrigin), 60)
+	if err != nil || prof.ProfileID != profile.ProfileID {
+		t.Fatal("FinishPasskeyLogin error: ", err)
+	}
+	if _, err := auth.ReadToken(tok); err != nil {
+		t.Fatal("ReadToken after passkey login error: ", err)
+	}
+
+	reqOpts, _ = auth.BeginPasskeyLogin()
+	if _, _, err := auth.FinishPasskeyLogin(authr.get(reqOpts, "https://evil.example"), 60); !errors.Is(err, authentication.ErrWebAuthnInvalid) {
+		t.Fatal("FinishPasskeyLogin foreign origin error: ", err)
+	}
+
+	//ключ доступа вторым фактором после пароля
+	mfaAuth := authentication.NewAuth(authentication.AuthConfig{
+		DriverStorage:             dr,
+		EmailLifeTimeSecond:       60 * 60 * 24,
+		ProfilePasswordSalt:       []byte("test password salt"),
+		TokenSecretKey:            []byte("token secret keu"),
+		EmailVerificationRequired: true,
+		WebAuthn: &authentication.WebAuthnConfig{
+			RPID:         testRPID,
+			RPName:       "Example",
+			Origins:      []string{testOrigin},
+			SecondFactor: true,
+		},
+	})
+	//вход ключом тоже требует подтвержденный E-MAIL
+	reqOpts, _ = mfaAuth.BeginPasskeyLogin()
+	if _, _, err := mfaAuth.FinishPasskeyLogin(authr.get(reqOpts, testOrigin), 60); !errors.Is(err, authentication.ErrEmailNotVerified) {
+		t.Fatal("FinishPasskeyLogin unverified email error: ", err)
+	}
+	if err := dr.SetEmailVerified(profile.ProfileID, true); err != nil {
+		t.Fatal("SetEmailVerified error: ", err)
+	}
+	//ключ, которым выполнен вход, вторым фактором не считается
+	reqOpts, _ = mfaAuth.BeginPasskeyLogin()
+	if _, _, err := mfaAuth.FinishPasskeyLogin(authr.get(reqOpts, testOrigin), 60); err != nil {
+		t.Fatal("FinishPasskeyLogin with passkey second factor error: ", err)
+	}
+
+	_, err = mfaAuth.Authentication(regLogin, regPass)
+	var mfa *authentication.MFARequiredError
+	if !errors.As(err, &mfa) || len(mfa.Methods) != 1 || mfa.Methods[0] != authentication.MFAPasskey {
+		t.Fatal("Authentication with passkey second factor error: ", err)
+	}
+	if _, err := mfaAuth.CompleteMFA(mfa.Challenge, authentication.MFAPasskey, ""); !errors.Is(err, authentication.ErrMFAMethodNotAvailable) {
+		t.Fatal("CompleteMFA passkey method error: ", err)
+	}
+	mfaOpts, err := mfaAuth.BeginPasskeyMFA(mfa.Challenge)
+	if err != nil || len(mfaOpts.AllowCredentials) != 1 {
+		t.Fatal("BeginPasskeyMFA error: ", err)
+	}
+	forged := authr.get(mfaOpts, testOrigin)
+	forged.Signature = base64.RawURLEncoding.EncodeToString([]byte("forged"))
+	if _, err := mfaAuth.CompleteMFAPasskey(mfa.Challenge, forged); !errors.Is(err, authentication.ErrMFACodeInvalid) {
+		t.Fatal("CompleteMFAPasskey forged signature error: ", err)
+	}
+	mfaOpts, _ = mfaAuth.BeginPasskeyMFA(mfa.Challenge)
+	mfaProfile, err := mfaAuth.CompleteMFAPasskey(mfa.Challenge, authr.get(mfaOpts, testOrigin))
+	if err != nil || mfaProfile.ProfileID != profile.ProfileID {
+		t.Fatal("CompleteMFAPasskey error: ", err)
+	}
+
+	//копия ключа с отставшим счетчиком
+	reqOpts, _ = auth.BeginPasskeyLogin()
+	authr.signCount = 0
+	if _, _, err := auth.FinishPasskeyLogin(authr.get(reqOpts, testOrigin), 60); !errors.Is(err, authentication.ErrWebAuthnSignCount) {
+		t.Fatal("FinishPasskeyLogin sign count error: ", err)
+	}
+
+	keys, _ := profile.Passkeys()
+	if err := profile.DeletePasskey("wrong-"+regPass, keys[0].ID); !errors.Is(err, authentication.ErrWrongPassword) {
+		t.Fatal("DeletePasskey wrong password error: ", err)
+	}
+	if err := profile.DeletePasskey(regPass, keys[0].ID); err != nil {
+		t.Fatal("DeletePasskey error: ", err)
+	}
+	reqOpts, _ = auth.BeginPasskeyLogin()
+	if _, _, err := auth.FinishPasskeyLogin(authr.get(reqOpts, testOrigin), 60); !errors.Is(err, authentication.ErrWebAuthnCredentialNotFound) {
+		t.Fatal("FinishPasskeyLogin deleted key error: ", err)
+	}
+
+	if err := profile.DeleteProfile(regPass); err != nil {
+		t.Fatal("DeleteProfile error: ", err)
+	}
+}